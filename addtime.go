@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// MaxAddTimeSeconds bounds a single addTime grant and AddTimeCooldown is
+// the minimum time between two grants of the same player.
+var MaxAddTimeSeconds = 60
+var AddTimeCooldown = 5 * time.Second
+
+const defaultAddTimeSeconds = 15
+
+type AddTimeMessage struct {
+	GameID string `json:"gameId"`
+	// Seconds granted to the opponent, defaults to 15.
+	Seconds int `json:"seconds"`
+}
+
+// HandleAddTime adds time to the opponent's clock of the granting player
+// and broadcasts the updated clocks.
+func HandleAddTime(wsMsg WebsocketMessage, client *Client) error {
+	var addTime AddTimeMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &addTime)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(addTime.GameID)
+	if !ok {
+		return SendError(client, addTime.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return SendError(client, addTime.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	seconds := addTime.Seconds
+	if seconds == 0 {
+		seconds = defaultAddTimeSeconds
+	}
+
+	if seconds < 0 || seconds > MaxAddTimeSeconds {
+		return SendError(client, addTime.GameID, ErrorCodeInvalidAmount, "Invalid amount of time")
+	}
+
+	game.mu.Lock()
+
+	if game.Clock == nil {
+		game.mu.Unlock()
+		return SendError(client, addTime.GameID, ErrorCodeNoClock, "Game has no clock")
+	}
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, addTime.GameID, ErrorCodeGameOver, "Game is over")
+	}
+
+	now := time.Now()
+	last := game.WhiteAddedTimeAt
+	if color == chess.Black {
+		last = game.BlackAddedTimeAt
+	}
+
+	if now.Sub(last) < AddTimeCooldown {
+		game.mu.Unlock()
+		return SendError(client, addTime.GameID, ErrorCodeRateLimited, "Adding time too often")
+	}
+
+	if color == chess.White {
+		game.WhiteAddedTimeAt = now
+	} else {
+		game.BlackAddedTimeAt = now
+	}
+
+	opponent := color.Other()
+	game.Clock.SetRemaining(opponent, game.Clock.Remaining(opponent)+int64(seconds)*1000)
+
+	data, err := GenerateClockMessage(addTime.GameID, game.Clock)
+	game.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = SaveGame(addTime.GameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastToGame(addTime.GameID, data)
+
+	return nil
+}
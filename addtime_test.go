@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestAddTimeGrantsOpponentTime(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		InitialSeconds: 300,
+	})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("addTime", AddTimeMessage{GameID: "game-1", Seconds: 30})
+
+	for _, client := range []*testConn{alice, bob} {
+		var clock ClockMessage
+		client.expect("clock", &clock)
+		if clock.BlackMillis != 330_000 {
+			t.Errorf("black has %dms, want 330000", clock.BlackMillis)
+		}
+		if clock.WhiteMillis != 300_000 {
+			t.Errorf("white has %dms, want the unchanged 300000", clock.WhiteMillis)
+		}
+	}
+}
+
+func TestAddTimeIsBounded(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		InitialSeconds: 300,
+	})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	var errMsg ErrorMessage
+	alice.send("addTime", AddTimeMessage{GameID: "game-1", Seconds: MaxAddTimeSeconds + 1})
+	alice.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeInvalidAmount {
+		t.Fatalf("error code %q, want %s", errMsg.Code, ErrorCodeInvalidAmount)
+	}
+
+	// the cooldown starts with the first accepted grant
+	alice.send("addTime", AddTimeMessage{GameID: "game-1", Seconds: 10})
+	alice.expect("clock")
+	alice.send("addTime", AddTimeMessage{GameID: "game-1", Seconds: 10})
+	alice.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeRateLimited {
+		t.Fatalf("error code %q, want %s", errMsg.Code, ErrorCodeRateLimited)
+	}
+
+	game, _ := GetGame("game-1")
+	game.mu.RLock()
+	black := game.Clock.BlackMillis
+	game.mu.RUnlock()
+	if black != 310_000 {
+		t.Errorf("black has %dms, want 310000", black)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth guards the admin routes with the ADMIN_TOKEN bearer token.
+// Without a configured token the admin routes are disabled.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AdminToken == "" {
+			c.AbortWithStatusJSON(403, gin.H{"message": "Admin API disabled"})
+			return
+		}
+
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{"message": "Unauthorized"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ClearGames removes every game, tells all connected clients about it and
+// persists the empty store. It returns the number of removed games.
+func ClearGames() (int, error) {
+	gamesMu.Lock()
+	removed := len(games) + len(storedIndex)
+
+	for _, game := range games {
+		stopEvictTimer(game)
+	}
+
+	games = make(map[string]*Game)
+	storedIndex = make(StoredGames)
+	indexRecords = make(map[string]GameRecord)
+	unloadedGames = make(map[string]bool)
+	gamesMu.Unlock()
+
+	data, err := json.Marshal(WebsocketMessage{
+		Type:    "gamesCleared",
+		Payload: "",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	clientsMu.Lock()
+	gameClients = make(map[string][]*Client)
+	for _, client := range connectedClients {
+		detachClientLocked(client)
+
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "client_id", client.ID, "error", err)
+		}
+	}
+	clientsMu.Unlock()
+
+	return removed, SaveGames()
+}
+
+// StateArchive is the format of GET /admin/export and POST /admin/import.
+// Games use the same serialization as the stored game files.
+type StateArchive struct {
+	Version    int         `json:"version"`
+	ExportedAt time.Time   `json:"exportedAt"`
+	Games      StoredGames `json:"games"`
+}
+
+func ExportState(now time.Time) (StateArchive, error) {
+	stored, err := SnapshotGames()
+	if err != nil {
+		return StateArchive{}, err
+	}
+
+	return StateArchive{
+		Version:    StoredGameVersion,
+		ExportedAt: now,
+		Games:      stored,
+	}, nil
+}
+
+// ImportState restores the games of an archive. With replace all existing
+// games are removed first, otherwise imported games are merged in and
+// overwrite games with the same id. It returns the number of imported
+// games.
+func ImportState(archive StateArchive, replace bool) (int, error) {
+	restored := make(map[string]*Game, len(archive.Games))
+	indexed := make(StoredGames)
+	records := make(map[string]GameRecord)
+
+	// restore everything before touching the live state, so a broken
+	// archive changes nothing
+	for id, storedGame := range archive.Games {
+		if !ValidGameID(id) {
+			return 0, fmt.Errorf("game %s: %w", id, ErrInvalidGameID)
+		}
+
+		storedGame, err := MigrateStoredGame(storedGame)
+		if err != nil {
+			return 0, fmt.Errorf("game %s: %w", id, err)
+		}
+
+		game, err := RestoreGame(id, storedGame)
+		if err != nil {
+			return 0, fmt.Errorf("game %s: %w", id, err)
+		}
+
+		if LazyLoadGames {
+			stopEvictTimer(game)
+			indexed[id] = storedGame
+			records[id] = RecordGame(game)
+		} else {
+			restored[id] = game
+		}
+	}
+
+	if replace {
+		_, err := ClearGames()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	gamesMu.Lock()
+	for id, game := range restored {
+		if existing, ok := games[id]; ok {
+			stopEvictTimer(existing)
+		}
+		games[id] = game
+	}
+	for id, storedGame := range indexed {
+		if existing, ok := games[id]; ok {
+			stopEvictTimer(existing)
+		}
+		delete(games, id)
+		indexGame(id, storedGame, records[id])
+	}
+	gamesMu.Unlock()
+
+	return len(archive.Games), SaveGames()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// doAdminRequest sends a request to an admin route with the bearer token.
+func doAdminRequest(t *testing.T, method string, path string, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewRouter().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func setAdminToken(t *testing.T, token string) {
+	t.Helper()
+
+	previous := AdminToken
+	AdminToken = token
+	t.Cleanup(func() { AdminToken = previous })
+}
+
+func TestClearGamesRemovesAllGamesAndNotifiesClients(t *testing.T) {
+	resetState(t)
+	setAdminToken(t, "secret")
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+	newTestGame(t, "game-2", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+	err := SaveGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	recorder := doAdminRequest(t, "DELETE", "/admin/games?confirm=true", "secret", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Removed int `json:"removed"`
+	}
+	decodeJSON(t, recorder, &response)
+	if response.Removed != 2 {
+		t.Errorf("removed %d games, want 2", response.Removed)
+	}
+
+	alice.expect("gamesCleared")
+
+	gamesMu.RLock()
+	remaining := len(games) + len(storedIndex)
+	gamesMu.RUnlock()
+	if remaining != 0 {
+		t.Errorf("%d games left after clearing", remaining)
+	}
+
+	for _, id := range []string{"game-1", "game-2"} {
+		if _, err := os.Stat(gamePath(id)); !os.IsNotExist(err) {
+			t.Errorf("stored file of %s still exists: %v", id, err)
+		}
+	}
+}
+
+func TestClearGamesRequiresConfirmation(t *testing.T) {
+	resetState(t)
+	setAdminToken(t, "secret")
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	recorder := doAdminRequest(t, "DELETE", "/admin/games", "secret", nil)
+	if recorder.Code != 400 {
+		t.Errorf("status %d without confirmation, want 400", recorder.Code)
+	}
+
+	recorder = doAdminRequest(t, "DELETE", "/admin/games?confirm=true", "wrong", nil)
+	if recorder.Code != 401 {
+		t.Errorf("status %d with a wrong token, want 401", recorder.Code)
+	}
+
+	gamesMu.RLock()
+	_, ok := games["game-1"]
+	gamesMu.RUnlock()
+	if !ok {
+		t.Error("game removed without confirmation")
+	}
+}
+
+// storedGames returns the persisted form of every game.
+func storedGames(t *testing.T) StoredGames {
+	t.Helper()
+
+	stored, err := SnapshotGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return stored
+}
+
+func TestExportImportRestoresGames(t *testing.T) {
+	resetState(t)
+	setAdminToken(t, "secret")
+
+	game := newTestGame(t, "game-1", CreateGameRequest{InitialSeconds: 300})
+	playMoves(t, "game-1", game, "e2e4", "e7e5", "g1f3")
+	finished := newTestGame(t, "game-2", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+	playMoves(t, "game-2", finished, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	before := storedGames(t)
+
+	recorder := doAdminRequest(t, "GET", "/admin/export", "secret", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("export status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	archive := recorder.Body.Bytes()
+
+	_, err := ClearGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(storedGames(t)) != 0 {
+		t.Fatal("games left after clearing")
+	}
+
+	recorder = doAdminRequest(t, "POST", "/admin/import", "secret", archive)
+	if recorder.Code != 200 {
+		t.Fatalf("import status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Imported int `json:"imported"`
+	}
+	decodeJSON(t, recorder, &response)
+	if response.Imported != 2 {
+		t.Errorf("imported %d games, want 2", response.Imported)
+	}
+
+	// compared in their encoded form, which drops the monotonic clock
+	// readings of the timestamps
+	want, err := json.Marshal(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(storedGames(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("restored games differ:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestImportMergeAndReplace(t *testing.T) {
+	resetState(t)
+	setAdminToken(t, "secret")
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	recorder := doAdminRequest(t, "GET", "/admin/export", "secret", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("export status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	archive := recorder.Body.Bytes()
+
+	_, err := ClearGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestGame(t, "game-2", CreateGameRequest{})
+
+	recorder = doAdminRequest(t, "POST", "/admin/import", "secret", archive)
+	if recorder.Code != 200 {
+		t.Fatalf("merge status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if stored := storedGames(t); len(stored) != 2 {
+		t.Fatalf("%d games after merging, want 2", len(stored))
+	}
+
+	recorder = doAdminRequest(t, "POST", "/admin/import?mode=replace", "secret", archive)
+	if recorder.Code != 200 {
+		t.Fatalf("replace status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	stored := storedGames(t)
+	if _, ok := stored["game-1"]; !ok || len(stored) != 1 {
+		t.Fatalf("games %v after replacing, want only game-1", stored)
+	}
+
+	recorder = doAdminRequest(t, "POST", "/admin/import", "wrong", archive)
+	if recorder.Code != 401 {
+		t.Errorf("import with a wrong token: status %d, want 401", recorder.Code)
+	}
+}
+
+func TestClearGamesResetsSpectators(t *testing.T) {
+	resetState(t)
+	setAdminToken(t, "secret")
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	carol := dialWS(t, server, "id=carol")
+	carol.send("spectate", SpectateMessage{GameID: "game-1"})
+	carol.expect("players")
+
+	recorder := doAdminRequest(t, "DELETE", "/admin/games?confirm=true", "secret", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	carol.expect("gamesCleared")
+
+	// carol plays the next game and moves without joining it first, a
+	// spectator flag left over from the cleared game would reject that
+	newTestGame(t, "game-2", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+
+	carol.send("move", MoveMessage{GameID: "game-2", Move: "e2e4"})
+	carol.expect("moveAck")
+}
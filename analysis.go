@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+type AnalysisRequest struct {
+	Fen string `json:"fen"`
+}
+
+type AnalysisMove struct {
+	UCI string `json:"uci"`
+	SAN string `json:"san"`
+}
+
+type CastlingAnalysis struct {
+	// Rights are the castling rights from the FEN, Legal the castling
+	// moves that can be played right now.
+	Rights map[string]bool `json:"rights"`
+	Legal  []string        `json:"legal"`
+}
+
+type EnPassantAnalysis struct {
+	Square string `json:"square"`
+	Legal  bool   `json:"legal"`
+}
+
+type PositionAnalysis struct {
+	Fen                  string            `json:"fen"`
+	Turn                 string            `json:"turn"`
+	Moves                []AnalysisMove    `json:"moves"`
+	InCheck              bool              `json:"inCheck"`
+	IsCheckmate          bool              `json:"isCheckmate"`
+	IsStalemate          bool              `json:"isStalemate"`
+	InsufficientMaterial bool              `json:"insufficientMaterial"`
+	Castling             CastlingAnalysis  `json:"castling"`
+	EnPassant            EnPassantAnalysis `json:"enPassant"`
+}
+
+// AnalyzeFEN parses a FEN and reports everything a client needs to show
+// the position without creating a game.
+func AnalyzeFEN(fenStr string) (*PositionAnalysis, error) {
+	fen, err := chess.FEN(fenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	game := chess.NewGame(fen)
+	pos := game.Position()
+	turn := pos.Turn()
+
+	analysis := &PositionAnalysis{
+		Fen:                  pos.String(),
+		Turn:                 ColorCode(turn),
+		Moves:                make([]AnalysisMove, 0),
+		InCheck:              IsInCheck(pos.Board(), turn),
+		IsCheckmate:          pos.Status() == chess.Checkmate,
+		IsStalemate:          pos.Status() == chess.Stalemate,
+		InsufficientMaterial: game.Method() == chess.InsufficientMaterial,
+		Castling: CastlingAnalysis{
+			Rights: map[string]bool{
+				"K": pos.CastleRights().CanCastle(chess.White, chess.KingSide),
+				"Q": pos.CastleRights().CanCastle(chess.White, chess.QueenSide),
+				"k": pos.CastleRights().CanCastle(chess.Black, chess.KingSide),
+				"q": pos.CastleRights().CanCastle(chess.Black, chess.QueenSide),
+			},
+			Legal: make([]string, 0),
+		},
+	}
+
+	if pos.EnPassantSquare() != chess.NoSquare {
+		analysis.EnPassant.Square = pos.EnPassantSquare().String()
+	}
+
+	for _, m := range pos.ValidMoves() {
+		analysis.Moves = append(analysis.Moves, AnalysisMove{
+			UCI: m.String(),
+			SAN: chess.AlgebraicNotation{}.Encode(pos, m),
+		})
+
+		if m.HasTag(chess.KingSideCastle) || m.HasTag(chess.QueenSideCastle) {
+			analysis.Castling.Legal = append(analysis.Castling.Legal, m.String())
+		}
+
+		if m.HasTag(chess.EnPassant) {
+			analysis.EnPassant.Legal = true
+		}
+	}
+
+	return analysis, nil
+}
+
+// PositionHash returns a stable hash of the repetition-relevant part of a
+// position: the hex encoded SHA-256 of the first four X-FEN fields
+// (placement, side to move, castling rights, en passant square) joined by
+// single spaces. X-FEN only lists the en passant square when a capture is
+// actually possible, so transpositions hash the same.
+func PositionHash(pos *chess.Position) string {
+	fields := strings.Fields(pos.XFENString())
+	sum := sha256.Sum256([]byte(strings.Join(fields[:4], " ")))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func analyze(t *testing.T, fen string) PositionAnalysis {
+	t.Helper()
+
+	recorder := doRequest(t, "POST", "/analysis", AnalysisRequest{Fen: fen})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var analysis PositionAnalysis
+	decodeJSON(t, recorder, &analysis)
+
+	return analysis
+}
+
+func TestAnalysisNormalPosition(t *testing.T) {
+	resetState(t)
+
+	// black just played d7d5 next to the pawn on e5
+	analysis := analyze(t, "rnbqkbnr/ppp1pppp/8/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 3")
+
+	if analysis.Turn != ColorWhite {
+		t.Errorf("turn %q, want %q", analysis.Turn, ColorWhite)
+	}
+	if analysis.InCheck || analysis.IsCheckmate || analysis.IsStalemate || analysis.InsufficientMaterial {
+		t.Errorf("flags %+v, want a quiet position", analysis)
+	}
+	if analysis.EnPassant.Square != "d6" || !analysis.EnPassant.Legal {
+		t.Errorf("en passant %+v, want a legal capture on d6", analysis.EnPassant)
+	}
+	if !analysis.Castling.Rights["K"] || !analysis.Castling.Rights["q"] || len(analysis.Castling.Legal) != 0 {
+		t.Errorf("castling %+v, want all rights and no legal castling", analysis.Castling)
+	}
+
+	found := false
+	for _, m := range analysis.Moves {
+		if m.UCI == "e5d6" {
+			found = m.SAN == "exd6"
+		}
+	}
+	if !found {
+		t.Errorf("moves %v miss e5d6 as exd6", analysis.Moves)
+	}
+	if len(analysis.Moves) != 31 {
+		t.Errorf("%d legal moves, want 31", len(analysis.Moves))
+	}
+}
+
+func TestAnalysisCheckmate(t *testing.T) {
+	resetState(t)
+
+	analysis := analyze(t, "rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3")
+
+	if !analysis.InCheck || !analysis.IsCheckmate || analysis.IsStalemate {
+		t.Errorf("flags %+v, want checkmate", analysis)
+	}
+	if len(analysis.Moves) != 0 {
+		t.Errorf("moves %v, want none", analysis.Moves)
+	}
+}
+
+func TestAnalysisStalemate(t *testing.T) {
+	resetState(t)
+
+	analysis := analyze(t, "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1")
+
+	if analysis.InCheck || analysis.IsCheckmate || !analysis.IsStalemate {
+		t.Errorf("flags %+v, want stalemate", analysis)
+	}
+	if analysis.Turn != ColorBlack {
+		t.Errorf("turn %q, want %q", analysis.Turn, ColorBlack)
+	}
+}
+
+func TestAnalysisRejectsInvalidFEN(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "POST", "/analysis", AnalysisRequest{Fen: "not a fen"})
+	if recorder.Code != 400 {
+		t.Errorf("status %d, want 400", recorder.Code)
+	}
+}
+
+func positionHash(t *testing.T, id string) string {
+	t.Helper()
+
+	recorder := doRequest(t, "GET", "/game/"+id+"/hash", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Hash string `json:"hash"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	return response.Hash
+}
+
+func TestPositionHashTranspositions(t *testing.T) {
+	resetState(t)
+
+	first := newTestGame(t, "first", CreateGameRequest{})
+	playMoves(t, "first", first, "g1f3", "g8f6", "b1c3")
+
+	second := newTestGame(t, "second", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+	playMoves(t, "second", second, "b1c3", "g8f6", "g1f3")
+
+	other := newTestGame(t, "other", CreateGameRequest{Player1: "erin", Player2: "frank", PreferredColor: ColorWhite})
+	playMoves(t, "other", other, "b1c3", "g8f6", "g1h3")
+
+	if positionHash(t, "first") != positionHash(t, "second") {
+		t.Error("transposed positions hash differently")
+	}
+	if positionHash(t, "first") == positionHash(t, "other") {
+		t.Error("distinct positions share a hash")
+	}
+
+	// the documented scheme: SHA-256 of the first four FEN fields
+	sum := sha256.Sum256([]byte("rnbqkb1r/pppppppp/5n2/8/8/2N2N2/PPPPPPPP/R1BQKB1R b KQkq -"))
+	if want := hex.EncodeToString(sum[:]); positionHash(t, "first") != want {
+		t.Errorf("hash %s, want %s", positionHash(t, "first"), want)
+	}
+}
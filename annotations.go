@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// Annotation is a comment and/or numeric annotation glyph attached to a
+// move of the main line.
+type Annotation struct {
+	NAG     int    `json:"nag,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type SetAnnotationRequest struct {
+	// NAG is a move symbol like "!" or "?!" or a numeric glyph like "$14".
+	NAG     string `json:"nag"`
+	Comment string `json:"comment"`
+}
+
+// nagSymbols maps the common move evaluation symbols to their NAG.
+var nagSymbols = map[string]int{
+	"!":  1,
+	"?":  2,
+	"!!": 3,
+	"??": 4,
+	"!?": 5,
+	"?!": 6,
+}
+
+const maxNAG = 255
+
+func ParseNAG(str string) (int, error) {
+	if str == "" {
+		return 0, nil
+	}
+
+	if nag, ok := nagSymbols[str]; ok {
+		return nag, nil
+	}
+
+	nag, err := strconv.Atoi(strings.TrimPrefix(str, "$"))
+	if err != nil || nag < 1 || nag > maxNAG {
+		return 0, errors.New("Invalid NAG")
+	}
+
+	return nag, nil
+}
+
+// ValidateAnnotationPly checks that ply refers to a move of the main line.
+// Ply 1 is white's first move.
+func ValidateAnnotationPly(game *Game, ply int) error {
+	if ply < 1 || ply > len(game.Game.Moves()) {
+		return errors.New("Ply out of range")
+	}
+
+	return nil
+}
+
+// MoveNumber returns the full move number of a position, read from its FEN.
+func MoveNumber(pos *chess.Position) int {
+	fields := strings.Fields(pos.String())
+
+	number, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return 1
+	}
+
+	return number
+}
+
+// PGNTagRoster returns the seven standard tag pairs of a game, with "?"
+// for the values the server doesn't know.
+func PGNTagRoster(game *Game) []chess.TagPair {
+	white := game.WhitePlayerId
+	if white == "" {
+		white = "?"
+	}
+
+	black := game.BlackPlayerId
+	if black == "" {
+		black = "?"
+	}
+
+	return []chess.TagPair{
+		{Key: "Event", Value: "?"},
+		{Key: "Site", Value: "?"},
+		{Key: "Date", Value: "????.??.??"},
+		{Key: "Round", Value: "?"},
+		{Key: "White", Value: white},
+		{Key: "Black", Value: black},
+		{Key: "Result", Value: string(game.Game.Outcome())},
+	}
+}
+
+// pgnTagEscaper escapes tag values, which are quoted strings in PGN. Player
+// ids are chosen by the clients and may contain quotes.
+var pgnTagEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// ExportPGN encodes the main line in SAN together with the standard tag
+// roster, the game's own tag pairs and its annotations.
+func ExportPGN(game *Game) string {
+	var sb strings.Builder
+
+	roster := PGNTagRoster(game)
+	inRoster := make(map[string]bool)
+
+	for _, tag := range roster {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", tag.Key, pgnTagEscaper.Replace(tag.Value))
+		inRoster[tag.Key] = true
+	}
+
+	for _, tag := range game.Game.TagPairs() {
+		if !inRoster[tag.Key] {
+			fmt.Fprintf(&sb, "[%s \"%s\"]\n", tag.Key, pgnTagEscaper.Replace(tag.Value))
+		}
+	}
+	sb.WriteString("\n")
+
+	positions := game.Game.Positions()
+	notation := chess.AlgebraicNotation{}
+
+	for i, m := range game.Game.Moves() {
+		pos := positions[i]
+
+		if pos.Turn() == chess.White {
+			fmt.Fprintf(&sb, "%d. ", MoveNumber(pos))
+		} else if i == 0 {
+			fmt.Fprintf(&sb, "%d... ", MoveNumber(pos))
+		}
+
+		sb.WriteString(notation.Encode(pos, m))
+
+		if annotation, ok := game.Annotations[i+1]; ok {
+			if annotation.NAG != 0 {
+				fmt.Fprintf(&sb, " $%d", annotation.NAG)
+			}
+			if annotation.Comment != "" {
+				// braces would end the comment early
+				comment := strings.NewReplacer("{", "(", "}", ")").Replace(annotation.Comment)
+				fmt.Fprintf(&sb, " { %s }", comment)
+			}
+		}
+
+		sb.WriteString(" ")
+	}
+
+	sb.WriteString(string(game.Game.Outcome()))
+
+	return sb.String()
+}
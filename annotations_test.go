@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAnnotationsAppearInPGN(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "study", CreateGameRequest{})
+	playMoves(t, "study", game, "e2e4", "e7e5", "g1f3")
+
+	recorder := doRequest(t, "PUT", "/game/study/annotations/3", SetAnnotationRequest{
+		NAG:     "!",
+		Comment: "develops {and} attacks",
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = doRequest(t, "PUT", "/game/study/annotations/2", SetAnnotationRequest{NAG: "?!"})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	pgn := doRequest(t, "GET", "/game/study/pgn", nil).Body.String()
+	if !strings.Contains(pgn, "1. e4 e5 $6 2. Nf3 $1 { develops (and) attacks } *") {
+		t.Errorf("PGN misses the annotations:\n%s", pgn)
+	}
+
+	recorder = doRequest(t, "DELETE", "/game/study/annotations/2", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var annotations map[int]Annotation
+	decodeJSON(t, recorder, &annotations)
+	if _, ok := annotations[2]; ok || len(annotations) != 1 {
+		t.Errorf("annotations after deleting %v", annotations)
+	}
+
+	// annotations survive save and load
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedGame.Annotations[3].NAG != 1 {
+		t.Errorf("stored annotations %v", storedGame.Annotations)
+	}
+}
+
+func TestAnnotationPlyMustExist(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "study", CreateGameRequest{})
+	playMoves(t, "study", game, "e2e4")
+
+	for _, ply := range []string{"0", "2", "x"} {
+		recorder := doRequest(t, "PUT", "/game/study/annotations/"+ply, SetAnnotationRequest{NAG: "!"})
+		if recorder.Code != 400 {
+			t.Errorf("ply %s: status %d, want 400", ply, recorder.Code)
+		}
+	}
+}
+
+func TestConcurrentAnnotations(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "study", CreateGameRequest{})
+	playMoves(t, "study", game, "e2e4", "e7e5", "g1f3", "b8c6")
+
+	var wg sync.WaitGroup
+	for _, ply := range []string{"1", "2", "3", "4"} {
+		wg.Add(1)
+		go func(ply string) {
+			defer wg.Done()
+
+			recorder := doRequest(t, "PUT", "/game/study/annotations/"+ply, SetAnnotationRequest{Comment: "ply " + ply})
+			if recorder.Code != 200 {
+				t.Errorf("ply %s: status %d", ply, recorder.Code)
+			}
+		}(ply)
+	}
+
+	// a move and an export race with the annotations
+	playMoves(t, "study", game, "f1b5")
+	doRequest(t, "GET", "/game/study/pgn", nil)
+
+	wg.Wait()
+
+	game.mu.Lock()
+	count := len(game.Annotations)
+	game.mu.Unlock()
+	if count != 4 {
+		t.Errorf("%d annotations kept, want 4", count)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/notnil/chess"
+)
+
+var knightOffsets = [][2]int{
+	{1, 2}, {2, 1}, {2, -1}, {1, -2},
+	{-1, -2}, {-2, -1}, {-2, 1}, {-1, 2},
+}
+
+var kingOffsets = [][2]int{
+	{0, 1}, {1, 1}, {1, 0}, {1, -1},
+	{0, -1}, {-1, -1}, {-1, 0}, {-1, 1},
+}
+
+var diagonalDirections = [][2]int{{1, 1}, {1, -1}, {-1, -1}, {-1, 1}}
+var straightDirections = [][2]int{{0, 1}, {1, 0}, {0, -1}, {-1, 0}}
+
+func offsetSquare(sq chess.Square, df int, dr int) (chess.Square, bool) {
+	file := int(sq.File()) + df
+	rank := int(sq.Rank()) + dr
+
+	if file < 0 || file > 7 || rank < 0 || rank > 7 {
+		return chess.NoSquare, false
+	}
+
+	return chess.NewSquare(chess.File(file), chess.Rank(rank)), true
+}
+
+// AttackersOf returns the squares of all pieces of the given color that
+// attack sq on the given board.
+func AttackersOf(board *chess.Board, sq chess.Square, by chess.Color) []chess.Square {
+	attackers := make([]chess.Square, 0)
+
+	for _, offset := range knightOffsets {
+		from, ok := offsetSquare(sq, offset[0], offset[1])
+		if ok && board.Piece(from) == chess.NewPiece(chess.Knight, by) {
+			attackers = append(attackers, from)
+		}
+	}
+
+	for _, offset := range kingOffsets {
+		from, ok := offsetSquare(sq, offset[0], offset[1])
+		if ok && board.Piece(from) == chess.NewPiece(chess.King, by) {
+			attackers = append(attackers, from)
+		}
+	}
+
+	// pawns attack diagonally forward, so look one rank behind the target
+	pawnRank := -1
+	if by == chess.Black {
+		pawnRank = 1
+	}
+
+	for _, df := range []int{-1, 1} {
+		from, ok := offsetSquare(sq, df, pawnRank)
+		if ok && board.Piece(from) == chess.NewPiece(chess.Pawn, by) {
+			attackers = append(attackers, from)
+		}
+	}
+
+	sliders := []struct {
+		directions [][2]int
+		pieceType  chess.PieceType
+	}{
+		{diagonalDirections, chess.Bishop},
+		{straightDirections, chess.Rook},
+	}
+
+	for _, slider := range sliders {
+		for _, dir := range slider.directions {
+			from, ok := offsetSquare(sq, dir[0], dir[1])
+			for ok {
+				piece := board.Piece(from)
+				if piece != chess.NoPiece {
+					if piece.Color() == by && (piece.Type() == slider.pieceType || piece.Type() == chess.Queen) {
+						attackers = append(attackers, from)
+					}
+					break
+				}
+				from, ok = offsetSquare(from, dir[0], dir[1])
+			}
+		}
+	}
+
+	return attackers
+}
+
+func IsSquareAttacked(board *chess.Board, sq chess.Square, by chess.Color) bool {
+	return len(AttackersOf(board, sq, by)) > 0
+}
+
+func KingSquare(board *chess.Board, color chess.Color) chess.Square {
+	king := chess.NewPiece(chess.King, color)
+
+	for sq, piece := range board.SquareMap() {
+		if piece == king {
+			return sq
+		}
+	}
+
+	return chess.NoSquare
+}
+
+// IsInCheck reports whether the king of the given color is attacked.
+func IsInCheck(board *chess.Board, color chess.Color) bool {
+	kingSq := KingSquare(board, color)
+	if kingSq == chess.NoSquare {
+		return false
+	}
+
+	return IsSquareAttacked(board, kingSq, color.Other())
+}
+
+// Pin describes a piece that is absolutely pinned to its own king.
+type Pin struct {
+	Square       string `json:"square"`
+	Piece        string `json:"piece"`
+	PinnedBy     string `json:"pinnedBy"`
+	PinnedByType string `json:"pinnedByType"`
+}
+
+// Pins returns the pieces of the given color that may not leave their square
+// because doing so would expose their king to an enemy slider.
+func Pins(board *chess.Board, color chess.Color) []Pin {
+	pins := make([]Pin, 0)
+
+	kingSq := KingSquare(board, color)
+	if kingSq == chess.NoSquare {
+		return pins
+	}
+
+	before := make(map[chess.Square]bool)
+	for _, sq := range AttackersOf(board, kingSq, color.Other()) {
+		before[sq] = true
+	}
+
+	squares := board.SquareMap()
+
+	for sq, piece := range squares {
+		if piece.Color() != color || piece.Type() == chess.King {
+			continue
+		}
+
+		// remove the piece and look for a slider that now reaches the king
+		without := make(map[chess.Square]chess.Piece, len(squares))
+		for s, p := range squares {
+			if s != sq {
+				without[s] = p
+			}
+		}
+
+		for _, from := range AttackersOf(chess.NewBoard(without), kingSq, color.Other()) {
+			if before[from] {
+				continue
+			}
+
+			pinner := squares[from]
+			pins = append(pins, Pin{
+				Square:       sq.String(),
+				Piece:        piece.Type().String(),
+				PinnedBy:     from.String(),
+				PinnedByType: pinner.Type().String(),
+			})
+		}
+	}
+
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].Square < pins[j].Square
+	})
+
+	return pins
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheckEndpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		fen       string
+		inCheck   bool
+		king      string
+		attackers []string
+	}{
+		{"no check", StartingFEN, false, "e1", []string{}},
+		{"single check", "4k3/8/8/8/8/8/8/4R1K1 b - - 0 1", true, "e8", []string{"e1"}},
+		{"double check", "4k3/8/3N4/8/8/8/8/4R1K1 b - - 0 1", true, "e8", []string{"d6", "e1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+
+			newTestGame(t, "check", CreateGameRequest{StartingFen: test.fen})
+
+			recorder := doRequest(t, http.MethodGet, "/game/check/check", nil)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", recorder.Code)
+			}
+
+			var response struct {
+				InCheck    bool     `json:"inCheck"`
+				KingSquare string   `json:"kingSquare"`
+				Attackers  []string `json:"attackers"`
+			}
+			decodeJSON(t, recorder, &response)
+
+			sort.Strings(response.Attackers)
+			if response.InCheck != test.inCheck || response.KingSquare != test.king {
+				t.Errorf("inCheck %v on %s, want %v on %s", response.InCheck, response.KingSquare, test.inCheck, test.king)
+			}
+			if len(response.Attackers) != len(test.attackers) {
+				t.Fatalf("attackers %v, want %v", response.Attackers, test.attackers)
+			}
+			for i := range test.attackers {
+				if response.Attackers[i] != test.attackers[i] {
+					t.Fatalf("attackers %v, want %v", response.Attackers, test.attackers)
+				}
+			}
+		})
+	}
+}
+
+func TestPinsEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		pins []Pin
+	}{
+		{"no pins", StartingFEN, []Pin{}},
+		{"knight pinned by a bishop", "4k3/8/8/8/1b6/2N5/8/4K3 w - - 0 1", []Pin{
+			{Square: "c3", Piece: "n", PinnedBy: "b4", PinnedByType: "b"},
+		}},
+		// the second knight shields the first, so neither is pinned
+		{"shielded knights", "4k3/8/8/8/1b6/2N5/3N4/4K3 w - - 0 1", []Pin{}},
+		// only pieces of the side to move are reported
+		{"opponent's pin ignored", "4k3/4n3/8/8/8/8/8/4RK2 w - - 0 1", []Pin{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+
+			newTestGame(t, "pins", CreateGameRequest{StartingFen: test.fen})
+
+			recorder := doRequest(t, http.MethodGet, "/game/pins/pins", nil)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", recorder.Code)
+			}
+
+			var response struct {
+				Color string `json:"color"`
+				Pins  []Pin  `json:"pins"`
+			}
+			decodeJSON(t, recorder, &response)
+
+			if response.Color != ColorWhite {
+				t.Errorf("color = %q, want %q", response.Color, ColorWhite)
+			}
+			if !reflect.DeepEqual(response.Pins, test.pins) {
+				t.Errorf("pins = %+v, want %+v", response.Pins, test.pins)
+			}
+		})
+	}
+}
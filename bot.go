@@ -0,0 +1,249 @@
+package main
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// BotPlayerID is the player id of the built-in computer opponent. A game
+// created with it as one of the players gets its moves played by the
+// server.
+const BotPlayerID = "bot"
+
+const (
+	BotEasy   = "easy"
+	BotMedium = "medium"
+	BotHard   = "hard"
+)
+
+// BotMoveTimeout bounds how long the bot may think about a move.
+var BotMoveTimeout = 2 * time.Second
+
+type botLevel struct {
+	// depth is the search depth in half moves, 0 plays a random move
+	depth        int
+	pieceSquares bool
+}
+
+var botLevels = map[string]botLevel{
+	BotEasy:   {depth: 0},
+	BotMedium: {depth: 2},
+	BotHard:   {depth: 4, pieceSquares: true},
+}
+
+func IsValidBotDifficulty(difficulty string) bool {
+	_, ok := botLevels[difficulty]
+	return ok
+}
+
+// pieceSquareTables hold a bonus per square for each piece type, from
+// white's point of view with the eighth rank first. They reward
+// centralization and development and keep the king sheltered.
+var pieceSquareTables = map[chess.PieceType][64]int{
+	chess.Pawn: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	},
+	chess.Knight: {
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	},
+	chess.Bishop: {
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	},
+	chess.Rook: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	},
+	chess.Queen: {
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	},
+	chess.King: {
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	},
+}
+
+// EvaluatePieceSquares returns the material balance plus the piece-square
+// bonuses in centipawns from white's point of view.
+func EvaluatePieceSquares(board *chess.Board) int {
+	score := 0
+
+	for sq, piece := range board.SquareMap() {
+		file := int(sq.File())
+		rank := int(sq.Rank())
+
+		// the tables list the eighth rank first, black reads them mirrored
+		index := (7-rank)*8 + file
+		if piece.Color() == chess.Black {
+			index = rank*8 + file
+		}
+
+		value := pieceValues[piece.Type()] + pieceSquareTables[piece.Type()][index]
+		if piece.Color() == chess.White {
+			score += value
+		} else {
+			score -= value
+		}
+	}
+
+	return score
+}
+
+// ChooseBotMove picks a move for the given difficulty. Easy plays a random
+// legal move, the others the move with the best score after a search of
+// the level's depth. Of equally good moves the first one wins.
+func ChooseBotMove(pos *chess.Position, difficulty string, timeout time.Duration) *chess.Move {
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		return nil
+	}
+
+	level, ok := botLevels[difficulty]
+	if !ok {
+		level = botLevels[BotMedium]
+	}
+
+	if level.depth == 0 {
+		return moves[rand.IntN(len(moves))]
+	}
+
+	evaluate := EvaluateMaterial
+	if level.pieceSquares {
+		evaluate = EvaluatePieceSquares
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	var best *chess.Move
+	bestScore := -MateScore - 1
+
+	for _, m := range moves {
+		score := -negamax(pos.Update(m), level.depth-1, -MateScore-1, MateScore+1, deadline, evaluate)
+		if best == nil || score > bestScore {
+			best = m
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// IsBotsTurn reports whether the bot has to move in the game.
+func IsBotsTurn(game *Game) bool {
+	if game.Game.Outcome() != chess.NoOutcome || WaitingForReady(game) {
+		return false
+	}
+
+	return PlayerIdForColor(game, game.Game.Position().Turn()) == BotPlayerID
+}
+
+// botClient submits the bot's moves through ApplyMove, which only looks
+// at the mover's id.
+var botClient = &Client{ID: BotPlayerID}
+
+// botSearches tracks running bot searches, so shutdown can wait for their
+// moves before writing the games.
+var botSearches sync.WaitGroup
+
+// PlayBotMove lets the bot move if it is its turn. The search runs in the
+// background without holding the game lock, the move is broadcast like
+// one submitted by a player.
+func PlayBotMove(gameID string, game *Game) {
+	game.mu.RLock()
+	if !IsBotsTurn(game) {
+		game.mu.RUnlock()
+		return
+	}
+
+	// positions are immutable, so the search can use this one unlocked
+	pos := game.Game.Position()
+	difficulty := game.BotDifficulty
+	sequence := game.Sequence
+	game.mu.RUnlock()
+
+	botSearches.Add(1)
+	go func() {
+		defer botSearches.Done()
+
+		err := playBotMove(gameID, game, pos, difficulty, sequence)
+		if err != nil {
+			slog.Error("bot move failed", "game_id", gameID, "error", err)
+		}
+	}()
+}
+
+// playBotMove searches a move in pos and plays it, unless the game moved
+// on from sequence in the meantime, e.g. by a takeback.
+func playBotMove(gameID string, game *Game, pos *chess.Position, difficulty string, sequence int) error {
+	m := ChooseBotMove(pos, difficulty, BotMoveTimeout)
+	if m == nil {
+		return nil
+	}
+
+	game.mu.Lock()
+
+	if game.Sequence != sequence {
+		game.mu.Unlock()
+		return nil
+	}
+
+	move := MoveMessage{
+		GameID: gameID,
+		Color:  ColorCode(pos.Turn()),
+		Move:   FormatMove(game, pos, m),
+	}
+
+	updates, err := applyMoveLocked(game, &move, botClient)
+	game.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	MarkDirty(gameID)
+	BroadcastMove(gameID, game, updates)
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func TestBotAnswersTheHumansMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        BotPlayerID,
+		PreferredColor: ColorWhite,
+		BotDifficulty:  BotMedium,
+	})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck")
+
+	var answer MoveAnswer
+	alice.expect("move", &answer)
+
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+
+	moves := game.Game.Moves()
+	if len(moves) != 2 || answer.LastMoveUci != moves[1].String() {
+		t.Fatalf("bot answered %q, game has moves %v", answer.LastMoveUci, moves)
+	}
+	if turn := game.Game.Position().Turn(); turn != chess.White {
+		t.Errorf("%s to move after the bot's answer, want white", turn)
+	}
+}
+
+func TestBotWaitsForTheHuman(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        BotPlayerID,
+		PreferredColor: ColorWhite,
+	})
+
+	PlayBotMove("game-1", game)
+	botSearches.Wait()
+
+	if moves := len(game.Game.Moves()); moves != 0 {
+		t.Errorf("bot played %d moves on the human's turn", moves)
+	}
+}
+
+func TestBotDropsMoveForChangedGame(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        BotPlayerID,
+		PreferredColor: ColorWhite,
+	})
+	playMoves(t, "game-1", game, "e2e4")
+
+	game.mu.RLock()
+	pos := game.Game.Position()
+	sequence := game.Sequence
+	game.mu.RUnlock()
+
+	// the game moves on while the bot thinks about pos
+	game.mu.Lock()
+	game.Sequence++
+	game.mu.Unlock()
+
+	err := playBotMove("game-1", game, pos, BotMedium, sequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moves := len(game.Game.Moves()); moves != 1 {
+		t.Errorf("bot played into a changed game, %d moves", moves)
+	}
+
+	err = playBotMove("game-1", game, pos, BotMedium, sequence+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moves := len(game.Game.Moves()); moves != 2 {
+		t.Errorf("bot didn't move in the current game, %d moves", moves)
+	}
+}
+
+func TestBotSearchesWithoutTheGameLock(t *testing.T) {
+	resetState(t)
+
+	previous := BotMoveTimeout
+	BotMoveTimeout = time.Second
+	t.Cleanup(func() {
+		botSearches.Wait()
+		BotMoveTimeout = previous
+	})
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        BotPlayerID,
+		PreferredColor: ColorWhite,
+		BotDifficulty:  BotHard,
+	})
+	playMoves(t, "game-1", game, "e2e4")
+
+	PlayBotMove("game-1", game)
+
+	// readers get the game while the bot is still searching
+	start := time.Now()
+	game.mu.Lock()
+	moves := len(game.Game.Moves())
+	game.mu.Unlock()
+
+	if moves != 1 && time.Since(start) > 50*time.Millisecond {
+		t.Errorf("waited %s for the game lock during the search", time.Since(start))
+	}
+}
+
+func TestBotPlaysLegalMoves(t *testing.T) {
+	fens := []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		// black's only move is g6
+		"rnbqkbnr/ppppp1pp/5p2/7Q/4P3/8/PPPP1PPP/RNB1KBNR b KQkq - 1 2",
+		"r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4",
+	}
+
+	for _, fen := range fens {
+		opt, err := chess.FEN(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := chess.NewGame(opt).Position()
+
+		for difficulty := range botLevels {
+			m := ChooseBotMove(pos, difficulty, 100*time.Millisecond)
+			if m == nil || !slices.ContainsFunc(pos.ValidMoves(), func(valid *chess.Move) bool {
+				return valid.String() == m.String()
+			}) {
+				t.Errorf("%s bot played %v in %s", difficulty, m, fen)
+			}
+		}
+	}
+}
+
+func TestBotDifficultyIsStoredOnTheGame(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "POST", "/game", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        BotPlayerID,
+		PreferredColor: ColorWhite,
+		BotDifficulty:  BotHard,
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response CreateGameResponse
+	decodeJSON(t, recorder, &response)
+
+	game, ok := GetGame(response.ID)
+	if !ok {
+		t.Fatal("game not found")
+	}
+	if game.BotDifficulty != BotHard {
+		t.Errorf("difficulty %q, want %q", game.BotDifficulty, BotHard)
+	}
+
+	recorder = doRequest(t, "POST", "/game", CreateGameRequest{
+		Player1:       "alice",
+		Player2:       BotPlayerID,
+		BotDifficulty: "impossible",
+	})
+	if recorder.Code != 400 {
+		t.Errorf("unknown difficulty: status %d, want 400", recorder.Code)
+	}
+}
+
+func TestBotDifficulties(t *testing.T) {
+	// the black knight can take the hanging queen
+	opt, err := chess.FEN("4k3/8/8/3Q4/8/2n5/8/4K3 b - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := chess.NewGame(opt).Position()
+
+	if m := ChooseBotMove(pos, BotHard, time.Second); m == nil || m.String() != "c3d5" {
+		t.Errorf("hard bot played %v, want c3d5", m)
+	}
+
+	played := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		m := ChooseBotMove(pos, BotEasy, time.Second)
+		if m == nil {
+			t.Fatal("easy bot found no move")
+		}
+		played[m.String()] = true
+	}
+	if len(played) < 2 {
+		t.Errorf("easy bot played only %v", played)
+	}
+}
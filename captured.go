@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/notnil/chess"
+)
+
+// capturedOrder is the canonical display order of captured pieces.
+var capturedOrder = []chess.PieceType{
+	chess.Pawn,
+	chess.Knight,
+	chess.Bishop,
+	chess.Rook,
+	chess.Queen,
+}
+
+type CapturedGroup struct {
+	Piece string `json:"piece"`
+	Count int    `json:"count"`
+}
+
+type CapturedPiecesMessage struct {
+	GameID string `json:"gameId"`
+	// White and Black list the pieces of that color that were taken.
+	White CapturedSide `json:"white"`
+	Black CapturedSide `json:"black"`
+}
+
+type CapturedSide struct {
+	Pieces []string        `json:"pieces"`
+	Groups []CapturedGroup `json:"groups"`
+	Count  int             `json:"count"`
+}
+
+// CapturedPiece returns the piece taken by m in pos, or chess.NoPiece for
+// a quiet move. It looks at the board rather than the move tags so it
+// also works for games restored from PGN, and handles en passant.
+func CapturedPiece(pos *chess.Position, m *chess.Move) chess.Piece {
+	board := pos.Board()
+
+	target := board.Piece(m.S2())
+	if target != chess.NoPiece {
+		return target
+	}
+
+	mover := board.Piece(m.S1())
+	if mover.Type() == chess.Pawn && m.S1().File() != m.S2().File() {
+		return chess.NewPiece(chess.Pawn, mover.Color().Other())
+	}
+
+	return chess.NoPiece
+}
+
+// CapturedPieces returns the pieces of each color that have been
+// captured so far, in the order they were taken. Promoted pieces count
+// as what they were promoted to.
+func CapturedPieces(game *chess.Game) map[chess.Color][]chess.PieceType {
+	captured := map[chess.Color][]chess.PieceType{
+		chess.White: {},
+		chess.Black: {},
+	}
+
+	positions := game.Positions()
+	for i, m := range game.Moves() {
+		piece := CapturedPiece(positions[i], m)
+		if piece != chess.NoPiece {
+			captured[piece.Color()] = append(captured[piece.Color()], piece.Type())
+		}
+	}
+
+	return captured
+}
+
+// GroupCapturedPieces groups captured pieces by type and sorts them in
+// capturedOrder.
+func GroupCapturedPieces(pieces []chess.PieceType) CapturedSide {
+	counts := make(map[chess.PieceType]int)
+	for _, pieceType := range pieces {
+		counts[pieceType]++
+	}
+
+	side := CapturedSide{
+		Pieces: make([]string, 0, len(pieces)),
+		Groups: make([]CapturedGroup, 0),
+		Count:  len(pieces),
+	}
+
+	for _, pieceType := range capturedOrder {
+		count := counts[pieceType]
+		if count == 0 {
+			continue
+		}
+
+		side.Groups = append(side.Groups, CapturedGroup{
+			Piece: pieceType.String(),
+			Count: count,
+		})
+
+		for i := 0; i < count; i++ {
+			side.Pieces = append(side.Pieces, pieceType.String())
+		}
+	}
+
+	return side
+}
+
+func GenerateCapturedPiecesMessage(gameID string, game *Game) ([]byte, error) {
+	captured := CapturedPieces(game.Game)
+
+	capturedMsg := CapturedPiecesMessage{
+		GameID: gameID,
+		White:  GroupCapturedPieces(captured[chess.White]),
+		Black:  GroupCapturedPieces(captured[chess.Black]),
+	}
+
+	data, err := json.Marshal(capturedMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := WebsocketMessage{
+		Type:    "capturedPieces",
+		Payload: string(data),
+	}
+
+	return json.Marshal(msg)
+}
+
+type GetCapturedMessage struct {
+	GameID string `json:"gameId"`
+}
+
+// HandleGetCaptured sends the current captured pieces of a game to the
+// requesting client without waiting for the next capture.
+func HandleGetCaptured(wsMsg WebsocketMessage, client *Client) error {
+	var getCaptured GetCapturedMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &getCaptured)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(getCaptured.GameID)
+	if !ok {
+		return SendError(client, getCaptured.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	game.mu.RLock()
+	data, err := GenerateCapturedPiecesMessage(getCaptured.GameID, game)
+	game.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestCapturedEndpointGroupsAndSorts(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "captures", CreateGameRequest{})
+	// black loses pawn, queen and knight in that order, white two pawns
+	// and a bishop
+	playMoves(t, "captures", game,
+		"e2e4", "d7d5", "e4d5", "d8d5", "b1c3", "d5a2", "a1a2",
+		"b8c6", "f1b5", "a7a6", "b5c6", "b7c6")
+
+	recorder := doRequest(t, http.MethodGet, "/game/captures/captured", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+
+	var response struct {
+		White CapturedSide `json:"white"`
+		Black CapturedSide `json:"black"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	wantBlack := CapturedSide{
+		Pieces: []string{"p", "n", "q"},
+		Groups: []CapturedGroup{{"p", 1}, {"n", 1}, {"q", 1}},
+		Count:  3,
+	}
+	if !reflect.DeepEqual(response.Black, wantBlack) {
+		t.Errorf("black = %+v, want %+v", response.Black, wantBlack)
+	}
+
+	wantWhite := CapturedSide{
+		Pieces: []string{"p", "p", "b"},
+		Groups: []CapturedGroup{{"p", 2}, {"b", 1}},
+		Count:  3,
+	}
+	if !reflect.DeepEqual(response.White, wantWhite) {
+		t.Errorf("white = %+v, want %+v", response.White, wantWhite)
+	}
+}
+
+func TestCapturedPieceEnPassant(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "ep", CreateGameRequest{})
+	playMoves(t, "ep", game, "e2e4", "a7a6", "e4e5", "d7d5", "e5d6")
+
+	captured := CapturedPieces(game.Game)
+	if got := GroupCapturedPieces(captured[chess.White]); got.Count != 0 {
+		t.Errorf("white lost %v", got.Pieces)
+	}
+	if got := GroupCapturedPieces(captured[chess.Black]); got.Count != 1 || got.Pieces[0] != "p" {
+		t.Errorf("black lost %v, want the en passant pawn", got.Pieces)
+	}
+}
+
+func TestCapturedPiecesOnlyBroadcastOnCaptures(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	// alice's messages are handled in order, so the pgn answer comes
+	// after everything the quiet move sent to the game
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.send("getPgn", GetPgnMessage{GameID: "game-1"})
+	for _, msg := range alice.readUntil("pgn") {
+		if msg.Type == "capturedPieces" {
+			t.Errorf("captured update after a quiet move: %s", msg.Payload)
+		}
+	}
+
+	bob.send("move", MoveMessage{GameID: "game-1", Move: "d7d5"})
+	bob.expect("moveAck")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e4d5"})
+
+	var captured CapturedPiecesMessage
+	bob.expect("capturedPieces", &captured)
+	if captured.Black.Count != 1 {
+		t.Errorf("%d black pieces captured, want 1", captured.Black.Count)
+	}
+}
+
+func TestGetCapturedMatchesBroadcast(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck")
+	bob.send("move", MoveMessage{GameID: "game-1", Move: "d7d5"})
+	bob.expect("moveAck")
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e4d5"})
+
+	broadcast := bob.expect("capturedPieces")
+
+	// a client that wasn't watching asks for the state
+	late := dialWS(t, server, "id=carol")
+	late.send("getCaptured", GetCapturedMessage{GameID: "game-1"})
+	answer := late.expect("capturedPieces")
+
+	if answer.Payload != broadcast.Payload {
+		t.Errorf("getCaptured answered %s, broadcast was %s", answer.Payload, broadcast.Payload)
+	}
+
+	late.send("getCaptured", GetCapturedMessage{GameID: "missing"})
+
+	var errorMsg ErrorMessage
+	late.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeGameNotFound {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeGameNotFound)
+	}
+}
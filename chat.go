@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxChatLength is the maximum length of a chat message in characters.
+var MaxChatLength = 500
+
+// chatHistorySize is how many chat messages a game keeps to replay to
+// clients that join later.
+const chatHistorySize = 50
+
+type ChatMessage struct {
+	GameID string `json:"gameId"`
+	Text   string `json:"text"`
+}
+
+// ChatEntry is a chat message as relayed by the server, stamped with its
+// sender.
+type ChatEntry struct {
+	GameID   string    `json:"gameId"`
+	PlayerID string    `json:"playerId"`
+	Color    string    `json:"color"`
+	Text     string    `json:"text"`
+	SentAt   time.Time `json:"sentAt"`
+}
+
+type ChatHistoryMessage struct {
+	GameID   string      `json:"gameId"`
+	Messages []ChatEntry `json:"messages"`
+}
+
+// HandleChat relays a player's chat message to everyone in the game,
+// including the sender as confirmation.
+func HandleChat(wsMsg WebsocketMessage, client *Client) error {
+	var chat ChatMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &chat)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(chat.GameID)
+	if !ok {
+		return SendError(client, chat.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return SendError(client, chat.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	text := strings.TrimSpace(chat.Text)
+	if text == "" || utf8.RuneCountInString(text) > MaxChatLength {
+		return SendError(client, chat.GameID, ErrorCodeInvalidChat, "Chat messages must not be empty or too long")
+	}
+
+	entry := ChatEntry{
+		GameID:   chat.GameID,
+		PlayerID: client.ID,
+		Color:    ColorCode(color),
+		Text:     text,
+		SentAt:   time.Now(),
+	}
+
+	game.mu.Lock()
+	game.Chat = append(game.Chat, entry)
+	if len(game.Chat) > chatHistorySize {
+		game.Chat = game.Chat[len(game.Chat)-chatHistorySize:]
+	}
+	game.mu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "chat",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	BroadcastToGame(chat.GameID, data)
+
+	return nil
+}
+
+// SendChatHistory replays the kept chat messages of the game to a client
+// that just joined. Nothing is sent while the chat is empty.
+func SendChatHistory(client *Client, gameID string, game *Game) error {
+	game.mu.Lock()
+	messages := make([]ChatEntry, len(game.Chat))
+	copy(messages, game.Chat)
+	game.mu.Unlock()
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(ChatHistoryMessage{
+		GameID:   gameID,
+		Messages: messages,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "chatHistory",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
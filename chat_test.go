@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChatIsRelayedWithTheSenderColor(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	spectator := dialWS(t, server, "id=carol")
+	spectator.send("spectate", SpectateMessage{GameID: "game-1"})
+	spectator.expect("players")
+
+	alice.send("chat", ChatMessage{GameID: "game-1", Text: "good luck"})
+
+	for _, client := range []*testConn{bob, spectator} {
+		var entry ChatEntry
+		client.expect("chat", &entry)
+		if entry.PlayerID != "alice" || entry.Color != ColorWhite || entry.Text != "good luck" {
+			t.Errorf("chat relayed as %+v, want alice's with white", entry)
+		}
+	}
+
+	// a late joiner gets the chat so far
+	late := dialWS(t, server, "id=dave")
+	late.send("spectate", SpectateMessage{GameID: "game-1"})
+
+	var history ChatHistoryMessage
+	late.expect("chatHistory", &history)
+	if len(history.Messages) != 1 || history.Messages[0].Text != "good luck" {
+		t.Errorf("chat history %+v, want alice's message", history.Messages)
+	}
+}
+
+func TestChatIsRejected(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	outsider := dialWS(t, server, "id=carol")
+
+	var errorMsg ErrorMessage
+
+	alice.send("chat", ChatMessage{GameID: "game-1", Text: strings.Repeat("a", MaxChatLength+1)})
+	alice.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeInvalidChat {
+		t.Errorf("long chat: error code %q, want %q", errorMsg.Code, ErrorCodeInvalidChat)
+	}
+
+	outsider.send("chat", ChatMessage{GameID: "game-1", Text: "hi"})
+	outsider.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeNotAPlayer {
+		t.Errorf("outsider chat: error code %q, want %q", errorMsg.Code, ErrorCodeNotAPlayer)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// gameClients indexes the registered clients by the game they are viewing,
+// so game updates only go to the clients of that game. It is guarded by
+// clientsMu and kept in sync with Client.GameID by SetClientGame.
+var gameClients = make(map[string][]*Client)
+
+// MaxClientsPerSeat is the number of connections each of the two players
+// may have in a game, e.g. a second tab or a reconnect racing the close of
+// the old connection. MaxSpectators is the number of spectator
+// connections.
+var MaxClientsPerSeat = 2
+var MaxSpectators = 100
+
+var ErrGameFull = errors.New("Game is full")
+
+// AddGameClient moves the client to the given game like SetClientGame, but
+// fails with ErrGameFull when the game has no free slot for it. Players
+// are counted per seat, so the connections of one player never lock out
+// the other. A client already in the game keeps its slot.
+func AddGameClient(client *Client, gameID string, spectator bool) error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	limit := MaxSpectators
+	if !spectator {
+		limit = MaxClientsPerSeat
+	}
+
+	count := 0
+	for _, viewer := range gameClients[gameID] {
+		if viewer == client || viewer.Spectator != spectator {
+			continue
+		}
+
+		if spectator || viewer.ID == client.ID {
+			count++
+		}
+	}
+
+	if count >= limit {
+		return ErrGameFull
+	}
+
+	setClientGameLocked(client, gameID, spectator)
+
+	return nil
+}
+
+// SetClientGame moves the client to the given game, or detaches it from
+// its game when gameID is empty. Spectators only receive updates of the
+// game and can't change it.
+func SetClientGame(client *Client, gameID string, spectator bool) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	setClientGameLocked(client, gameID, spectator)
+}
+
+// ClientGame returns the game the client is viewing and whether it views
+// it as a spectator. Other connections change both through DetachGameClients,
+// so they must not be read without clientsMu.
+func ClientGame(client *Client) (string, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	return client.GameID, client.Spectator
+}
+
+func setClientGameLocked(client *Client, gameID string, spectator bool) {
+	if client.GameID != "" {
+		viewers := gameClients[client.GameID]
+		for i, viewer := range viewers {
+			if viewer == client {
+				viewers = append(viewers[:i], viewers[i+1:]...)
+				break
+			}
+		}
+
+		if len(viewers) == 0 {
+			delete(gameClients, client.GameID)
+		} else {
+			gameClients[client.GameID] = viewers
+		}
+	}
+
+	client.GameID = gameID
+	client.Spectator = gameID != "" && spectator
+
+	if gameID != "" {
+		gameClients[gameID] = append(gameClients[gameID], client)
+	}
+}
+
+// DetachGameClients detaches every client viewing the game.
+func DetachGameClients(gameID string) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	for _, client := range gameClients[gameID] {
+		detachClientLocked(client)
+	}
+
+	delete(gameClients, gameID)
+}
+
+// detachClientLocked resets the client's game without touching
+// gameClients, for callers that drop the game's entry themselves. The
+// caller must hold clientsMu.
+func detachClientLocked(client *Client) {
+	client.GameID = ""
+	client.Spectator = false
+}
+
+// SpectatorCount returns the number of spectators viewing the game.
+func SpectatorCount(gameID string) int {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	count := 0
+	for _, client := range gameClients[gameID] {
+		if client.Spectator {
+			count++
+		}
+	}
+
+	return count
+}
+
+// BroadcastToGame sends data to every client viewing the game, players
+// and spectators alike.
+func BroadcastToGame(gameID string, data []byte) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range gameClients[gameID] {
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// SendToSpectators sends data to the spectators of the game.
+func SendToSpectators(gameID string, data []byte) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range gameClients[gameID] {
+		if !client.Spectator {
+			continue
+		}
+
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// GamePlayerClients returns the clients viewing the game as players.
+func GamePlayerClients(gameID string) []*Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	players := make([]*Client, 0)
+	for _, client := range gameClients[gameID] {
+		if !client.Spectator {
+			players = append(players, client)
+		}
+	}
+
+	return players
+}
+
+// CloseGameClients sends a close frame to every client viewing the game.
+// Their read loops end and unregister them.
+func CloseGameClients(gameID string, reason string) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+
+	for _, client := range gameClients[gameID] {
+		err := client.SendControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		if err != nil {
+			slog.Warn("closing connection failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+)
+
+// setMaxSpectators changes the spectator cap for the test. The cap is
+// swapped under clientsMu, which AddGameClient reads it under.
+func setMaxSpectators(t *testing.T, max int) {
+	t.Helper()
+
+	clientsMu.Lock()
+	previous := MaxSpectators
+	MaxSpectators = max
+	clientsMu.Unlock()
+
+	t.Cleanup(func() {
+		clientsMu.Lock()
+		MaxSpectators = previous
+		clientsMu.Unlock()
+	})
+}
+
+func TestPlayerSeatsAreCappedPerPlayer(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	for i := 0; i < MaxClientsPerSeat; i++ {
+		dialPlayer(t, server, "alice")
+	}
+
+	// one connection too many for alice's seat
+	extra := dialWS(t, server, "id=alice")
+
+	var errorMsg ErrorMessage
+	extra.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeGameFull {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeGameFull)
+	}
+
+	// alice's connections don't take bob's seat
+	bob := dialPlayer(t, server, "bob")
+	bob.send("join", JoinMessage{GameID: "game-1"})
+
+	var against AgainstMessage
+	bob.expect("against", &against)
+	if against.ID != "alice" {
+		t.Errorf("bob plays against %q, want alice", against.ID)
+	}
+}
+
+func TestSpectatorsAreCapped(t *testing.T) {
+	resetState(t)
+	setMaxSpectators(t, 2)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	for _, id := range []string{"carol", "dave"} {
+		spectator := dialWS(t, server, "id="+id)
+		spectator.send("spectate", SpectateMessage{GameID: "game-1"})
+		spectator.expect("players")
+	}
+
+	late := dialWS(t, server, "id=erin")
+	late.send("spectate", SpectateMessage{GameID: "game-1"})
+
+	var errorMsg ErrorMessage
+	late.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeGameFull {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeGameFull)
+	}
+
+	// the players' seats don't count against the spectators
+	alice.send("join", JoinMessage{GameID: "game-1"})
+	alice.expect("against")
+}
+
+func TestObserversJoinAsSpectators(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+
+	// observers with alice's id fill no seat of hers
+	for i := 0; i < MaxClientsPerSeat+1; i++ {
+		observer := dialWS(t, server, "id=alice&role=observer")
+		observer.send("join", JoinMessage{GameID: "game-1"})
+
+		var spectator SpectatorMessage
+		observer.expect("players", &spectator)
+		if spectator.GameID != "game-1" {
+			t.Errorf("observer joined %q, want game-1", spectator.GameID)
+		}
+	}
+
+	clientsMu.RLock()
+	for _, client := range gameClients["game-1"] {
+		if !client.Spectator {
+			t.Errorf("observer %q joined as a player", client.ID)
+		}
+	}
+	clientsMu.RUnlock()
+
+	dialPlayer(t, server, "alice")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Clock start rules. With ClockStartAfterWhiteMove white's first move is
+// free, with ClockStartAfterFirstMove each side's first move is free.
+const (
+	ClockStartImmediately    = "immediately"
+	ClockStartAfterWhiteMove = "afterWhiteMove"
+	ClockStartAfterFirstMove = "afterFirstMove"
+)
+
+func IsValidClockStart(start string) bool {
+	return start == ClockStartImmediately ||
+		start == ClockStartAfterWhiteMove ||
+		start == ClockStartAfterFirstMove
+}
+
+type ClockSettings struct {
+	BaseSeconds      int `json:"baseSeconds"`
+	IncrementSeconds int `json:"incrementSeconds"`
+}
+
+// Clock keeps the remaining time of both sides. Each side has its own
+// settings so handicap games like 5+0 vs 3+2 are possible.
+type Clock struct {
+	White       ClockSettings `json:"white"`
+	Black       ClockSettings `json:"black"`
+	WhiteMillis int64         `json:"whiteMillis"`
+	BlackMillis int64         `json:"blackMillis"`
+	LastMoveAt  time.Time     `json:"lastMoveAt"`
+	// Start is one of the ClockStart rules, empty means immediately.
+	Start      string `json:"start,omitempty"`
+	WhiteMoves int    `json:"whiteMoves"`
+	BlackMoves int    `json:"blackMoves"`
+	// History holds the state from before every punched move, so a
+	// takeback can restore it, see Undo.
+	History []ClockSnapshot `json:"history,omitempty"`
+}
+
+// ClockSnapshot is the part of the clock a move changes.
+type ClockSnapshot struct {
+	WhiteMillis int64 `json:"whiteMillis"`
+	BlackMillis int64 `json:"blackMillis"`
+	WhiteMoves  int   `json:"whiteMoves"`
+	BlackMoves  int   `json:"blackMoves"`
+}
+
+type ClockMessage struct {
+	GameID      string `json:"gameId"`
+	WhiteMillis int64  `json:"whiteMillis"`
+	BlackMillis int64  `json:"blackMillis"`
+}
+
+func NewClock(white ClockSettings, black ClockSettings, now time.Time) *Clock {
+	return &Clock{
+		White:       white,
+		Black:       black,
+		WhiteMillis: int64(white.BaseSeconds) * 1000,
+		BlackMillis: int64(black.BaseSeconds) * 1000,
+		LastMoveAt:  now,
+	}
+}
+
+// Copy returns a copy of the clock that shares no state with it.
+func (c *Clock) Copy() *Clock {
+	copied := *c
+	copied.History = slices.Clone(c.History)
+
+	return &copied
+}
+
+func (c *Clock) Settings(color chess.Color) ClockSettings {
+	if color == chess.White {
+		return c.White
+	}
+
+	return c.Black
+}
+
+func (c *Clock) Remaining(color chess.Color) int64 {
+	if color == chess.White {
+		return c.WhiteMillis
+	}
+
+	return c.BlackMillis
+}
+
+// Started reports whether the clock of the given color is running under
+// the configured start rule.
+func (c *Clock) Started(color chess.Color) bool {
+	switch c.Start {
+	case ClockStartAfterWhiteMove:
+		return c.WhiteMoves > 0
+	case ClockStartAfterFirstMove:
+		if color == chess.White {
+			return c.WhiteMoves > 0
+		}
+		return c.BlackMoves > 0
+	default:
+		return true
+	}
+}
+
+func (c *Clock) SetRemaining(color chess.Color, millis int64) {
+	if millis < 0 {
+		millis = 0
+	}
+
+	if color == chess.White {
+		c.WhiteMillis = millis
+	} else {
+		c.BlackMillis = millis
+	}
+}
+
+// Punch deducts the time the mover spent since the last move from their
+// clock and adds their own increment. It reports whether the mover's flag
+// fell, in which case no increment is added. Moves made before the mover's
+// clock has started neither cost time nor earn an increment.
+func (c *Clock) Punch(mover chess.Color, now time.Time) bool {
+	c.History = append(c.History, ClockSnapshot{
+		WhiteMillis: c.WhiteMillis,
+		BlackMillis: c.BlackMillis,
+		WhiteMoves:  c.WhiteMoves,
+		BlackMoves:  c.BlackMoves,
+	})
+
+	started := c.Started(mover)
+
+	if mover == chess.White {
+		c.WhiteMoves++
+	} else {
+		c.BlackMoves++
+	}
+
+	if !started {
+		c.LastMoveAt = now
+		return false
+	}
+
+	elapsed := now.Sub(c.LastMoveAt).Milliseconds()
+	remaining := c.Remaining(mover) - elapsed
+	c.LastMoveAt = now
+
+	if remaining <= 0 {
+		c.SetRemaining(mover, 0)
+		return true
+	}
+
+	increment := int64(c.Settings(mover).IncrementSeconds) * 1000
+	c.SetRemaining(mover, remaining+increment)
+
+	return false
+}
+
+// Undo restores the clock to before the last punched move and restarts
+// the thinking time of the side to move at now. Clocks stored without a
+// history only restart the thinking time.
+func (c *Clock) Undo(now time.Time) {
+	c.LastMoveAt = now
+
+	if len(c.History) == 0 {
+		return
+	}
+
+	last := c.History[len(c.History)-1]
+	c.History = c.History[:len(c.History)-1]
+
+	c.WhiteMillis = last.WhiteMillis
+	c.BlackMillis = last.BlackMillis
+	c.WhiteMoves = last.WhiteMoves
+	c.BlackMoves = last.BlackMoves
+}
+
+func GenerateClockMessage(gameID string, clock *Clock) ([]byte, error) {
+	clockMsg := ClockMessage{
+		GameID:      gameID,
+		WhiteMillis: clock.WhiteMillis,
+		BlackMillis: clock.BlackMillis,
+	}
+
+	data, err := json.Marshal(clockMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := WebsocketMessage{
+		Type:    "clock",
+		Payload: string(data),
+	}
+
+	return json.Marshal(msg)
+}
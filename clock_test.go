@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func TestTimeOddsClockInitializesAndIncrementsPerSide(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "odds", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 300},
+		BlackClock:     &ClockSettings{BaseSeconds: 180, IncrementSeconds: 2},
+	})
+
+	clock := game.Clock
+	if clock == nil {
+		t.Fatal("game has no clock")
+	}
+	if clock.WhiteMillis != 300_000 || clock.BlackMillis != 180_000 {
+		t.Fatalf("clock starts at %d/%d, want 300000/180000", clock.WhiteMillis, clock.BlackMillis)
+	}
+
+	start := clock.LastMoveAt
+
+	// white spends 10s and gets no increment
+	if clock.Punch(chess.White, start.Add(10*time.Second)) {
+		t.Fatal("white flagged")
+	}
+	if clock.WhiteMillis != 290_000 {
+		t.Errorf("white has %d ms, want 290000", clock.WhiteMillis)
+	}
+
+	// black spends 5s and gets 2s back
+	if clock.Punch(chess.Black, start.Add(15*time.Second)) {
+		t.Fatal("black flagged")
+	}
+	if clock.BlackMillis != 177_000 {
+		t.Errorf("black has %d ms, want 177000", clock.BlackMillis)
+	}
+}
+
+func TestTimeOddsClockIsPersistedAndBroadcast(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "odds", CreateGameRequest{
+		WhiteClock: &ClockSettings{BaseSeconds: 300},
+		BlackClock: &ClockSettings{BaseSeconds: 180, IncrementSeconds: 2},
+	})
+
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreGame("odds", storedGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.Clock.White != (ClockSettings{BaseSeconds: 300}) ||
+		restored.Clock.Black != (ClockSettings{BaseSeconds: 180, IncrementSeconds: 2}) {
+		t.Fatalf("restored settings %+v/%+v", restored.Clock.White, restored.Clock.Black)
+	}
+
+	data, err := GenerateClockMessage("odds", game.Clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg WebsocketMessage
+	var clockMsg ClockMessage
+	json.Unmarshal(data, &msg)
+	json.Unmarshal([]byte(msg.Payload), &clockMsg)
+	if clockMsg.WhiteMillis != 300_000 || clockMsg.BlackMillis != 180_000 {
+		t.Fatalf("clock message %+v", clockMsg)
+	}
+}
+
+func TestSingleClockSettingAppliesToBothSides(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "single", CreateGameRequest{
+		WhiteClock: &ClockSettings{BaseSeconds: 60, IncrementSeconds: 1},
+	})
+
+	if game.Clock.Black != game.Clock.White || game.Clock.BlackMillis != 60_000 {
+		t.Fatalf("black clock %+v with %d ms, want white's", game.Clock.Black, game.Clock.BlackMillis)
+	}
+}
+
+func TestCheckmateOnFlagFallWinsOnTheBoard(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "mate", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 60},
+		BlackClock:     &ClockSettings{BaseSeconds: 60},
+	})
+
+	playMoves(t, "mate", game, "f2f3", "e7e5", "g2g4")
+
+	// black's flag falls while delivering mate
+	game.Clock.LastMoveAt = time.Now().Add(-2 * time.Minute)
+	playMoves(t, "mate", game, "d8h4")
+
+	if game.Game.Outcome() != chess.BlackWon {
+		t.Errorf("outcome %s, want 0-1", game.Game.Outcome())
+	}
+	if method := OutcomeMethod(game); method != "Checkmate" {
+		t.Errorf("method %s, want Checkmate", method)
+	}
+	if game.TimedOut {
+		t.Error("game marked as lost on time")
+	}
+}
+
+func TestFlagFallOnQuietMoveLosesOnTime(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "flag", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 60},
+		BlackClock:     &ClockSettings{BaseSeconds: 60},
+	})
+
+	playMoves(t, "flag", game, "e2e4")
+
+	game.Clock.LastMoveAt = time.Now().Add(-2 * time.Minute)
+	playMoves(t, "flag", game, "e7e5")
+
+	if game.Game.Outcome() != chess.WhiteWon {
+		t.Errorf("outcome %s, want 1-0", game.Game.Outcome())
+	}
+	if method := OutcomeMethod(game); method != "Timeout" {
+		t.Errorf("method %s, want Timeout", method)
+	}
+	if game.Clock.BlackMillis != 0 {
+		t.Errorf("black has %d ms left, want 0", game.Clock.BlackMillis)
+	}
+}
+
+func TestClockStartRules(t *testing.T) {
+	tests := []struct {
+		start string
+		// the remaining seconds of white and black after each of the
+		// first three moves, each taking 10s
+		white []int64
+		black []int64
+	}{
+		{ClockStartImmediately, []int64{50, 50, 40}, []int64{60, 50, 50}},
+		{ClockStartAfterWhiteMove, []int64{60, 60, 50}, []int64{60, 50, 50}},
+		{ClockStartAfterFirstMove, []int64{60, 60, 50}, []int64{60, 60, 60}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.start, func(t *testing.T) {
+			resetState(t)
+
+			game := newTestGame(t, "clock", CreateGameRequest{
+				Player1:        "alice",
+				Player2:        "bob",
+				PreferredColor: ColorWhite,
+				WhiteClock:     &ClockSettings{BaseSeconds: 60},
+				BlackClock:     &ClockSettings{BaseSeconds: 60},
+				ClockStart:     test.start,
+			})
+
+			clock := game.Clock
+			now := clock.LastMoveAt
+
+			// nobody loses on time before their clock started
+			if test.start != ClockStartImmediately && FlagIfTimedOut("clock", game, now.Add(time.Hour)) {
+				t.Fatal("white flagged before the clock started")
+			}
+
+			movers := []chess.Color{chess.White, chess.Black, chess.White}
+			for i, mover := range movers {
+				now = now.Add(10 * time.Second)
+				clock.Punch(mover, now)
+
+				if clock.WhiteMillis != test.white[i]*1000 || clock.BlackMillis != test.black[i]*1000 {
+					t.Errorf("after move %d: %d/%d ms, want %d/%d s", i+1,
+						clock.WhiteMillis, clock.BlackMillis, test.white[i], test.black[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIncrementOnlyForCompletedMoves(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:          "alice",
+		Player2:          "bob",
+		PreferredColor:   ColorWhite,
+		InitialSeconds:   60,
+		IncrementSeconds: 5,
+	})
+
+	// an illegal move and a move out of turn don't touch the clock
+	_, err := ApplyMove(game, &MoveMessage{GameID: "game-1", Move: "e2e5"}, &Client{ID: "alice"})
+	if err == nil {
+		t.Fatal("illegal move accepted")
+	}
+	_, err = ApplyMove(game, &MoveMessage{GameID: "game-1", Move: "e7e5"}, &Client{ID: "bob"})
+	if err == nil {
+		t.Fatal("move out of turn accepted")
+	}
+
+	game.mu.RLock()
+	white, moves := game.Clock.WhiteMillis, game.Clock.WhiteMoves
+	game.mu.RUnlock()
+	if white != 60_000 || moves != 0 {
+		t.Fatalf("white has %dms after %d moves, want 60000 after none", white, moves)
+	}
+
+	playMoves(t, "game-1", game, "e2e4")
+
+	game.mu.RLock()
+	white, moves = game.Clock.WhiteMillis, game.Clock.WhiteMoves
+	game.mu.RUnlock()
+
+	// the move took a few milliseconds at most, the increment came once
+	if white <= 64_000 || white > 65_000 || moves != 1 {
+		t.Errorf("white has %dms after %d moves, want just under 65000 after one", white, moves)
+	}
+}
+
+func TestMoveDeductsElapsedTimeAndBroadcastsClock(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:          "alice",
+		Player2:          "bob",
+		PreferredColor:   ColorWhite,
+		InitialSeconds:   300,
+		IncrementSeconds: 2,
+	})
+	if game.Clock.White != game.Clock.Black || game.Clock.White != (ClockSettings{BaseSeconds: 300, IncrementSeconds: 2}) {
+		t.Fatalf("clock settings %+v/%+v, want 300+2 for both", game.Clock.White, game.Clock.Black)
+	}
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	// white thought for 10 seconds
+	game.mu.Lock()
+	game.Clock.LastMoveAt = time.Now().Add(-10 * time.Second)
+	game.mu.Unlock()
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+
+	for _, client := range []*testConn{alice, bob} {
+		var clock ClockMessage
+		client.expect("clock", &clock)
+
+		// 300s - 10s + 2s, less the few milliseconds the move took
+		if clock.WhiteMillis > 292_000 || clock.WhiteMillis < 291_000 {
+			t.Errorf("white has %dms, want about 292000", clock.WhiteMillis)
+		}
+		if clock.BlackMillis != 300_000 {
+			t.Errorf("black has %dms, want 300000", clock.BlackMillis)
+		}
+	}
+}
+
+func TestSweeperFlagsIdlePlayer(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		InitialSeconds: 60,
+	})
+	playMoves(t, "game-1", game, "e2e4")
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	// black sits on the move while the injected clock runs past the flag
+	var nowMu sync.Mutex
+	now := time.Now()
+	advance := func(d time.Duration) {
+		nowMu.Lock()
+		now = now.Add(d)
+		nowMu.Unlock()
+	}
+
+	previousNow, previousInterval := sweeperNow, ClockSweepInterval
+	sweeperNow = func() time.Time {
+		nowMu.Lock()
+		defer nowMu.Unlock()
+		return now
+	}
+	ClockSweepInterval = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunClockSweeper(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		sweeperNow, ClockSweepInterval = previousNow, previousInterval
+	})
+
+	time.Sleep(20 * time.Millisecond)
+	game.mu.RLock()
+	timedOut := game.TimedOut
+	game.mu.RUnlock()
+	if timedOut {
+		t.Fatal("flagged before the time ran out")
+	}
+
+	advance(2 * time.Minute)
+
+	for _, client := range []*testConn{alice, bob} {
+		var outcome OutcomeMessage
+		client.expect("outcome", &outcome)
+		if outcome.Winner != ColorCode(chess.White) {
+			t.Errorf("winner %q, want white", outcome.Winner)
+		}
+	}
+
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+	if !game.TimedOut || game.Clock.Remaining(chess.Black) != 0 {
+		t.Errorf("timed out %v with black at %dms", game.TimedOut, game.Clock.Remaining(chess.Black))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// MaxIllegalMoves is the number of consecutive illegal moves a client
+// may send before it is disconnected.
+var MaxIllegalMoves = 10
+
+// TLSCertFile and TLSKeyFile enable HTTPS/WSS when both are set.
+var TLSCertFile string
+var TLSKeyFile string
+
+// AdminToken guards the /admin routes. They are disabled when it is empty.
+var AdminToken string
+
+// StrictMessageTypes answers unknown websocket message types with an
+// "unknown_type" error instead of silently dropping them.
+var StrictMessageTypes = true
+
+// LazyLoadGames only indexes stored games at startup and restores them on
+// first access, keeping at most MaxLoadedGames in memory.
+var LazyLoadGames = false
+var MaxLoadedGames = 1000
+
+// WsReadBufferSize, WsWriteBufferSize and WsHandshakeTimeout tune the
+// websocket upgrader. The handshake timeout also bounds how long a client may
+// take to send the upgrade request headers.
+var WsReadBufferSize = 4096
+var WsWriteBufferSize = 4096
+var WsHandshakeTimeout = 10 * time.Second
+
+func EnvBool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("invalid config value", "name", name, "error", err)
+		return fallback
+	}
+
+	return parsed
+}
+
+func EnvInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid config value", "name", name, "error", err)
+		return fallback
+	}
+
+	return parsed
+}
+
+// EnvPositiveInt is EnvInt for values that must be greater than zero, like
+// intervals and timeouts.
+func EnvPositiveInt(name string, fallback int) int {
+	parsed := EnvInt(name, fallback)
+	if parsed <= 0 {
+		slog.Warn("invalid config value", "name", name, "error", "must be positive")
+		return fallback
+	}
+
+	return parsed
+}
+
+func LoadConfig() {
+	// the log level is set up first so invalid values below can be logged
+	var levelErr error
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		levelErr = LogLevel.UnmarshalText([]byte(value))
+	}
+	SetupLogging()
+	if levelErr != nil {
+		slog.Warn("invalid config value", "name", "LOG_LEVEL", "error", levelErr)
+	}
+
+	MaxIllegalMoves = EnvInt("MAX_ILLEGAL_MOVES", MaxIllegalMoves)
+	TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+	AllowedOrigins = ParseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS"))
+	if dir := os.Getenv("GAMES_DIR"); dir != "" {
+		GamesDir = dir
+	}
+	StrictMessageTypes = EnvBool("STRICT_MESSAGE_TYPES", StrictMessageTypes)
+	LazyLoadGames = EnvBool("LAZY_LOAD_GAMES", LazyLoadGames)
+	MaxLoadedGames = EnvInt("MAX_LOADED_GAMES", MaxLoadedGames)
+	FinishedGameTTL = time.Duration(EnvInt("FINISHED_GAME_TTL_SECONDS", 0)) * time.Second
+	DrawOfferWindow = time.Duration(EnvInt("DRAW_OFFER_WINDOW_SECONDS", int(DrawOfferWindow/time.Second))) * time.Second
+	BotMoveTimeout = time.Duration(EnvInt("BOT_MOVE_TIMEOUT_MS", int(BotMoveTimeout/time.Millisecond))) * time.Millisecond
+	MaxChatLength = EnvInt("MAX_CHAT_LENGTH", MaxChatLength)
+	MaxClientsPerSeat = EnvInt("MAX_CLIENTS_PER_SEAT", MaxClientsPerSeat)
+	MaxSpectators = EnvInt("MAX_SPECTATORS", MaxSpectators)
+	MaxAddTimeSeconds = EnvInt("MAX_ADD_TIME_SECONDS", MaxAddTimeSeconds)
+	AddTimeCooldown = time.Duration(EnvInt("ADD_TIME_COOLDOWN_SECONDS", int(AddTimeCooldown/time.Second))) * time.Second
+	ReconnectTokenTTL = time.Duration(EnvInt("RECONNECT_TOKEN_TTL_SECONDS", int(ReconnectTokenTTL/time.Second))) * time.Second
+	ClockSweepInterval = time.Duration(EnvPositiveInt("CLOCK_SWEEP_INTERVAL_MS", int(ClockSweepInterval/time.Millisecond))) * time.Millisecond
+	ShutdownTimeout = time.Duration(EnvPositiveInt("SHUTDOWN_TIMEOUT_SECONDS", int(ShutdownTimeout/time.Second))) * time.Second
+	PersistInterval = time.Duration(EnvPositiveInt("PERSIST_INTERVAL_MS", int(PersistInterval/time.Millisecond))) * time.Millisecond
+	WsReadBufferSize = EnvInt("WS_READ_BUFFER_SIZE", WsReadBufferSize)
+	WsWriteBufferSize = EnvInt("WS_WRITE_BUFFER_SIZE", WsWriteBufferSize)
+	WsHandshakeTimeout = time.Duration(EnvInt("WS_HANDSHAKE_TIMEOUT_SECONDS", int(WsHandshakeTimeout/time.Second))) * time.Second
+	PongWait = time.Duration(EnvPositiveInt("WS_PONG_WAIT_SECONDS", int(PongWait/time.Second))) * time.Second
+	PingInterval = time.Duration(EnvInt("WS_PING_INTERVAL_SECONDS", int(PingInterval/time.Second))) * time.Second
+	if PingInterval <= 0 || PingInterval >= PongWait {
+		PingInterval = PongWait * 9 / 10
+	}
+
+	upgrader.ReadBufferSize = WsReadBufferSize
+	upgrader.WriteBufferSize = WsWriteBufferSize
+	upgrader.HandshakeTimeout = WsHandshakeTimeout
+}
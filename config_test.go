@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestEnvPositiveIntKeepsDefaultForNonPositiveValues(t *testing.T) {
+	for value, want := range map[string]int{
+		"":     500,
+		"250":  250,
+		"0":    500,
+		"-100": 500,
+		"fast": 500,
+	} {
+		t.Setenv("CLOCK_SWEEP_INTERVAL_MS", value)
+
+		if got := EnvPositiveInt("CLOCK_SWEEP_INTERVAL_MS", 500); got != want {
+			t.Errorf("%q parsed as %d, want %d", value, got, want)
+		}
+	}
+}
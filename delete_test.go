@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDeleteFinishedGame(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "f2f3", "e7e5", "g2g4", "d8h4")
+	err := SaveGame("game-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t)
+	carol := dialWS(t, server, "id=carol")
+	carol.send("spectate", SpectateMessage{GameID: "game-1"})
+	carol.expect("state")
+
+	recorder := doRequest(t, "DELETE", "/game/game-1", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		ID string `json:"id"`
+	}
+	decodeJSON(t, recorder, &response)
+	if response.ID != "game-1" {
+		t.Errorf("deleted %q, want game-1", response.ID)
+	}
+
+	if _, ok := GetGame("game-1"); ok {
+		t.Error("game still exists")
+	}
+	if _, err := os.Stat(gamePath("game-1")); !os.IsNotExist(err) {
+		t.Errorf("stored file still exists: %v", err)
+	}
+
+	// the clients of the game get a close frame
+	carol.conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := carol.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseNormalClosure {
+			t.Errorf("connection ended with %v, want a normal close", err)
+		}
+		break
+	}
+}
+
+func TestDeleteUnknownGame(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "DELETE", "/game/missing", nil)
+	if recorder.Code != 404 {
+		t.Errorf("status %d, want 404", recorder.Code)
+	}
+}
+
+func TestDeleteGameInProgress(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	recorder := doRequest(t, "DELETE", "/game/game-1", nil)
+	if recorder.Code != 409 {
+		t.Errorf("status %d, want 409", recorder.Code)
+	}
+
+	if _, ok := GetGame("game-1"); !ok {
+		t.Error("running game was deleted")
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// DrawOfferWindow is how long a draw offer can be accepted and the
+// acceptance confirmed before the offer expires.
+var DrawOfferWindow = 60 * time.Second
+
+// DrawOffer is a pending offer of draw by agreement. Accepting it only
+// asks the opponent for confirmation, the draw happens on confirmDraw. The
+// offer is withdrawn when the offering player moves instead.
+type DrawOffer struct {
+	By       chess.Color
+	At       time.Time
+	Accepted bool
+}
+
+func (offer *DrawOffer) ExpiresAt() time.Time {
+	return offer.At.Add(DrawOfferWindow)
+}
+
+func (offer *DrawOffer) Expired(now time.Time) bool {
+	return now.After(offer.ExpiresAt())
+}
+
+type DrawMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type DrawOfferMessage struct {
+	GameID    string    `json:"gameId"`
+	By        string    `json:"by"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type DrawDeclinedMessage struct {
+	GameID string `json:"gameId"`
+	By     string `json:"by"`
+}
+
+type ConfirmDrawMessage struct {
+	GameID    string    `json:"gameId"`
+	Text      string    `json:"text"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// drawMessageGame parses a draw message and looks up the game and the
+// sender's color in it. Rejections are sent to the client and reported
+// by ok being false.
+func drawMessageGame(wsMsg WebsocketMessage, client *Client) (string, *Game, chess.Color, bool, error) {
+	var drawMsg DrawMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &drawMsg)
+	if err != nil {
+		return "", nil, chess.NoColor, false, err
+	}
+
+	game, ok := GetGame(drawMsg.GameID)
+	if !ok {
+		return drawMsg.GameID, nil, chess.NoColor, false,
+			SendError(client, drawMsg.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return drawMsg.GameID, nil, chess.NoColor, false,
+			SendError(client, drawMsg.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	game.mu.RLock()
+	finished := game.Game.Outcome() != chess.NoOutcome
+	game.mu.RUnlock()
+
+	if finished {
+		return drawMsg.GameID, nil, chess.NoColor, false,
+			SendError(client, drawMsg.GameID, ErrorCodeGameOver, "Game is over")
+	}
+
+	if game.DrawsDisabled {
+		return drawMsg.GameID, nil, chess.NoColor, false,
+			SendError(client, drawMsg.GameID, ErrorCodeDrawsDisabled, "Draws are disabled in this game")
+	}
+
+	return drawMsg.GameID, game, color, true, nil
+}
+
+// HandleOfferDraw offers the opponent a draw, replacing the player's own
+// earlier offer. A fresh offer of the opponent has to be answered first.
+func HandleOfferDraw(wsMsg WebsocketMessage, client *Client) error {
+	gameID, game, color, ok, err := drawMessageGame(wsMsg, client)
+	if !ok {
+		return err
+	}
+
+	now := time.Now()
+	offer := &DrawOffer{By: color, At: now}
+
+	game.mu.Lock()
+	pending := game.DrawOffer
+	if pending != nil && pending.By != color && !pending.Expired(now) {
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeDrawOfferPending, "Answer the opponent's draw offer first")
+	}
+
+	game.DrawOffer = offer
+	game.mu.Unlock()
+
+	data, err := json.Marshal(DrawOfferMessage{
+		GameID:    gameID,
+		By:        ColorCode(color),
+		ExpiresAt: offer.ExpiresAt(),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "drawOffer",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	SendToPlayer(gameID, PlayerIdForColor(game, color.Other()), data)
+
+	return nil
+}
+
+// HandleDeclineDraw rejects the opponent's pending draw offer and tells
+// the offering player.
+func HandleDeclineDraw(wsMsg WebsocketMessage, client *Client) error {
+	gameID, game, color, ok, err := drawMessageGame(wsMsg, client)
+	if !ok {
+		return err
+	}
+
+	game.mu.Lock()
+
+	offer := game.DrawOffer
+	if offer == nil || offer.By == color {
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeNoDrawOffer, "No draw offer to decline")
+	}
+
+	game.DrawOffer = nil
+	game.mu.Unlock()
+
+	data, err := json.Marshal(DrawDeclinedMessage{
+		GameID: gameID,
+		By:     ColorCode(color),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "drawDeclined",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	SendToPlayer(gameID, PlayerIdForColor(game, offer.By), data)
+
+	return nil
+}
+
+// HandleAcceptDraw accepts the opponent's fresh draw offer and asks the
+// accepting player to confirm.
+func HandleAcceptDraw(wsMsg WebsocketMessage, client *Client) error {
+	gameID, game, color, ok, err := drawMessageGame(wsMsg, client)
+	if !ok {
+		return err
+	}
+
+	game.mu.Lock()
+
+	offer := game.DrawOffer
+	if offer == nil || offer.By == color {
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeNoDrawOffer, "No draw offer to accept")
+	}
+
+	if offer.Expired(time.Now()) {
+		game.DrawOffer = nil
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeDrawOfferExpired, "Draw offer expired")
+	}
+
+	offer.Accepted = true
+	game.mu.Unlock()
+
+	data, err := json.Marshal(ConfirmDrawMessage{
+		GameID:    gameID,
+		Text:      "This will draw the game",
+		ExpiresAt: offer.ExpiresAt(),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "confirmDraw",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+// HandleConfirmDraw draws the game if the player accepted the offer and
+// confirms within the window.
+func HandleConfirmDraw(wsMsg WebsocketMessage, client *Client) error {
+	gameID, game, color, ok, err := drawMessageGame(wsMsg, client)
+	if !ok {
+		return err
+	}
+
+	game.mu.Lock()
+
+	offer := game.DrawOffer
+	if offer == nil || offer.By == color || !offer.Accepted || game.Game.Outcome() != chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeNoDrawOffer, "No accepted draw offer to confirm")
+	}
+
+	if offer.Expired(time.Now()) {
+		game.DrawOffer = nil
+		game.mu.Unlock()
+		return SendError(client, gameID, ErrorCodeDrawOfferExpired, "Draw offer expired")
+	}
+
+	err = game.Game.Draw(chess.DrawOffer)
+	if err != nil {
+		game.mu.Unlock()
+		return err
+	}
+
+	game.DrawOffer = nil
+	FinalizeGame(gameID, game)
+	game.mu.Unlock()
+
+	err = SaveGame(gameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastOutcome(gameID, game)
+
+	return nil
+}
+
+// ClaimDrawMessage claims a draw by rule. Method is "ThreefoldRepetition"
+// or "FiftyMoveRule", empty claims whichever applies.
+type ClaimDrawMessage struct {
+	GameID string `json:"gameId"`
+	Method string `json:"method"`
+}
+
+// claimableDraws are the draws a player has to claim, the other draw
+// rules end the game on their own.
+var claimableDraws = []chess.Method{chess.ThreefoldRepetition, chess.FiftyMoveRule}
+
+// ClaimableDraw returns the method of a draw the players may claim in the
+// current position. With a method given only that one is checked.
+func ClaimableDraw(game *Game, method string) (chess.Method, bool) {
+	for _, claimable := range claimableDraws {
+		if method != "" && method != claimable.String() {
+			continue
+		}
+
+		for _, eligible := range game.Game.EligibleDraws() {
+			if eligible == claimable {
+				return claimable, true
+			}
+		}
+	}
+
+	return chess.NoMethod, false
+}
+
+// HandleClaimDraw ends the game in a draw by threefold repetition or the
+// fifty-move rule if the position allows it. Claims are allowed even when
+// draw offers are disabled, they follow from the rules.
+func HandleClaimDraw(wsMsg WebsocketMessage, client *Client) error {
+	var claim ClaimDrawMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &claim)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(claim.GameID)
+	if !ok {
+		return SendError(client, claim.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	if !IsPlayer(game, client.ID) {
+		return SendError(client, claim.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	game.mu.Lock()
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, claim.GameID, ErrorCodeGameOver, "Game is over")
+	}
+
+	method, ok := ClaimableDraw(game, claim.Method)
+	if !ok {
+		game.mu.Unlock()
+		return SendError(client, claim.GameID, ErrorCodeDrawNotClaimable, "No draw can be claimed in this position")
+	}
+
+	err = game.Game.Draw(method)
+	if err != nil {
+		game.mu.Unlock()
+		return err
+	}
+
+	game.DrawOffer = nil
+	FinalizeGame(claim.GameID, game)
+	game.mu.Unlock()
+
+	err = SaveGame(claim.GameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastOutcome(claim.GameID, game)
+
+	return nil
+}
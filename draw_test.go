@@ -0,0 +1,264 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func TestFiftyMoveRuleIsClaimable(t *testing.T) {
+	resetState(t)
+
+	// one quiet move short of 50 moves without capture or pawn move
+	game := newTestGame(t, "fifty", CreateGameRequest{StartingFen: "4k3/8/8/8/8/8/8/R3K3 w - - 99 80"})
+	playMoves(t, "fifty", game, "a1a2")
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		t.Fatalf("game ended by %s, the fifty-move rule must be claimed", game.Game.Method())
+	}
+
+	method, ok := ClaimableDraw(game, "")
+	if !ok || method != chess.FiftyMoveRule {
+		t.Fatalf("claimable draw = %s/%v, want FiftyMoveRule", method, ok)
+	}
+}
+
+func TestSeventyFiveMoveRuleIsAutomatic(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "seventyfive", CreateGameRequest{StartingFen: "4k3/8/8/8/8/8/8/R3K3 w - - 149 100"})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("move", MoveMessage{GameID: "seventyfive", Move: "a1a2"})
+
+	for _, client := range []*testConn{alice, bob} {
+		var outcome OutcomeMessage
+		client.expect("outcome", &outcome)
+		if outcome.Outcome != "1/2-1/2" || outcome.Method != chess.SeventyFiveMoveRule.String() {
+			t.Fatalf("outcome %s by %s, want a draw by the seventy-five-move rule", outcome.Outcome, outcome.Method)
+		}
+	}
+
+	game.mu.Lock()
+	finishedAt := game.FinishedAt
+	game.mu.Unlock()
+	if finishedAt.IsZero() {
+		t.Fatal("game was not finalized")
+	}
+}
+
+func TestDrawsDisabledRejectsOffers(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "nodraw", CreateGameRequest{DisableDrawOffers: true})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("offerDraw", DrawMessage{GameID: "nodraw"})
+
+	var errMsg ErrorMessage
+	alice.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeDrawsDisabled {
+		t.Fatalf("error code %q, want %q", errMsg.Code, ErrorCodeDrawsDisabled)
+	}
+
+	bob.expectNone("drawOffer", 100*time.Millisecond)
+}
+
+func TestDrawsDisabledKeepsAutomaticDraws(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "nodraw", CreateGameRequest{
+		DisableDrawOffers: true,
+		StartingFen:       "k7/8/1K6/2Q5/8/8/8/8 w - - 0 1",
+	})
+	playMoves(t, "nodraw", game, "c5c7")
+
+	if game.Game.Outcome() != chess.Draw || game.Game.Method() != chess.Stalemate {
+		t.Fatalf("outcome %s by %s, want a stalemate draw", game.Game.Outcome(), game.Game.Method())
+	}
+	if game.FinishedAt.IsZero() {
+		t.Fatal("stalemate was not finalized")
+	}
+}
+
+func TestDrawsDisabledIsPersisted(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "nodraw", CreateGameRequest{DisableDrawOffers: true})
+
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreGame("nodraw", storedGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !restored.DrawsDisabled {
+		t.Fatal("flag lost on restore")
+	}
+}
+
+func setDrawOfferWindow(t *testing.T, window time.Duration) {
+	t.Helper()
+
+	previous := DrawOfferWindow
+	DrawOfferWindow = window
+	t.Cleanup(func() { DrawOfferWindow = previous })
+}
+
+func TestDrawConfirmedWithinWindow(t *testing.T) {
+	resetState(t)
+	setDrawOfferWindow(t, time.Minute)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("offerDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("drawOffer")
+
+	bob.send("acceptDraw", DrawMessage{GameID: "game-1"})
+	var confirm ConfirmDrawMessage
+	bob.expect("confirmDraw", &confirm)
+	if confirm.GameID != "game-1" {
+		t.Fatalf("confirmation for %q, want game-1", confirm.GameID)
+	}
+
+	// accepting alone doesn't end the game
+	game.mu.Lock()
+	outcome := game.Game.Outcome()
+	game.mu.Unlock()
+	if outcome != chess.NoOutcome {
+		t.Fatalf("game ended with %s before the confirmation", outcome)
+	}
+
+	bob.send("confirmDraw", DrawMessage{GameID: "game-1"})
+
+	for _, client := range []*testConn{alice, bob} {
+		var outcome OutcomeMessage
+		client.expect("outcome", &outcome)
+		if outcome.Outcome != "1/2-1/2" || outcome.Method != chess.DrawOffer.String() {
+			t.Fatalf("outcome %s by %s, want a draw by agreement", outcome.Outcome, outcome.Method)
+		}
+	}
+}
+
+func TestCounterOfferKeepsThePendingOffer(t *testing.T) {
+	resetState(t)
+	setDrawOfferWindow(t, time.Minute)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("offerDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("drawOffer")
+
+	bob.send("offerDraw", DrawMessage{GameID: "game-1"})
+
+	var errMsg ErrorMessage
+	bob.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeDrawOfferPending {
+		t.Fatalf("error code %q, want %q", errMsg.Code, ErrorCodeDrawOfferPending)
+	}
+
+	game.mu.RLock()
+	offer := game.DrawOffer
+	game.mu.RUnlock()
+	if offer == nil || offer.By != chess.White {
+		t.Fatalf("pending offer %+v, want alice's", offer)
+	}
+
+	// alice's offer can still be accepted
+	bob.send("acceptDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("confirmDraw")
+}
+
+func TestDrawConfirmedAfterExpiryIsRejected(t *testing.T) {
+	resetState(t)
+	setDrawOfferWindow(t, 50*time.Millisecond)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("offerDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("drawOffer")
+	bob.send("acceptDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("confirmDraw")
+
+	time.Sleep(100 * time.Millisecond)
+
+	bob.send("confirmDraw", DrawMessage{GameID: "game-1"})
+	var errMsg ErrorMessage
+	bob.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeDrawOfferExpired {
+		t.Fatalf("error code %q, want %s", errMsg.Code, ErrorCodeDrawOfferExpired)
+	}
+
+	game.mu.Lock()
+	outcome, offer := game.Game.Outcome(), game.DrawOffer
+	game.mu.Unlock()
+	if outcome != chess.NoOutcome {
+		t.Fatalf("game ended with %s after an expired offer", outcome)
+	}
+	if offer != nil {
+		t.Error("expired offer is still pending")
+	}
+
+	// the expired offer can't be accepted again either
+	bob.send("acceptDraw", DrawMessage{GameID: "game-1"})
+	bob.expect("error", &errMsg)
+	if errMsg.Code != ErrorCodeNoDrawOffer {
+		t.Fatalf("error code %q, want %s", errMsg.Code, ErrorCodeNoDrawOffer)
+	}
+}
+
+func TestClaimDrawByThreefoldRepetition(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	// nothing to claim in the start position
+	alice.send("claimDraw", ClaimDrawMessage{GameID: "game-1"})
+
+	var errorMsg ErrorMessage
+	alice.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeDrawNotClaimable {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeDrawNotClaimable)
+	}
+
+	// the start position comes up for the third time
+	for i := 0; i < 2; i++ {
+		playMoves(t, "game-1", game, "g1f3", "g8f6", "f3g1", "f6g8")
+	}
+
+	bob.send("claimDraw", ClaimDrawMessage{GameID: "game-1", Method: chess.ThreefoldRepetition.String()})
+
+	for _, client := range []*testConn{alice, bob} {
+		var outcome OutcomeMessage
+		client.expect("outcome", &outcome)
+		if outcome.Outcome != chess.Draw.String() || outcome.Method != chess.ThreefoldRepetition.String() {
+			t.Errorf("outcome %q by %q, want a threefold repetition draw", outcome.Outcome, outcome.Method)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "encoding/json"
+
+// Error codes sent in the "error" message so clients can tell failures
+// apart without parsing the human-readable text.
+const (
+	ErrorCodeGameNotFound     = "game_not_found"
+	ErrorCodeReadOnly         = "read_only"
+	ErrorCodeUnknownType      = "unknown_type"
+	ErrorCodeInvalidSquare    = "invalid_square"
+	ErrorCodeNotAPlayer       = "not_a_player"
+	ErrorCodeGameOver         = "game_over"
+	ErrorCodeNoDrawOffer      = "no_draw_offer"
+	ErrorCodeDrawOfferExpired = "draw_offer_expired"
+	ErrorCodeNotYourTurn      = "not_your_turn"
+	ErrorCodeIllegalMove      = "illegal_move"
+	ErrorCodeNotReady         = "not_ready"
+	ErrorCodeNoClock          = "no_clock"
+	ErrorCodeInvalidAmount    = "invalid_amount"
+	ErrorCodeRateLimited      = "rate_limited"
+	ErrorCodeNoMoves          = "no_moves"
+	ErrorCodeNoTakeback       = "no_takeback_request"
+	ErrorCodeGameNotOver      = "game_not_over"
+	ErrorCodeInvalidChat      = "invalid_chat"
+	ErrorCodeDrawNotClaimable = "draw_not_claimable"
+	ErrorCodeGameFull         = "game_full"
+	ErrorCodeDrawsDisabled    = "draws_disabled"
+	ErrorCodeDrawOfferPending = "draw_offer_pending"
+)
+
+type ErrorMessage struct {
+	GameID  string `json:"gameId"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func GenerateErrorMessage(gameID string, code string, message string) ([]byte, error) {
+	errorMsg := ErrorMessage{
+		GameID:  gameID,
+		Code:    code,
+		Message: message,
+	}
+
+	data, err := json.Marshal(errorMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := WebsocketMessage{
+		Type:    "error",
+		Payload: string(data),
+	}
+
+	return json.Marshal(msg)
+}
+
+// SendError sends an error to the client. The message is replaced by its
+// translation for the client's locale when the catalog has one for code.
+func SendError(client *Client, gameID string, code string, message string) error {
+	message = Translate(client.Locale, "error."+code, message)
+
+	data, err := GenerateErrorMessage(gameID, code, message)
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// MateScore is the score of a checkmate. It is kept finite so scores can
+// be serialized and plotted.
+const MateScore = 100000
+
+var pieceValues = map[chess.PieceType]int{
+	chess.Pawn:   100,
+	chess.Knight: 320,
+	chess.Bishop: 330,
+	chess.Rook:   500,
+	chess.Queen:  900,
+	chess.King:   0,
+}
+
+// EvaluateMaterial returns the material balance in centipawns from
+// white's point of view.
+func EvaluateMaterial(board *chess.Board) int {
+	score := 0
+
+	for _, piece := range board.SquareMap() {
+		if piece.Color() == chess.White {
+			score += pieceValues[piece.Type()]
+		} else {
+			score -= pieceValues[piece.Type()]
+		}
+	}
+
+	return score
+}
+
+// negamax returns the score of pos from the side to move's point of view.
+// Leaves are scored with evaluate, which scores from white's point of
+// view. Once the deadline has passed the remaining nodes are evaluated
+// statically.
+func negamax(pos *chess.Position, depth int, alpha int, beta int, deadline time.Time, evaluate func(*chess.Board) int) int {
+	switch pos.Status() {
+	case chess.Checkmate:
+		return -MateScore
+	case chess.Stalemate:
+		return 0
+	}
+
+	if depth == 0 || time.Now().After(deadline) {
+		score := evaluate(pos.Board())
+		if pos.Turn() == chess.Black {
+			return -score
+		}
+
+		return score
+	}
+
+	for _, m := range pos.ValidMoves() {
+		score := -negamax(pos.Update(m), depth-1, -beta, -alpha, deadline, evaluate)
+		if score >= beta {
+			return beta
+		}
+
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha
+}
+
+// Evaluate searches pos to the given depth within timeout and returns the
+// score in centipawns from white's point of view.
+func Evaluate(pos *chess.Position, depth int, timeout time.Duration) int {
+	score := negamax(pos, depth, -MateScore-1, MateScore+1, time.Now().Add(timeout), EvaluateMaterial)
+	if pos.Turn() == chess.Black {
+		return -score
+	}
+
+	return score
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// FinishedGameTTL is how long a finished game stays queryable before it
+// is evicted. Zero keeps finished games forever.
+var FinishedGameTTL time.Duration
+
+// FinalizeGame tears down the per-game state of a game that reached an
+// outcome and schedules its eviction according to FinishedGameTTL. The
+// caller must hold game.mu.
+func FinalizeGame(id string, game *Game) {
+	if game.FinishedAt.IsZero() {
+		game.FinishedAt = time.Now()
+	}
+
+	// offers can't be answered anymore
+	game.DrawOffer = nil
+	game.TakebackRequest = nil
+
+	if game.evictTimer != nil {
+		game.evictTimer.Stop()
+		game.evictTimer = nil
+	}
+
+	if FinishedGameTTL <= 0 {
+		return
+	}
+
+	game.evictTimer = time.AfterFunc(FinishedGameTTL, func() {
+		err := EvictGame(id)
+		if err != nil {
+			slog.Error("evicting game failed", "game_id", id, "error", err)
+		}
+	})
+}
+
+// stopEvictTimer cancels a scheduled eviction of the game. The caller must
+// not hold game.mu.
+func stopEvictTimer(game *Game) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	if game.evictTimer != nil {
+		game.evictTimer.Stop()
+	}
+}
+
+// EvictGame drops a finished game from memory and detaches any client
+// still viewing it. Its file is kept: in lazy mode the game moves into the
+// index, otherwise the next LoadGames restores it.
+func EvictGame(id string) error {
+	gamesMu.Lock()
+	game, ok := games[id]
+	if !ok {
+		gamesMu.Unlock()
+		return nil
+	}
+
+	stopEvictTimer(game)
+
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		gamesMu.Unlock()
+		return err
+	}
+
+	if LazyLoadGames {
+		game.mu.RLock()
+		record := RecordGame(game)
+		game.mu.RUnlock()
+
+		indexGame(id, storedGame, record)
+	} else {
+		unloadedGames[id] = true
+	}
+
+	delete(games, id)
+	gamesMu.Unlock()
+
+	DetachGameClients(id)
+
+	// the last changes may still wait for the persist worker
+	data, err := json.Marshal(storedGame)
+	if err != nil {
+		return err
+	}
+
+	return PersistGame(id, data)
+}
+
+// DeleteGame removes a game from memory and storage and detaches any
+// client still viewing it.
+func DeleteGame(id string) error {
+	gamesMu.Lock()
+	unindexGame(id)
+	delete(unloadedGames, id)
+
+	if game, ok := games[id]; ok {
+		stopEvictTimer(game)
+		delete(games, id)
+	}
+	gamesMu.Unlock()
+
+	DetachGameClients(id)
+
+	return DeleteStoredGame(id)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFinishedGameIsEvictedAfterGraceWindow(t *testing.T) {
+	resetState(t)
+
+	ttl := FinishedGameTTL
+	FinishedGameTTL = 50 * time.Millisecond
+	t.Cleanup(func() { FinishedGameTTL = ttl })
+
+	game := newTestGame(t, "mate", CreateGameRequest{})
+	game.DrawOffer = &DrawOffer{}
+
+	// fool's mate
+	playMoves(t, "mate", game, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	game.mu.Lock()
+	finishedAt, drawOffer := game.FinishedAt, game.DrawOffer
+	game.mu.Unlock()
+
+	if finishedAt.IsZero() {
+		t.Fatal("game was not finalized")
+	}
+	if drawOffer != nil {
+		t.Fatal("pending draw offer survived the end of the game")
+	}
+
+	err := SaveGame("mate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// still queryable right after the outcome
+	if _, ok := GetGame("mate"); !ok {
+		t.Fatal("game evicted before the grace window")
+	}
+
+	waitFor(t, "eviction", func() bool {
+		gamesMu.RLock()
+		defer gamesMu.RUnlock()
+
+		_, ok := games["mate"]
+		return !ok
+	})
+
+	// the file outlives the eviction, saving doesn't remove it either
+	err = SaveGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(gamePath("mate")); err != nil {
+		t.Fatalf("stored game removed: %v", err)
+	}
+}
+
+func TestLazyEvictionMovesGameIntoIndex(t *testing.T) {
+	resetState(t)
+
+	ttl := FinishedGameTTL
+	FinishedGameTTL = time.Hour
+	t.Cleanup(func() { FinishedGameTTL = ttl })
+
+	newTestGame(t, "mate", CreateGameRequest{})
+	indexGames(t, 10)
+
+	game, ok := GetGame("mate")
+	if !ok {
+		t.Fatal("game not found")
+	}
+	playMoves(t, "mate", game, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	err := EvictGame("mate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if isLoaded("mate") {
+		t.Fatal("evicted game still loaded")
+	}
+
+	// the final position was written and is restored from the index
+	summaries := ListGames(GameStatusFinished, "")
+	if len(summaries) != 1 || summaries[0].MoveCount != 4 {
+		t.Fatalf("finished games %+v, want mate with 4 moves", summaries)
+	}
+
+	if n := storedMoveCount(t, "mate"); n != 4 {
+		t.Errorf("stored game has %d moves, want 4", n)
+	}
+
+	restored, ok := GetGame("mate")
+	if !ok {
+		t.Fatal("evicted game not restored from the index")
+	}
+	if len(restored.Game.Moves()) != 4 {
+		t.Errorf("restored game has %d moves, want 4", len(restored.Game.Moves()))
+	}
+}
+
+func TestFinalizeGameCancelsEarlierTimer(t *testing.T) {
+	resetState(t)
+
+	ttl := FinishedGameTTL
+	FinishedGameTTL = time.Hour
+	t.Cleanup(func() { FinishedGameTTL = ttl })
+
+	game := newTestGame(t, "timer", CreateGameRequest{})
+
+	FinalizeGame("timer", game)
+	first := game.evictTimer
+
+	FinalizeGame("timer", game)
+	if first.Stop() {
+		t.Fatal("the first eviction timer was still running")
+	}
+
+	err := EvictGame("timer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if game.evictTimer.Stop() {
+		t.Fatal("EvictGame left the eviction timer running")
+	}
+}
+
+func TestFinishedGamesAreKeptWithoutTTL(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "keep", CreateGameRequest{})
+	playMoves(t, "keep", game, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	if game.evictTimer != nil {
+		t.Fatal("eviction scheduled without a TTL")
+	}
+}
+
+func TestEvictionRacesWithFinalize(t *testing.T) {
+	resetState(t)
+
+	ttl := FinishedGameTTL
+	FinishedGameTTL = time.Hour
+	t.Cleanup(func() { FinishedGameTTL = ttl })
+
+	game := newTestGame(t, "race", CreateGameRequest{})
+
+	// the race detector flags an eviction timer that isn't always
+	// accessed under game.mu
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		game.mu.Lock()
+		FinalizeGame("race", game)
+		game.mu.Unlock()
+	}()
+
+	err := EvictGame("race")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// ready is set once LoadGames succeeded and cleared again when the server
+// starts shutting down, so load balancers stop sending traffic.
+var ready atomic.Bool
+
+// loadErr holds why LoadGames failed, if it did.
+var loadErr atomic.Value
+
+func SetReady(isReady bool) {
+	ready.Store(isReady)
+}
+
+func IsReady() bool {
+	return ready.Load()
+}
+
+func SetLoadError(err error) {
+	loadErr.Store(err.Error())
+}
+
+// LoadError returns why the games could not be loaded, or "" if they
+// were.
+func LoadError() string {
+	message, _ := loadErr.Load().(string)
+	return message
+}
+
+// LoadGamesForReadiness loads the games on startup and marks the server
+// ready, or records why it can't be. The server keeps running either way
+// so /readyz can report the error.
+func LoadGamesForReadiness() {
+	err := LoadGames()
+	if err != nil {
+		slog.Error("loading games failed", "error", err)
+		SetLoadError(err)
+		return
+	}
+
+	SetReady(true)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// resetReadiness marks the server as not yet loaded.
+func resetReadiness(t *testing.T) {
+	t.Helper()
+
+	SetReady(false)
+	loadErr.Store("")
+	t.Cleanup(func() {
+		SetReady(false)
+		loadErr.Store("")
+	})
+}
+
+func TestReadyAfterLoadingGames(t *testing.T) {
+	resetState(t)
+	resetReadiness(t)
+
+	if recorder := doRequest(t, "GET", "/healthz", nil); recorder.Code != 200 {
+		t.Errorf("healthz before loading: status %d, want 200", recorder.Code)
+	}
+	if recorder := doRequest(t, "GET", "/readyz", nil); recorder.Code != 503 {
+		t.Errorf("readyz before loading: status %d, want 503", recorder.Code)
+	}
+
+	LoadGamesForReadiness()
+
+	if recorder := doRequest(t, "GET", "/healthz", nil); recorder.Code != 200 {
+		t.Errorf("healthz after loading: status %d, want 200", recorder.Code)
+	}
+	if recorder := doRequest(t, "GET", "/readyz", nil); recorder.Code != 200 {
+		t.Errorf("readyz after loading: status %d, want 200", recorder.Code)
+	}
+}
+
+func TestNotReadyWhenLoadingFails(t *testing.T) {
+	resetState(t)
+	resetReadiness(t)
+
+	// a corrupt game without a backup can't be loaded
+	err := os.WriteFile(gamePath("broken"), []byte("{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	LoadGamesForReadiness()
+
+	recorder := doRequest(t, "GET", "/readyz", nil)
+	if recorder.Code != 503 {
+		t.Fatalf("readyz: status %d, want 503", recorder.Code)
+	}
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	decodeJSON(t, recorder, &response)
+	if response.Message == "" || response.Message == "Shutting down" {
+		t.Errorf("message %q, want the load error", response.Message)
+	}
+
+	if recorder := doRequest(t, "GET", "/healthz", nil); recorder.Code != 200 {
+		t.Errorf("healthz: status %d, want 200", recorder.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// PongWait is how long a connection may go without answering a ping
+// before it counts as dead. PingInterval must be shorter so a healthy
+// client always has a ping to answer in time.
+var PongWait = 60 * time.Second
+var PingInterval = 50 * time.Second
+
+// StartHeartbeat pings the client's connection every PingInterval and
+// makes reads fail once no pong arrived for PongWait, which ends the read
+// loop of a dead connection. The returned function stops the pings.
+func StartHeartbeat(client *Client) func() {
+	conn := client.Conn
+	conn.SetReadDeadline(time.Now().Add(PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(PingInterval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := client.SendControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+				if err != nil {
+					slog.Debug("sending ping failed", "error", err)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientWithoutPongsIsDisconnected(t *testing.T) {
+	resetState(t)
+
+	previousWait, previousInterval := PongWait, PingInterval
+	PongWait = 200 * time.Millisecond
+	PingInterval = 20 * time.Millisecond
+	t.Cleanup(func() {
+		// restore once the handlers that read them are gone
+		waitFor(t, "the clients to disconnect", func() bool {
+			clientsMu.RLock()
+			defer clientsMu.RUnlock()
+
+			return len(connectedClients) == 0
+		})
+		PongWait, PingInterval = previousWait, previousInterval
+	})
+
+	server := newTestServer(t)
+
+	// alice stops reading, so her pings are never answered
+	dialWS(t, server, "id=alice")
+
+	// bob keeps reading, which answers the pings
+	bob := dialWS(t, server, "id=bob")
+	go func() {
+		for {
+			_, _, err := bob.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	waitFor(t, "alice to be dropped", func() bool {
+		return !isConnected("alice")
+	})
+	if elapsed := time.Since(start); elapsed > 2*PongWait {
+		t.Errorf("alice dropped after %s, want within %s", elapsed, PongWait)
+	}
+
+	if !isConnected("bob") {
+		t.Error("bob was dropped although the pings were answered")
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/notnil/chess"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir, err := os.MkdirTemp("", "chess-api-test")
+	if err != nil {
+		panic(err)
+	}
+	GamesDir = dir
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// resetState clears the global game and client state and points the store
+// at a fresh directory, so every test starts from an empty server.
+func resetState(t *testing.T) {
+	t.Helper()
+
+	// bot moves from earlier tests land before the state goes
+	botSearches.Wait()
+
+	gamesMu.Lock()
+	games = make(map[string]*Game)
+	storedIndex = make(StoredGames)
+	indexRecords = make(map[string]GameRecord)
+	unloadedGames = make(map[string]bool)
+	gamesMu.Unlock()
+
+	clientsMu.Lock()
+	connectedClients = make([]*Client, 0)
+	gameClients = make(map[string][]*Client)
+	clientsMu.Unlock()
+
+	matchMu.Lock()
+	matchQueue = make([]string, 0)
+	matchedGames = make(map[string]string)
+	creatingMatches = make(map[string]bool)
+	matchMu.Unlock()
+
+	dirtyMu.Lock()
+	dirtyGames = make(map[string]bool)
+	dirtyMu.Unlock()
+
+	reconnectMu.Lock()
+	reconnectSessions = make(map[string]*ReconnectSession)
+	reconnectMu.Unlock()
+
+	storeMu.Lock()
+	pendingWrites = make(map[string][]byte)
+	storeMu.Unlock()
+
+	idempotencyMu.Lock()
+	idempotencyKeys = make(map[string]IdempotencyRecord)
+	idempotencyMu.Unlock()
+
+	GamesDir = t.TempDir()
+}
+
+// newTestGame creates and registers a game from the request. The players
+// default to "alice" with white and "bob" with black.
+func newTestGame(t *testing.T, id string, request CreateGameRequest) *Game {
+	t.Helper()
+
+	if request.Player1 == "" && request.Player2 == "" {
+		request.Player1 = "alice"
+		request.Player2 = "bob"
+		request.PreferredColor = ColorWhite
+	}
+
+	game, err := NewGameFromRequest(request)
+	if err != nil {
+		t.Fatalf("creating game: %v", err)
+	}
+
+	err = AddGame(id, game)
+	if err != nil {
+		t.Fatalf("adding game: %v", err)
+	}
+
+	return game
+}
+
+// playMoves applies the moves in the game's notation, each by the player
+// whose turn it is.
+func playMoves(t *testing.T, id string, game *Game, moves ...string) {
+	t.Helper()
+
+	for _, move := range moves {
+		mover := game.WhitePlayerId
+		if game.Game.Position().Turn() == chess.Black {
+			mover = game.BlackPlayerId
+		}
+
+		_, err := ApplyMove(game, &MoveMessage{GameID: id, Move: move}, &Client{ID: mover})
+		if err != nil {
+			t.Fatalf("move %s: %v", move, err)
+		}
+	}
+}
+
+// doRequest sends a request through the router and returns the recorded
+// response. A non-nil body is sent as JSON.
+func doRequest(t *testing.T, method string, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	recorder := httptest.NewRecorder()
+	NewRouter().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+// decodeJSON decodes a JSON response body into v.
+func decodeJSON(t *testing.T, recorder *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	err := json.Unmarshal(recorder.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", recorder.Body.String(), err)
+	}
+}
+
+// newTestServer serves the router over HTTP for websocket tests.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(NewRouter())
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+type testConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// dialWS connects to the websocket route with the given query, e.g.
+// "id=alice".
+func dialWS(t *testing.T, server *httptest.Server, query string) *testConn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		t.Fatalf("dialing %s: %v (status %d)", url, err, status)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testConn{t: t, conn: conn}
+	c.expect("hello")
+
+	return c
+}
+
+// dialPlayer connects a player with one running game and waits for the
+// resume messages, which end with the game's outcome.
+func dialPlayer(t *testing.T, server *httptest.Server, id string) *testConn {
+	t.Helper()
+
+	c := dialWS(t, server, "id="+id)
+	c.expect("outcome")
+
+	return c
+}
+
+// send writes a message with the payload encoded as JSON.
+func (c *testConn) send(msgType string, payload any) {
+	c.t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	err = c.conn.WriteJSON(WebsocketMessage{Type: msgType, Payload: string(data)})
+	if err != nil {
+		c.t.Fatalf("sending %s: %v", msgType, err)
+	}
+}
+
+// read returns the next message. ok is false when none arrives in time.
+func (c *testConn) read(timeout time.Duration) (WebsocketMessage, bool) {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var msg WebsocketMessage
+	err := c.conn.ReadJSON(&msg)
+	if err != nil {
+		return msg, false
+	}
+
+	return msg, true
+}
+
+// expect skips messages until one of the given type arrives and decodes
+// its payload into v, if v is not nil.
+func (c *testConn) expect(msgType string, v ...any) WebsocketMessage {
+	c.t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		msg, ok := c.read(time.Until(deadline))
+		if !ok {
+			break
+		}
+
+		if msg.Type != msgType {
+			continue
+		}
+
+		for _, target := range v {
+			err := json.Unmarshal([]byte(msg.Payload), target)
+			if err != nil {
+				c.t.Fatalf("decoding %s payload %q: %v", msgType, msg.Payload, err)
+			}
+		}
+
+		return msg
+	}
+
+	c.t.Fatalf("no %s message received", msgType)
+	return WebsocketMessage{}
+}
+
+// readUntil returns all messages up to and including the first one of the
+// given type.
+func (c *testConn) readUntil(msgType string) []WebsocketMessage {
+	c.t.Helper()
+
+	var msgs []WebsocketMessage
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		msg, ok := c.read(time.Until(deadline))
+		if !ok {
+			break
+		}
+
+		msgs = append(msgs, msg)
+		if msg.Type == msgType {
+			return msgs
+		}
+	}
+
+	c.t.Fatalf("no %s message received", msgType)
+	return nil
+}
+
+// expectNone fails when a message of the type arrives within the timeout.
+// The connection can't be read from afterwards.
+func (c *testConn) expectNone(msgType string, timeout time.Duration) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		msg, ok := c.read(time.Until(deadline))
+		if !ok {
+			return
+		}
+
+		if msg.Type == msgType {
+			c.t.Fatalf("unexpected %s message: %s", msgType, msg.Payload)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// decodeWebsocketPayload decodes the payload of an encoded websocket
+// message, as returned by the Generate*Message functions, into v.
+func decodeWebsocketPayload(t *testing.T, data []byte, v any) {
+	t.Helper()
+
+	var msg WebsocketMessage
+	err := json.Unmarshal(data, &msg)
+	if err != nil {
+		t.Fatalf("decoding message %q: %v", data, err)
+	}
+
+	err = json.Unmarshal([]byte(msg.Payload), v)
+	if err != nil {
+		t.Fatalf("decoding %s payload %q: %v", msg.Type, msg.Payload, err)
+	}
+}
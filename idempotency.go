@@ -0,0 +1,57 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const idempotencyKeyTTL = 24 * time.Hour
+
+type IdempotencyRecord struct {
+	GameID    string
+	ExpiresAt time.Time
+}
+
+// idempotencyKeys maps the Idempotency-Key header of POST /game to the
+// game that was created for it, so retried requests don't create
+// duplicate games. It is guarded by idempotencyMu.
+var idempotencyKeys = make(map[string]IdempotencyRecord)
+var idempotencyMu sync.Mutex
+
+// ReserveIdempotencyKey records gameID for the key unless the key is
+// already in use. Check and insert happen under one lock, so of two
+// concurrent requests with the same key only one creates a game. It
+// returns the game id recorded for the key and whether it was reserved
+// by this call.
+func ReserveIdempotencyKey(key string, gameID string, now time.Time) (string, bool) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	for k, record := range idempotencyKeys {
+		if now.After(record.ExpiresAt) {
+			delete(idempotencyKeys, k)
+		}
+	}
+
+	if record, ok := idempotencyKeys[key]; ok {
+		return record.GameID, false
+	}
+
+	idempotencyKeys[key] = IdempotencyRecord{
+		GameID:    gameID,
+		ExpiresAt: now.Add(idempotencyKeyTTL),
+	}
+
+	return gameID, true
+}
+
+// ReleaseIdempotencyKey frees a key reserved for gameID when the game
+// could not be created, so a retry may try again.
+func ReleaseIdempotencyKey(key string, gameID string) {
+	idempotencyMu.Lock()
+	defer idempotencyMu.Unlock()
+
+	if record, ok := idempotencyKeys[key]; ok && record.GameID == gameID {
+		delete(idempotencyKeys, key)
+	}
+}
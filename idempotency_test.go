@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createGameWithKey(t *testing.T, key string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/game", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	recorder := httptest.NewRecorder()
+	NewRouter().ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func gameCount() int {
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+
+	return len(games)
+}
+
+func TestIdempotencyKeyReturnsTheSameGame(t *testing.T) {
+	resetState(t)
+
+	body := `{"player1": "alice", "player2": "bob"}`
+
+	var first, second CreateGameResponse
+	decodeJSON(t, createGameWithKey(t, "key-1", body), &first)
+	decodeJSON(t, createGameWithKey(t, "key-1", body), &second)
+
+	if first.ID == "" || first.ID != second.ID {
+		t.Fatalf("ids %q and %q, want the same game", first.ID, second.ID)
+	}
+	if gameCount() != 1 {
+		t.Fatalf("%d games, want 1", gameCount())
+	}
+
+	var third CreateGameResponse
+	decodeJSON(t, createGameWithKey(t, "key-2", body), &third)
+	if third.ID == first.ID {
+		t.Fatal("a different key returned the same game")
+	}
+	if gameCount() != 2 {
+		t.Fatalf("%d games, want 2", gameCount())
+	}
+}
+
+func TestIdempotencyKeyConcurrentRetries(t *testing.T) {
+	resetState(t)
+
+	body := `{"player1": "alice", "player2": "bob"}`
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, 10)
+	for i := range recorders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			recorders[i] = createGameWithKey(t, "retry", body)
+		}()
+	}
+	wg.Wait()
+
+	// retries racing the unfinished first request are told to retry, all
+	// others get the game that was created
+	ids := make([]string, 0)
+	for _, recorder := range recorders {
+		if recorder.Code == http.StatusConflict {
+			continue
+		}
+
+		var response CreateGameResponse
+		decodeJSON(t, recorder, &response)
+		ids = append(ids, response.ID)
+	}
+
+	if len(ids) == 0 {
+		t.Fatal("no request created the game")
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("retries got different ids %q and %q", ids[0], id)
+		}
+	}
+	if _, ok := GetGame(ids[0]); !ok {
+		t.Fatalf("returned id %q of a game that doesn't exist", ids[0])
+	}
+	if gameCount() != 1 {
+		t.Fatalf("%d games, want 1", gameCount())
+	}
+}
+
+func TestIdempotencyKeyIsReleasedOnInvalidRequests(t *testing.T) {
+	resetState(t)
+
+	recorder := createGameWithKey(t, "key", `{"player1": "alice", "player2": "alice"}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", recorder.Code)
+	}
+
+	recorder = createGameWithKey(t, "key", `{"player1": "alice", "player2": "bob"}`)
+	if recorder.Code != http.StatusOK || gameCount() != 1 {
+		t.Fatalf("retry after a rejected request: status %d, %d games", recorder.Code, gameCount())
+	}
+}
+
+func TestIdempotencyKeyOfUnfinishedRequestConflicts(t *testing.T) {
+	resetState(t)
+
+	// the first request reserved the key but hasn't added its game yet
+	ReserveIdempotencyKey("key", "pending", time.Now())
+
+	recorder := createGameWithKey(t, "key", `{"player1": "alice", "player2": "bob"}`)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", recorder.Code)
+	}
+	if gameCount() != 0 {
+		t.Fatalf("%d games, want none", gameCount())
+	}
+}
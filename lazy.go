@@ -0,0 +1,224 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// storedIndex holds the persisted form of games that are not loaded into
+// the games map. It is only populated when LazyLoadGames is enabled.
+var storedIndex = make(StoredGames)
+
+// GetGame returns a game, restoring it from the index first if lazy
+// loading is enabled and it isn't loaded yet.
+func GetGame(id string) (*Game, bool) {
+	gamesMu.RLock()
+	game, ok := games[id]
+	gamesMu.RUnlock()
+
+	if ok {
+		game.touch()
+		return game, true
+	}
+
+	if !LazyLoadGames {
+		return nil, false
+	}
+
+	gamesMu.Lock()
+	defer gamesMu.Unlock()
+
+	// another caller may have restored it in the meantime
+	game, ok = games[id]
+	if ok {
+		game.touch()
+		return game, true
+	}
+
+	storedGame, ok := storedIndex[id]
+	if !ok {
+		return nil, false
+	}
+
+	game, err := RestoreGame(id, storedGame)
+	if err != nil {
+		slog.Error("restoring game failed", "game_id", id, "error", err)
+		return nil, false
+	}
+
+	unindexGame(id)
+	game.touch()
+	games[id] = game
+
+	EvictIdleGames(id)
+
+	return game, true
+}
+
+// EvictIdleGames moves the least recently used games back into the index
+// until at most MaxLoadedGames are loaded. Games that are still being
+// viewed and the game with the keep id are kept. The caller must hold
+// gamesMu.
+func EvictIdleGames(keep string) {
+	if !LazyLoadGames {
+		return
+	}
+
+	for len(games) > MaxLoadedGames {
+		var oldestId string
+		var oldest *Game
+
+		for id, game := range games {
+			if id == keep || IsGameViewed(id) {
+				continue
+			}
+
+			if oldest == nil || game.LastAccess().Before(oldest.LastAccess()) {
+				oldestId = id
+				oldest = game
+			}
+		}
+
+		if oldest == nil {
+			return
+		}
+
+		storedGame, err := StoreGame(oldest)
+		if err != nil {
+			slog.Error("storing game failed", "game_id", oldestId, "error", err)
+			return
+		}
+
+		stopEvictTimer(oldest)
+
+		indexGame(oldestId, storedGame, RecordGame(oldest))
+		delete(games, oldestId)
+	}
+}
+
+func IsGameViewed(id string) bool {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	return len(gameClients[id]) > 0
+}
+
+// GameRecord is what the readers of all games, like listings and stats,
+// need to know about a game. The index keeps one for every game that is
+// not loaded, so they don't have to restore it.
+type GameRecord struct {
+	WhitePlayerId string
+	BlackPlayerId string
+	Turn          chess.Color
+	Outcome       chess.Outcome
+	Method        string
+	MoveCount     int
+	ViewCount     int
+	// Deadline is when the side to move runs out of time, nil without a
+	// running clock.
+	Deadline   *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	LastAccess time.Time
+}
+
+// indexRecords holds the record of every game in storedIndex.
+var indexRecords = make(map[string]GameRecord)
+
+// RecordGame summarizes a game. The caller must hold game.mu for reading.
+func RecordGame(game *Game) GameRecord {
+	record := GameRecord{
+		WhitePlayerId: game.WhitePlayerId,
+		BlackPlayerId: game.BlackPlayerId,
+		Turn:          game.Game.Position().Turn(),
+		Outcome:       game.Game.Outcome(),
+		Method:        OutcomeMethod(game),
+		MoveCount:     len(game.Game.Moves()),
+		ViewCount:     game.ViewCount,
+		CreatedAt:     game.CreatedAt,
+		UpdatedAt:     game.UpdatedAt,
+		LastAccess:    game.LastAccess(),
+	}
+
+	if deadline, ok := MoveDeadline(game); ok {
+		record.Deadline = &deadline
+	}
+
+	return record
+}
+
+// RecordStoredGames restores each stored game once to record it. Games
+// that can't be restored are left out and skipped by the readers.
+func RecordStoredGames(storedGames StoredGames) map[string]GameRecord {
+	records := make(map[string]GameRecord, len(storedGames))
+
+	for id, storedGame := range storedGames {
+		game, err := NewGameFromStored(storedGame)
+		if err != nil {
+			slog.Error("restoring game failed", "game_id", id, "error", err)
+			continue
+		}
+
+		records[id] = RecordGame(game)
+	}
+
+	return records
+}
+
+// indexGame moves a game into the index. The caller must hold gamesMu.
+func indexGame(id string, storedGame StoredGame, record GameRecord) {
+	storedIndex[id] = storedGame
+	indexRecords[id] = record
+}
+
+// unindexGame drops a game from the index. The caller must hold gamesMu.
+func unindexGame(id string) {
+	delete(storedIndex, id)
+	delete(indexRecords, id)
+}
+
+// ForEachGame calls fn with the record of every loaded and every indexed
+// game, for readers that have to see all games like listings and stats.
+// Indexed games are not restored for it, so lazy mode stays within
+// MaxLoadedGames. fn runs without any lock held.
+func ForEachGame(fn func(id string, record GameRecord)) {
+	gamesMu.RLock()
+	records := make(map[string]GameRecord, len(games)+len(indexRecords))
+
+	for id, game := range games {
+		game.mu.RLock()
+		records[id] = RecordGame(game)
+		game.mu.RUnlock()
+	}
+
+	for id, record := range indexRecords {
+		records[id] = record
+	}
+	gamesMu.RUnlock()
+
+	for id, record := range records {
+		fn(id, record)
+	}
+}
+
+// IndexedTimeouts returns the indexed games whose side to move ran out of
+// time by now.
+func IndexedTimeouts(now time.Time) []string {
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+
+	ids := make([]string, 0)
+	for id, record := range indexRecords {
+		if record.Outcome != chess.NoOutcome || record.Deadline == nil {
+			continue
+		}
+
+		if !record.Deadline.After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
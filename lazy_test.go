@@ -0,0 +1,190 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// indexGames saves the games and reloads them in lazy mode, so all of them
+// end up in the index.
+func indexGames(t *testing.T, maxLoaded int) {
+	t.Helper()
+
+	lazy, max := LazyLoadGames, MaxLoadedGames
+	LazyLoadGames, MaxLoadedGames = true, maxLoaded
+	t.Cleanup(func() { LazyLoadGames, MaxLoadedGames = lazy, max })
+
+	err := SaveGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = LoadGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func isLoaded(id string) bool {
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+
+	_, ok := games[id]
+	return ok
+}
+
+func TestLazyGameLoadedOnFirstAccessAndAfterEviction(t *testing.T) {
+	resetState(t)
+
+	first := newTestGame(t, "first", CreateGameRequest{})
+	playMoves(t, "first", first, "e2e4", "e7e5")
+	newTestGame(t, "second", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+
+	indexGames(t, 1)
+
+	if isLoaded("first") || isLoaded("second") {
+		t.Fatal("games loaded at startup")
+	}
+
+	recorder := doRequest(t, "GET", "/game/first", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+	if !isLoaded("first") {
+		t.Fatal("game not loaded on first access")
+	}
+
+	// loading the second game evicts the first one
+	if _, ok := GetGame("second"); !ok {
+		t.Fatal("second game not found")
+	}
+	if isLoaded("first") {
+		t.Fatal("least recently used game not evicted")
+	}
+
+	var fens []string
+	decodeJSON(t, doRequest(t, "GET", "/game/first", nil), &fens)
+	if len(fens) != 3 || fens[2] != "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2" {
+		t.Errorf("reloaded game has positions %v", fens)
+	}
+}
+
+func TestLazyIndexedGamesAreListed(t *testing.T) {
+	resetState(t)
+
+	running := newTestGame(t, "running", CreateGameRequest{})
+	playMoves(t, "running", running, "e2e4")
+
+	mated := newTestGame(t, "mated", CreateGameRequest{})
+	playMoves(t, "mated", mated, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	indexGames(t, 10)
+
+	if summaries := ListGames("", ""); len(summaries) != 2 {
+		t.Errorf("listed %d games, want 2", len(summaries))
+	}
+
+	stats := ComputePlayerStats("alice")
+	if stats.Total.Losses != 1 {
+		t.Errorf("stats %+v, want the checkmate loss", stats.Total)
+	}
+
+	toMove := GamesAwaitingMove("bob")
+	if len(toMove) != 1 || toMove[0].GameID != "running" {
+		t.Errorf("bob to move in %v, want running", toMove)
+	}
+
+	active := PlayerActiveGames("alice")
+	if len(active) != 1 || active[0] != "running" {
+		t.Errorf("alice active in %v, want running", active)
+	}
+
+	// reading doesn't load anything
+	if isLoaded("running") || isLoaded("mated") {
+		t.Error("listing loaded indexed games")
+	}
+}
+
+func TestLazySweepFlagsIndexedGames(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "timed", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 60},
+		BlackClock:     &ClockSettings{BaseSeconds: 60},
+	})
+	playMoves(t, "timed", game, "e2e4")
+
+	indexGames(t, 10)
+
+	if flagged := SweepClocks(time.Now()); len(flagged) != 0 {
+		t.Fatalf("flagged %v before the time ran out", flagged)
+	}
+
+	flagged := SweepClocks(time.Now().Add(2 * time.Minute))
+	if len(flagged) != 1 || flagged[0] != "timed" {
+		t.Fatalf("flagged %v, want timed", flagged)
+	}
+
+	loaded, ok := GetGame("timed")
+	if !ok {
+		t.Fatal("game not found")
+	}
+	if !loaded.TimedOut || loaded.Game.Outcome() != chess.WhiteWon {
+		t.Errorf("game ended %s, timed out %v", loaded.Game.Outcome(), loaded.TimedOut)
+	}
+}
+
+func TestLazyEvictedGamesKeepTheirRecord(t *testing.T) {
+	resetState(t)
+
+	first := newTestGame(t, "first", CreateGameRequest{})
+	playMoves(t, "first", first, "e2e4", "e7e5")
+	newTestGame(t, "second", CreateGameRequest{})
+
+	indexGames(t, 1)
+
+	if _, ok := GetGame("first"); !ok {
+		t.Fatal("first game not found")
+	}
+	if _, ok := GetGame("second"); !ok {
+		t.Fatal("second game not found")
+	}
+	if isLoaded("first") {
+		t.Fatal("least recently used game not evicted")
+	}
+
+	for _, summary := range ListGames("", GameSortMoves) {
+		if summary.ID == "first" && summary.MoveCount != 2 {
+			t.Errorf("evicted game listed with %d moves", summary.MoveCount)
+		}
+	}
+}
+
+func TestEagerGetGameSharesTheLock(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	gamesMu.RLock()
+	defer gamesMu.RUnlock()
+
+	found := make(chan bool)
+	go func() {
+		_, ok := GetGame("game-1")
+		found <- ok
+	}()
+
+	select {
+	case ok := <-found:
+		if !ok {
+			t.Error("game not found")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetGame waited for readers of the games")
+	}
+}
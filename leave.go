@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/notnil/chess"
+)
+
+type LeaveMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type PlayerLeftMessage struct {
+	GameID   string `json:"gameId"`
+	PlayerID string `json:"playerId"`
+	Color    string `json:"color"`
+}
+
+// HandleLeave detaches the client from the game and tells the opponent.
+// A player leaving an unfinished game marks it as abandoned until a
+// player joins it again. Observers only drop their subscription, even
+// with the id of a player.
+func HandleLeave(wsMsg WebsocketMessage, client *Client) error {
+	var leave LeaveMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &leave)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(leave.GameID)
+	if !ok {
+		return SendError(client, leave.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	if gameID, _ := ClientGame(client); gameID == leave.GameID {
+		SetClientGame(client, "", false)
+	}
+
+	if client.Observer {
+		return nil
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return nil
+	}
+
+	game.mu.Lock()
+	abandoned := game.Game.Outcome() == chess.NoOutcome && !game.Abandoned
+	if abandoned {
+		game.Abandoned = true
+	}
+	game.mu.Unlock()
+
+	if abandoned {
+		err = SaveGame(leave.GameID)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(PlayerLeftMessage{
+		GameID:   leave.GameID,
+		PlayerID: client.ID,
+		Color:    ColorCode(color),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "playerLeft",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	SendToPlayer(leave.GameID, PlayerIdForColor(game, color.Other()), data)
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func isConnected(playerID string) bool {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range connectedClients {
+		if client.ID == playerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestLeaveRemovesClientAndNotifiesOpponent(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.send("leave", LeaveMessage{GameID: "game-1"})
+
+	var left PlayerLeftMessage
+	bob.expect("playerLeft", &left)
+	if left.GameID != "game-1" || left.PlayerID != "alice" || left.Color != ColorWhite {
+		t.Fatalf("playerLeft %+v, want alice with white in game-1", left)
+	}
+
+	waitFor(t, "alice to be unregistered", func() bool { return !isConnected("alice") })
+
+	if IsPlayerConnected("game-1", "alice") {
+		t.Error("alice still receives the updates of game-1")
+	}
+
+	game.mu.RLock()
+	abandoned := game.Abandoned
+	game.mu.RUnlock()
+	if !abandoned {
+		t.Error("game not marked abandoned")
+	}
+
+	// the server closes the connection after the leave
+	if msg, ok := alice.read(time.Second); ok {
+		t.Errorf("connection still open, got %s", msg.Type)
+	}
+}
+
+func TestObserverLeaveKeepsTheGame(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	observer := dialWS(t, server, "id=alice&role=observer")
+	observer.send("join", JoinMessage{GameID: "game-1"})
+	observer.expect("players")
+
+	observer.send("leave", LeaveMessage{GameID: "game-1"})
+	waitFor(t, "the observer to be unregistered", func() bool {
+		clientsMu.RLock()
+		defer clientsMu.RUnlock()
+
+		return len(connectedClients) == 2
+	})
+
+	game.mu.RLock()
+	abandoned := game.Abandoned
+	game.mu.RUnlock()
+	if abandoned {
+		t.Error("observer leaving marked the game abandoned")
+	}
+
+	if !IsPlayerConnected("game-1", "alice") {
+		t.Error("alice lost the updates of game-1")
+	}
+
+	// the game goes on for both players
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck")
+	bob.expect("move")
+	bob.expectNone("playerLeft", 200*time.Millisecond)
+}
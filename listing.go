@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+const (
+	GameStatusActive   = "active"
+	GameStatusFinished = "finished"
+)
+
+// Sort orders of the games listing. Created and updated list the newest
+// games first, moves the longest. Ties and the default order go by id.
+const (
+	GameSortCreated = "created"
+	GameSortMoves   = "moves"
+	GameSortUpdated = "updated"
+)
+
+type GameSummary struct {
+	ID            string    `json:"id"`
+	WhitePlayerId string    `json:"whitePlayerId"`
+	BlackPlayerId string    `json:"blackPlayerId"`
+	Turn          string    `json:"turn"`
+	Outcome       string    `json:"outcome"`
+	MoveCount     int       `json:"moveCount"`
+	Spectators    int       `json:"spectators"`
+	ViewCount     int       `json:"viewCount"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func IsValidGameSort(sortBy string) bool {
+	return sortBy == GameSortCreated || sortBy == GameSortMoves || sortBy == GameSortUpdated
+}
+
+// ListGames summarizes the games, optionally only the active or finished
+// ones, in the given sort order. An empty status lists all of them.
+func ListGames(status string, sortBy string) []GameSummary {
+	summaries := make([]GameSummary, 0)
+
+	ForEachGame(func(id string, record GameRecord) {
+		finished := record.Outcome != chess.NoOutcome
+
+		if (status == GameStatusActive && finished) || (status == GameStatusFinished && !finished) {
+			return
+		}
+
+		summaries = append(summaries, GameSummary{
+			ID:            id,
+			WhitePlayerId: record.WhitePlayerId,
+			BlackPlayerId: record.BlackPlayerId,
+			Turn:          ColorCode(record.Turn),
+			Outcome:       record.Outcome.String(),
+			MoveCount:     record.MoveCount,
+			Spectators:    SpectatorCount(id),
+			ViewCount:     record.ViewCount,
+			CreatedAt:     record.CreatedAt,
+			UpdatedAt:     record.UpdatedAt,
+		})
+	})
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+
+		switch sortBy {
+		case GameSortCreated:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.After(b.CreatedAt)
+			}
+		case GameSortMoves:
+			if a.MoveCount != b.MoveCount {
+				return a.MoveCount > b.MoveCount
+			}
+		case GameSortUpdated:
+			if !a.UpdatedAt.Equal(b.UpdatedAt) {
+				return a.UpdatedAt.After(b.UpdatedAt)
+			}
+		}
+
+		return a.ID < b.ID
+	})
+
+	return summaries
+}
+
+// PageGameSummaries returns up to limit summaries starting at offset, or
+// all of them from offset on when limit is 0.
+func PageGameSummaries(summaries []GameSummary, offset int, limit int) []GameSummary {
+	if offset >= len(summaries) {
+		return make([]GameSummary, 0)
+	}
+
+	summaries = summaries[offset:]
+	if limit > 0 && limit < len(summaries) {
+		summaries = summaries[:limit]
+	}
+
+	return summaries
+}
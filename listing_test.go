@@ -0,0 +1,143 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listGames(t *testing.T, query string) []GameSummary {
+	t.Helper()
+
+	recorder := doRequest(t, "GET", "/games"+query, nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var summaries []GameSummary
+	decodeJSON(t, recorder, &summaries)
+
+	return summaries
+}
+
+func TestListGamesEmpty(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "GET", "/games", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if body := recorder.Body.String(); body != "[]" {
+		t.Errorf("body %s, want an empty array", body)
+	}
+}
+
+func TestListGamesFiltersByStatus(t *testing.T) {
+	resetState(t)
+
+	active := newTestGame(t, "active", CreateGameRequest{})
+	playMoves(t, "active", active, "e2e4")
+	active.ViewCount = 3
+
+	finished := newTestGame(t, "finished", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+	playMoves(t, "finished", finished, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	all := listGames(t, "")
+	if len(all) != 2 {
+		t.Fatalf("%d games listed, want 2", len(all))
+	}
+
+	summaries := listGames(t, "?status=active")
+	if len(summaries) != 1 {
+		t.Fatalf("%d active games, want 1", len(summaries))
+	}
+	want := GameSummary{
+		ID:            "active",
+		WhitePlayerId: "alice",
+		BlackPlayerId: "bob",
+		Turn:          ColorBlack,
+		Outcome:       "*",
+		MoveCount:     1,
+		ViewCount:     3,
+	}
+	got := summaries[0]
+	got.CreatedAt, got.UpdatedAt = want.CreatedAt, want.UpdatedAt
+	if got != want {
+		t.Errorf("active summary %+v, want %+v", got, want)
+	}
+
+	summaries = listGames(t, "?status=finished")
+	if len(summaries) != 1 || summaries[0].ID != "finished" {
+		t.Fatalf("finished games %+v, want only finished", summaries)
+	}
+	if summaries[0].Outcome != "0-1" || summaries[0].MoveCount != 4 {
+		t.Errorf("finished summary %+v, want 0-1 after 4 moves", summaries[0])
+	}
+}
+
+func listedIDs(summaries []GameSummary) []string {
+	ids := make([]string, 0, len(summaries))
+	for _, summary := range summaries {
+		ids = append(ids, summary.ID)
+	}
+
+	return ids
+}
+
+func TestListGamesSortsAndPages(t *testing.T) {
+	resetState(t)
+
+	start := time.Now()
+
+	a := newTestGame(t, "a", CreateGameRequest{})
+	b := newTestGame(t, "b", CreateGameRequest{})
+	c := newTestGame(t, "c", CreateGameRequest{})
+	playMoves(t, "a", a, "e2e4")
+	playMoves(t, "b", b, "e2e4", "e7e5", "g1f3")
+	playMoves(t, "c", c, "d2d4", "d7d5")
+
+	a.CreatedAt, a.UpdatedAt = start.Add(3*time.Second), start.Add(4*time.Second)
+	b.CreatedAt, b.UpdatedAt = start.Add(1*time.Second), start.Add(5*time.Second)
+	c.CreatedAt, c.UpdatedAt = start.Add(2*time.Second), start.Add(6*time.Second)
+
+	for sortBy, want := range map[string][]string{
+		"":        {"a", "b", "c"},
+		"created": {"a", "c", "b"},
+		"moves":   {"b", "c", "a"},
+		"updated": {"c", "b", "a"},
+	} {
+		if got := listedIDs(listGames(t, "?sort="+sortBy)); !reflect.DeepEqual(got, want) {
+			t.Errorf("sorted by %q: %v, want %v", sortBy, got, want)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"?sort=moves&limit=2", []string{"b", "c"}},
+		{"?sort=moves&limit=2&offset=2", []string{"a"}},
+		{"?sort=moves&offset=1", []string{"c", "a"}},
+		{"?sort=moves&offset=3", []string{}},
+	} {
+		recorder := doRequest(t, "GET", "/games"+test.query, nil)
+		if recorder.Code != 200 {
+			t.Fatalf("%s: status %d: %s", test.query, recorder.Code, recorder.Body.String())
+		}
+		if total := recorder.Header().Get("X-Total-Count"); total != "3" {
+			t.Errorf("%s: total count %q, want 3", test.query, total)
+		}
+
+		var summaries []GameSummary
+		decodeJSON(t, recorder, &summaries)
+		if got := listedIDs(summaries); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"?sort=name", "?limit=0", "?offset=-1"} {
+		if recorder := doRequest(t, "GET", "/games"+query, nil); recorder.Code != 400 {
+			t.Errorf("%s: status %d, want 400", query, recorder.Code)
+		}
+	}
+}
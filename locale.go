@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultLocale is used for connections that don't ask for a locale and
+// for keys missing in a locale's catalog.
+const DefaultLocale = "en"
+
+// catalog holds the human-readable texts per locale. Machine-readable
+// fields like error codes and outcome methods are never translated. Keys
+// missing in English fall back to the text given by the caller.
+var catalog = map[string]map[string]string{
+	"en": {
+		"outcome.whiteWon": "White won by %s",
+		"outcome.blackWon": "Black won by %s",
+		"outcome.draw":     "Draw by %s",
+
+		"method.Checkmate":            "checkmate",
+		"method.Resignation":          "resignation",
+		"method.DrawOffer":            "agreement",
+		"method.Stalemate":            "stalemate",
+		"method.ThreefoldRepetition":  "threefold repetition",
+		"method.FivefoldRepetition":   "fivefold repetition",
+		"method.FiftyMoveRule":        "fifty-move rule",
+		"method.SeventyFiveMoveRule":  "seventy-five-move rule",
+		"method.InsufficientMaterial": "insufficient material",
+		"method.Timeout":              "timeout",
+	},
+	"de": {
+		"outcome.whiteWon": "Weiß gewinnt durch %s",
+		"outcome.blackWon": "Schwarz gewinnt durch %s",
+		"outcome.draw":     "Remis durch %s",
+
+		"method.Checkmate":            "Schachmatt",
+		"method.Resignation":          "Aufgabe",
+		"method.DrawOffer":            "Einigung",
+		"method.Stalemate":            "Patt",
+		"method.ThreefoldRepetition":  "dreifache Stellungswiederholung",
+		"method.FivefoldRepetition":   "fünffache Stellungswiederholung",
+		"method.FiftyMoveRule":        "die 50-Züge-Regel",
+		"method.SeventyFiveMoveRule":  "die 75-Züge-Regel",
+		"method.InsufficientMaterial": "ungenügendes Material",
+		"method.Timeout":              "Zeitüberschreitung",
+
+		"error." + ErrorCodeGameNotFound:     "Partie nicht gefunden",
+		"error." + ErrorCodeReadOnly:         "Diese Verbindung ist schreibgeschützt",
+		"error." + ErrorCodeUnknownType:      "Unbekannter Nachrichtentyp",
+		"error." + ErrorCodeInvalidSquare:    "Ungültiges Feld",
+		"error." + ErrorCodeNotAPlayer:       "Nur Spieler können das tun",
+		"error." + ErrorCodeGameOver:         "Die Partie ist beendet",
+		"error." + ErrorCodeNoDrawOffer:      "Kein offenes Remisangebot",
+		"error." + ErrorCodeDrawOfferExpired: "Das Remisangebot ist abgelaufen",
+		"error." + ErrorCodeNotYourTurn:      "Du bist nicht am Zug",
+		"error." + ErrorCodeIllegalMove:      "Ungültiger Zug",
+		"error." + ErrorCodeNotReady:         "Die Spieler sind noch nicht bereit",
+		"error." + ErrorCodeNoClock:          "Die Partie hat keine Uhr",
+		"error." + ErrorCodeInvalidAmount:    "Ungültige Zeitangabe",
+		"error." + ErrorCodeRateLimited:      "Zu viele Anfragen",
+		"error." + ErrorCodeNoMoves:          "Keine Züge zum Zurücknehmen",
+		"error." + ErrorCodeNoTakeback:       "Keine Zugrücknahme angefragt",
+		"error." + ErrorCodeGameNotOver:      "Die Partie läuft noch",
+		"error." + ErrorCodeDrawNotClaimable: "In dieser Stellung kann kein Remis beansprucht werden",
+		"error." + ErrorCodeGameFull:         "Die Partie ist voll",
+		"error." + ErrorCodeInvalidChat:      "Chatnachrichten dürfen nicht leer oder zu lang sein",
+		"error." + ErrorCodeDrawsDisabled:    "Remis sind in dieser Partie deaktiviert",
+		"error." + ErrorCodeDrawOfferPending: "Beantworte zuerst das Remisangebot des Gegners",
+	},
+}
+
+// ParseLocale maps a requested locale like "de-DE" to a supported one,
+// falling back to DefaultLocale.
+func ParseLocale(locale string) string {
+	locale = strings.ToLower(locale)
+	if _, ok := catalog[locale]; ok {
+		return locale
+	}
+
+	base, _, _ := strings.Cut(locale, "-")
+	if _, ok := catalog[base]; ok {
+		return base
+	}
+
+	return DefaultLocale
+}
+
+// Translate looks up key in the locale's catalog, then in the default
+// catalog. It returns fallback when neither has the key.
+func Translate(locale string, key string, fallback string) string {
+	if text, ok := catalog[locale][key]; ok {
+		return text
+	}
+
+	if text, ok := catalog[DefaultLocale][key]; ok {
+		return text
+	}
+
+	return fallback
+}
+
+func OutcomeText(locale string, key string, method string) string {
+	return fmt.Sprintf(Translate(locale, key, "%s"), OutcomeReason(locale, method))
+}
+
+// OutcomeReason returns the human-readable form of an outcome method like
+// "insufficient material".
+func OutcomeReason(locale string, method string) string {
+	return Translate(locale, "method."+method, method)
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestOutcomeTextIsLocalized(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "f2f3", "e7e5", "g2g4")
+
+	server := newTestServer(t)
+	alice := dialWS(t, server, "id=alice&locale=de-DE")
+	alice.expect("outcome")
+	bob := dialPlayer(t, server, "bob")
+
+	bob.send("move", MoveMessage{GameID: "game-1", Move: "d8h4"})
+
+	for _, c := range []struct {
+		conn *testConn
+		text string
+	}{
+		{alice, "Schwarz gewinnt durch Schachmatt"},
+		{bob, "Black won by checkmate"},
+	} {
+		var outcome OutcomeMessage
+		c.conn.expect("outcome", &outcome)
+		if outcome.Text != c.text {
+			t.Errorf("outcome text %q, want %q", outcome.Text, c.text)
+		}
+
+		// the machine-readable fields are the same in every locale
+		if outcome.Outcome != "0-1" || outcome.Winner != ColorBlack || outcome.Method != "Checkmate" {
+			t.Errorf("outcome %s/%s/%s, want 0-1/%s/Checkmate", outcome.Outcome, outcome.Winner, outcome.Method, ColorBlack)
+		}
+	}
+}
+
+func TestErrorTextIsLocalized(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+	alice := dialWS(t, server, "id=alice&locale=de")
+	bob := dialWS(t, server, "id=bob&locale=fr")
+
+	for _, c := range []struct {
+		conn    *testConn
+		message string
+	}{
+		{alice, "Partie nicht gefunden"},
+		// unsupported locales fall back to English
+		{bob, "Game not found"},
+	} {
+		c.conn.send("move", MoveMessage{GameID: "missing", Move: "e2e4"})
+
+		var errMsg ErrorMessage
+		c.conn.expect("error", &errMsg)
+		if errMsg.Code != ErrorCodeGameNotFound {
+			t.Errorf("error code %q, want %s", errMsg.Code, ErrorCodeGameNotFound)
+		}
+		if errMsg.Message != c.message {
+			t.Errorf("error message %q, want %q", errMsg.Message, c.message)
+		}
+	}
+}
+
+func TestParseLocale(t *testing.T) {
+	for requested, want := range map[string]string{
+		"":      DefaultLocale,
+		"de":    "de",
+		"de-AT": "de",
+		"DE":    "de",
+		"fr-FR": DefaultLocale,
+	} {
+		if got := ParseLocale(requested); got != want {
+			t.Errorf("ParseLocale(%q) = %q, want %q", requested, got, want)
+		}
+	}
+}
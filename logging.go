@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+)
+
+// LogLevel is the minimum level that is logged. It is set with LOG_LEVEL
+// to "debug", "info", "warn" or "error".
+var LogLevel = slog.LevelInfo
+
+// SetupLogging makes the default logger write JSON lines to stdout.
+func SetupLogging() {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: LogLevel})
+	slog.SetDefault(slog.New(handler))
+}
+
+// clientErrors are handler errors caused by what the client sent. They
+// are logged as warnings, everything else as errors.
+var clientErrors = []error{
+	ErrInvalidMove,
+	ErrNotYourTurn,
+	ErrGameOver,
+	ErrNotReady,
+	ErrGameFull,
+}
+
+// LogMessageError logs an error returned by a websocket message handler
+// with the message type, the client and the game the message was about.
+func LogMessageError(client *Client, wsMsg WebsocketMessage, err error) {
+	// nearly every message carries the game id, messages without one are
+	// logged without it
+	var target struct {
+		GameID string `json:"gameId"`
+	}
+	_ = json.Unmarshal([]byte(wsMsg.Payload), &target)
+
+	level := slog.LevelError
+	for _, clientErr := range clientErrors {
+		if errors.Is(err, clientErr) {
+			level = slog.LevelWarn
+			break
+		}
+	}
+
+	slog.Log(context.Background(), level, "handling message failed",
+		"type", wsMsg.Type,
+		"client_id", client.ID,
+		"game_id", target.GameID,
+		"error", err,
+	)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// recordingHandler keeps every record logged through it.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, record.Clone())
+
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler      { return h }
+
+// find returns the first record with the message, if any.
+func (h *recordingHandler) find(message string) (slog.Record, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, record := range h.records {
+		if record.Message == message {
+			return record, true
+		}
+	}
+
+	return slog.Record{}, false
+}
+
+// recordLogs makes the default logger record into the returned handler
+// for the rest of the test.
+func recordLogs(t *testing.T) *recordingHandler {
+	t.Helper()
+
+	handler := &recordingHandler{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return handler
+}
+
+func TestIllegalMoveLogsWarningWithGameID(t *testing.T) {
+	resetState(t)
+	logs := recordLogs(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e5"})
+	alice.expect("error")
+
+	var record slog.Record
+	waitFor(t, "the failed move to be logged", func() bool {
+		var ok bool
+		record, ok = logs.find("handling message failed")
+		return ok
+	})
+
+	if record.Level != slog.LevelWarn {
+		t.Errorf("logged at %s, want %s", record.Level, slog.LevelWarn)
+	}
+
+	attrs := make(map[string]string)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value.String()
+		return true
+	})
+	if attrs["game_id"] != "game-1" || attrs["client_id"] != "alice" || attrs["type"] != "move" {
+		t.Errorf("logged with %v, want the game, client and message type", attrs)
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"math/rand/v2"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,6 +25,43 @@ import (
 	"github.com/notnil/chess"
 )
 
+const StartingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// SetFENSideToMove replaces the active color field of a FEN.
+func SetFENSideToMove(fenStr string, color string) (string, error) {
+	if color != ColorWhite && color != ColorBlack {
+		return "", errors.New("Invalid side to move")
+	}
+
+	fields := strings.Fields(fenStr)
+	if len(fields) != 6 {
+		return "", errors.New("Invalid FEN")
+	}
+
+	fields[1] = color
+
+	return strings.Join(fields, " "), nil
+}
+
+// Colors are always sent as the FEN color codes "w" and "b" in every
+// message and endpoint (against, outcome, state, turn, clock). Human-readable
+// names only appear in separate text fields.
+const (
+	ColorWhite = "w"
+	ColorBlack = "b"
+)
+
+func ColorCode(color chess.Color) string {
+	switch color {
+	case chess.White:
+		return ColorWhite
+	case chess.Black:
+		return ColorBlack
+	}
+
+	return ""
+}
+
 type MoveMessage struct {
 	GameID string `json:"gameId"`
 	Color  string `json:"color"`
@@ -21,8 +69,97 @@ type MoveMessage struct {
 }
 
 type MoveAnswer struct {
+	GameID string `json:"gameId"`
+	// Move is empty when the answer reports an accepted takeback.
+	Move string `json:"move"`
+	// Fen is the position after the move or the takeback.
+	Fen string `json:"fen"`
+	// Seq is the game's broadcast sequence number for this move, Ply the
+	// number of half moves played after it.
+	Seq int `json:"seq"`
+	Ply int `json:"ply"`
+	// InCheck, IsCheckmate and IsStalemate describe the position after the
+	// move. LastMoveUci is the move in UCI whatever the game's notation.
+	InCheck     bool   `json:"inCheck"`
+	IsCheckmate bool   `json:"isCheckmate"`
+	IsStalemate bool   `json:"isStalemate"`
+	LastMoveUci string `json:"lastMoveUci"`
+	// LastMove is the same move split into squares for highlighting.
+	LastMove *LastMove `json:"lastMove"`
+}
+
+// LastMove is the most recent move of a game. Promotion is the piece a pawn
+// promoted to, if any.
+type LastMove struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Promotion string `json:"promotion,omitempty"`
+}
+
+// GameLastMove returns the last move of the game, or nil if no move has
+// been played yet.
+func GameLastMove(game *Game) *LastMove {
+	moves := game.Game.Moves()
+	if len(moves) == 0 {
+		return nil
+	}
+
+	m := moves[len(moves)-1]
+
+	return &LastMove{
+		From:      m.S1().String(),
+		To:        m.S2().String(),
+		Promotion: m.Promo().String(),
+	}
+}
+
+// MoveAck tells the client that submitted a move which sequence number
+// and ply the server assigned to it.
+type MoveAck struct {
 	GameID string `json:"gameId"`
 	Move   string `json:"move"`
+	Seq    int    `json:"seq"`
+	Ply    int    `json:"ply"`
+	Fen    string `json:"fen"`
+}
+
+type OutcomeMessage struct {
+	GameID  string `json:"gameId"`
+	Outcome string `json:"outcome"`
+	// Winner is a color code, empty for draws.
+	Winner string `json:"winner"`
+	Method string `json:"method"`
+	// Reason is the human-readable method like "insufficient material",
+	// empty while the game is running.
+	Reason string `json:"reason"`
+	// Text is a human-readable summary like "White won by checkmate".
+	Text string `json:"text"`
+}
+
+type ValidateBatchMessage struct {
+	GameID string   `json:"gameId"`
+	Moves  []string `json:"moves"`
+}
+
+type MoveValidation struct {
+	Move    string `json:"move"`
+	Legal   bool   `json:"legal"`
+	Check   bool   `json:"check"`
+	Capture bool   `json:"capture"`
+}
+
+type ValidateBatchAnswer struct {
+	GameID  string           `json:"gameId"`
+	Results []MoveValidation `json:"results"`
+}
+
+type GetPgnMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type PgnAnswer struct {
+	GameID string `json:"gameId"`
+	Pgn    string `json:"pgn"`
 }
 
 type JoinMessage struct {
@@ -32,6 +169,20 @@ type JoinMessage struct {
 type AgainstMessage struct {
 	ID    string `json:"id"`
 	Color string `json:"color"`
+	// LastMove is the last move played before joining, nil for a new game.
+	LastMove *LastMove `json:"lastMove"`
+}
+
+type SpectateMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type SpectatorMessage struct {
+	GameID        string    `json:"gameId"`
+	WhitePlayerId string    `json:"whitePlayerId"`
+	BlackPlayerId string    `json:"blackPlayerId"`
+	Role          string    `json:"role"`
+	LastMove      *LastMove `json:"lastMove"`
 }
 
 type WebsocketMessage struct {
@@ -39,433 +190,2830 @@ type WebsocketMessage struct {
 	Payload string `json:"payload"`
 }
 
+type SwitchMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type StateMessage struct {
+	GameID        string   `json:"gameId"`
+	Fen           string   `json:"fen"`
+	Turn          string   `json:"turn"`
+	Outcome       string   `json:"outcome"`
+	Moves         []string `json:"moves"`
+	Notation      string   `json:"notation"`
+	DrawsDisabled bool     `json:"drawsDisabled"`
+	ViewCount     int      `json:"viewCount"`
+}
+
 type HelloMessage struct {
 	ID string `json:"id"`
+	// ReconnectToken resumes this identity on a later connection via the
+	// reconnectToken query parameter. It can be used once.
+	ReconnectToken string `json:"reconnectToken"`
+	// GameID is the resumed game when the connection used a token.
+	GameID string `json:"gameId,omitempty"`
 }
 
 type Client struct {
 	ID   string
 	Conn *websocket.Conn
+	// Observer connections are read-only: every mutating message is
+	// rejected, whatever seat the id holds.
+	Observer bool
+	// IllegalMoves counts consecutive rejected moves and is reset by
+	// every legal move.
+	IllegalMoves int
+	// GameID is the game the connection is currently viewing. Move
+	// broadcasts are only delivered for this game. It is guarded by
+	// clientsMu, read it with ClientGame.
+	GameID string
+	// Locale selects the language of human-readable message texts.
+	Locale string
+	// ReconnectToken is the token issued to this connection.
+	ReconnectToken string
+	// Spectator marks a client that views GameID read-only, either
+	// because it is not a player or because it sent "spectate". It is
+	// guarded by clientsMu like GameID.
+	Spectator bool
+	// writeMu serializes the writes to Conn, which come from the client's
+	// read loop, the heartbeat and the broadcasts of other connections.
+	writeMu sync.Mutex
+}
+
+// Send writes a text message to the client's connection. All writes must
+// go through Send or SendControl, a websocket connection supports only
+// one concurrent writer.
+func (client *Client) Send(data []byte) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+
+	return client.Conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// SendControl writes a control message like a ping or a close frame to
+// the client's connection.
+func (client *Client) SendControl(messageType int, data []byte, deadline time.Time) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+
+	return client.Conn.WriteControl(messageType, data, deadline)
 }
 
 type Game struct {
 	WhitePlayerId string `json:"whitePlayerId"`
 	BlackPlayerId string `json:"blackPlayerId"`
 	Game          *chess.Game
+	Clock         *Clock
+	// DrawsDisabled forbids draws by agreement. Automatic draws and draw
+	// claims still apply.
+	DrawsDisabled bool
+	// Notation is the name of the notation moves are parsed and reported
+	// in, see notations.
+	Notation string
+	// TimedOut marks a game lost on time.
+	TimedOut bool
+	// Variations are analysis side lines attached to the main line.
+	Variations []Variation
+	// Annotations holds NAGs and comments keyed by ply, 1 being white's
+	// first move.
+	Annotations map[int]Annotation
+	// RequireReady blocks the first move until both players sent a
+	// "ready" message.
+	RequireReady bool
+	WhiteReady   bool
+	BlackReady   bool
+	// ViewCount counts spectator joins over the lifetime of the game.
+	ViewCount int
+	// Sequence is incremented for every applied move and never goes
+	// back, so clients can order and reconcile move broadcasts.
+	Sequence int
+	// DrawOffer is the pending draw offer, if any. It is not persisted.
+	DrawOffer *DrawOffer
+	// TakebackRequest is the pending takeback request, if any. It is not
+	// persisted.
+	TakebackRequest *TakebackRequest
+	// WhiteAddedTimeAt and BlackAddedTimeAt rate limit addTime grants.
+	WhiteAddedTimeAt time.Time
+	BlackAddedTimeAt time.Time
+	// WhiteRematch and BlackRematch record who asked for a rematch,
+	// RematchGameID is the rematch once both did. They are not persisted.
+	WhiteRematch  bool
+	BlackRematch  bool
+	RematchGameID string
+	// Chat holds the latest chat messages. It is not persisted.
+	Chat []ChatEntry
+	// Abandoned is set when a player leaves the unfinished game and
+	// cleared when a player joins it again.
+	Abandoned bool
+	// BotDifficulty is how strong the bot plays in games against it.
+	BotDifficulty string
+	// FinishedAt is set by FinalizeGame once the game has an outcome.
+	FinishedAt time.Time
+	// CreatedAt is when the game was created, UpdatedAt when its last
+	// move was played. The games listing sorts by them.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// mu serializes changes to the game, see ApplyMove. Readers take the
+	// read lock.
+	mu sync.RWMutex
+	// evictTimer is guarded by mu, see stopEvictTimer.
+	evictTimer *time.Timer
+	// lastAccess is when GetGame last returned the game, in unix
+	// nanoseconds. GetGame only holds gamesMu for reading.
+	lastAccess atomic.Int64
+}
+
+func (game *Game) touch() {
+	game.lastAccess.Store(time.Now().UnixNano())
+}
+
+// LastAccess returns when GetGame last returned the game, the zero time
+// for games restored from the store and not accessed since.
+func (game *Game) LastAccess() time.Time {
+	nanos := game.lastAccess.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, nanos)
 }
 
 type StoredGames map[string]StoredGame
 
+// StoredGameVersion is the current version of the persisted game format.
+// Bump it together with a new step in MigrateStoredGame when StoredGame
+// changes.
+const StoredGameVersion = 2
+
 type StoredGame struct {
-	PGNStr        string `json:"pgn"`
-	WhitePlayerId string `json:"whitePlayerId"`
-	BlackPlayerId string `json:"blackPlayerId"`
+	Version       int                `json:"version"`
+	PGNStr        string             `json:"pgn"`
+	WhitePlayerId string             `json:"whitePlayerId"`
+	BlackPlayerId string             `json:"blackPlayerId"`
+	Clock         *Clock             `json:"clock,omitempty"`
+	DrawsDisabled bool               `json:"drawsDisabled,omitempty"`
+	Notation      string             `json:"notation"`
+	TimedOut      bool               `json:"timedOut,omitempty"`
+	Variations    []Variation        `json:"variations,omitempty"`
+	Annotations   map[int]Annotation `json:"annotations,omitempty"`
+	RequireReady  bool               `json:"requireReady,omitempty"`
+	ViewCount     int                `json:"viewCount,omitempty"`
+	Sequence      int                `json:"sequence,omitempty"`
+	Abandoned     bool               `json:"abandoned,omitempty"`
+	BotDifficulty string             `json:"botDifficulty,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt"`
+}
+
+type SimulateRequest struct {
+	Moves []string `json:"moves"`
 }
 
 type CreateGameRequest struct {
 	Player1        string `json:"player1"`
 	Player2        string `json:"player2"`
 	PreferredColor string `json:"preferredColor"`
+	// TrainingCategory starts the game from a random position with the
+	// given material, e.g. "KQ vs K".
+	TrainingCategory string `json:"trainingCategory"`
+	// StartingFen starts the game from a custom position, e.g. a puzzle.
+	// It can't be combined with TrainingCategory.
+	StartingFen string `json:"startingFen"`
+	// WhiteClock and BlackClock enable a clock. When only one is given
+	// both sides use it.
+	WhiteClock *ClockSettings `json:"whiteClock"`
+	BlackClock *ClockSettings `json:"blackClock"`
+	// InitialSeconds and IncrementSeconds are a shorthand for the same
+	// clock on both sides and can't be combined with WhiteClock and
+	// BlackClock.
+	InitialSeconds   int `json:"initialSeconds"`
+	IncrementSeconds int `json:"incrementSeconds"`
+	// ClockStart is "immediately" (default), "afterWhiteMove" or
+	// "afterFirstMove".
+	ClockStart string `json:"clockStart"`
+	// DisableDrawOffers forbids draws by agreement for this game.
+	DisableDrawOffers bool `json:"disableDrawOffers"`
+	// Notation is "uci" (default), "san" or "lan".
+	Notation string `json:"notation"`
+	// SideToMove overrides who moves first ("w" or "b"), e.g. for puzzles
+	// from the standard array where black starts.
+	SideToMove string `json:"sideToMove"`
+	// RequireReady makes both players confirm with a "ready" message
+	// before the first move.
+	RequireReady bool `json:"requireReady"`
+	// BotDifficulty is "easy", "medium" (default) or "hard" for games
+	// against the bot.
+	BotDifficulty string `json:"botDifficulty"`
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// CreateGameResponse is returned by POST /game. FirstMover is the color
+// to move in the start position ("w" or "b"), which is black for custom
+// positions with black to move, and FirstMoverId the player holding it.
+// The player ids include the ones generated for omitted players.
+type CreateGameResponse struct {
+	ID            string `json:"id"`
+	FirstMover    string `json:"firstMover"`
+	FirstMoverId  string `json:"firstMoverId"`
+	WhitePlayerId string `json:"whitePlayerId"`
+	BlackPlayerId string `json:"blackPlayerId"`
 }
 
-func GenerateMoveAnswerMessage(game *Game, move MoveMessage) ([]byte, error) {
-	answer := MoveAnswer{
-		GameID: move.GameID,
-		Move:   move.Move,
+func NewCreateGameResponse(id string, game *Game) CreateGameResponse {
+	game.mu.RLock()
+	firstMover := game.Game.Positions()[0].Turn()
+	game.mu.RUnlock()
+
+	return CreateGameResponse{
+		ID:            id,
+		FirstMover:    ColorCode(firstMover),
+		FirstMoverId:  PlayerIdForColor(game, firstMover),
+		WhitePlayerId: game.WhitePlayerId,
+		BlackPlayerId: game.BlackPlayerId,
 	}
+}
 
-	data, err := json.Marshal(answer)
-	if err != nil {
-		return nil, err
+const maxPlayerIDLength = 64
+
+// NormalizePlayerID trims a player id from a create request. An omitted id
+// gets a generated one, like /ws does for connections without an id.
+func NormalizePlayerID(id string) (string, error) {
+	if id == "" {
+		return uuid.New().String(), nil
 	}
 
-	answerMsg := WebsocketMessage{
-		Type:    "move",
-		Payload: string(data),
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", errors.New("Player ids must not be blank")
 	}
 
-	moveData, err := json.Marshal(answerMsg)
-	if err != nil {
-		return nil, err
+	if len(id) > maxPlayerIDLength {
+		return "", fmt.Errorf("Player ids must not be longer than %d bytes", maxPlayerIDLength)
 	}
 
-	return moveData, nil
+	return id, nil
 }
 
-func HandleMove(
-	wsMsg WebsocketMessage,
-	client *Client,
-) error {
-	var move MoveMessage
-	err := json.Unmarshal([]byte(wsMsg.Payload), &move)
+// NewGameFromRequest validates a create request and sets up the game it
+// describes. Validation errors are meant to be shown to the client.
+func NewGameFromRequest(request CreateGameRequest) (*Game, error) {
+	var err error
+
+	request.Player1, err = NormalizePlayerID(request.Player1)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	game, ok := games[move.GameID]
-	if !ok {
-		return errors.New("Game not found")
+	request.Player2, err = NormalizePlayerID(request.Player2)
+	if err != nil {
+		return nil, err
 	}
 
-	moves := game.Game.ValidMoves()
-	moved := false
+	// one id owning both colors makes the seat lookups in HandleMove and
+	// GenerateAgainstMessage ambiguous, so self-play is not supported
+	if request.Player1 == request.Player2 {
+		return nil, errors.New("Players must be different")
+	}
 
-	for _, m := range moves {
-		if m.String() == move.Move {
-			err := game.Game.Move(m)
-			if err != nil {
-				return err
-			}
+	if request.StartingFen != "" && request.TrainingCategory != "" {
+		return nil, errors.New("startingFen and trainingCategory are exclusive")
+	}
 
-			moved = true
-			break
+	fenStr := StartingFEN
+	if request.StartingFen != "" {
+		fen, err := chess.FEN(request.StartingFen)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid starting FEN: %w", err)
+		}
+
+		// normalized, so the side to move override and the FEN tag
+		// always see all six fields
+		fenStr = chess.NewGame(fen).Position().String()
+	}
+
+	if request.TrainingCategory != "" {
+		fenStr, err = GenerateTrainingFEN(request.TrainingCategory)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	if !moved {
-		return errors.New("Invalid move")
+	if request.SideToMove != "" {
+		fenStr, err = SetFENSideToMove(fenStr, request.SideToMove)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = SaveGames()
+	fen, err := chess.FEN(fenStr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	opponent := ""
-	if game.WhitePlayerId == client.ID {
-		opponent = game.BlackPlayerId
+	game := chess.NewGame(fen, chess.UseNotation(chess.LongAlgebraicNotation{}))
+
+	// turn checks all read the side to move from the position, so games
+	// starting with black to move need no special casing
+	if fenStr != StartingFEN {
+		// the FEN tag makes the custom start position survive SaveGames
+		game.AddTagPair("SetUp", "1")
+		game.AddTagPair("FEN", fenStr)
+	}
+
+	now := time.Now()
+
+	newGame := &Game{
+		Game:          game,
+		WhitePlayerId: "",
+		BlackPlayerId: "",
+		DrawsDisabled: request.DisableDrawOffers,
+		Notation:      DefaultNotation,
+		RequireReady:  request.RequireReady,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	newGame.lastAccess.Store(now.UnixNano())
+
+	if request.Notation != "" {
+		if !IsValidNotation(request.Notation) {
+			return nil, errors.New("Invalid notation")
+		}
+
+		newGame.Notation = request.Notation
+	}
+
+	if request.PreferredColor == ColorWhite {
+		newGame.WhitePlayerId = request.Player1
+		newGame.BlackPlayerId = request.Player2
+	} else if request.PreferredColor == ColorBlack {
+		newGame.WhitePlayerId = request.Player2
+		newGame.BlackPlayerId = request.Player1
 	} else {
-		opponent = game.WhitePlayerId
+		if rand.IntN(2) == 0 {
+			newGame.WhitePlayerId = request.Player1
+			newGame.BlackPlayerId = request.Player2
+		} else {
+			newGame.WhitePlayerId = request.Player2
+			newGame.BlackPlayerId = request.Player1
+		}
 	}
 
-	switch opponent {
-	case "":
-	case "ai":
-		break
-	default:
-		for _, client := range connectedClients {
-			if client.ID == opponent {
-				data, err := GenerateMoveAnswerMessage(game, move)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
+	if request.BotDifficulty != "" {
+		if !IsPlayer(newGame, BotPlayerID) {
+			return nil, errors.New("botDifficulty needs the bot as a player")
+		}
 
-				err = client.Conn.WriteMessage(websocket.TextMessage, data)
-				if err != nil {
-					continue
-				}
-			}
+		if !IsValidBotDifficulty(request.BotDifficulty) {
+			return nil, errors.New("Invalid bot difficulty")
+		}
+
+		newGame.BotDifficulty = request.BotDifficulty
+	}
+
+	if request.InitialSeconds != 0 || request.IncrementSeconds != 0 {
+		if request.WhiteClock != nil || request.BlackClock != nil {
+			return nil, errors.New("initialSeconds can't be combined with whiteClock or blackClock")
+		}
+
+		request.WhiteClock = &ClockSettings{
+			BaseSeconds:      request.InitialSeconds,
+			IncrementSeconds: request.IncrementSeconds,
 		}
 	}
 
-	return nil
+	if request.WhiteClock != nil || request.BlackClock != nil {
+		whiteClock := request.WhiteClock
+		blackClock := request.BlackClock
+
+		if whiteClock == nil {
+			whiteClock = blackClock
+		}
+		if blackClock == nil {
+			blackClock = whiteClock
+		}
+
+		if whiteClock.BaseSeconds <= 0 || blackClock.BaseSeconds <= 0 ||
+			whiteClock.IncrementSeconds < 0 || blackClock.IncrementSeconds < 0 {
+			return nil, errors.New("Invalid clock settings")
+		}
+
+		if request.ClockStart != "" && !IsValidClockStart(request.ClockStart) {
+			return nil, errors.New("Invalid clock start")
+		}
+
+		newGame.Clock = NewClock(*whiteClock, *blackClock, time.Now())
+		newGame.Clock.Start = request.ClockStart
+	}
+
+	return newGame, nil
 }
 
-func GenerateAgainstMessage(game *Game, client *Client) ([]byte, error) {
-	againstMsg := AgainstMessage{
-		ID:    "",
-		Color: "",
+// cacheValidMoves computes the legal moves of the positions. notnil/chess
+// caches them in the position on first use, so they are computed while the
+// game is written or before it is shared. Readers then share the positions
+// without racing on the cache.
+func cacheValidMoves(positions ...*chess.Position) {
+	for _, pos := range positions {
+		pos.ValidMoves()
 	}
+}
 
-	if game.WhitePlayerId == client.ID {
-		againstMsg.Color = "b"
-		againstMsg.ID = game.BlackPlayerId
-	} else if game.BlackPlayerId == client.ID {
-		againstMsg.Color = "w"
-		againstMsg.ID = game.WhitePlayerId
-	} else {
-		return nil, errors.New("Player not in game")
+// AddGame registers a new game and persists it.
+func AddGame(id string, game *Game) error {
+	cacheValidMoves(game.Game.Positions()...)
+
+	gamesMu.Lock()
+	games[id] = game
+	EvictIdleGames(id)
+	gamesMu.Unlock()
+
+	return SaveGame(id)
+}
+
+// mutatingMessageTypes are the websocket messages that change a game and
+// are therefore rejected for observer connections. Observers may still
+// send "leave", HandleLeave doesn't change the game for them.
+var mutatingMessageTypes = map[string]bool{
+	"move":            true,
+	"ready":           true,
+	"offerDraw":       true,
+	"acceptDraw":      true,
+	"confirmDraw":     true,
+	"declineDraw":     true,
+	"resign":          true,
+	"addTime":         true,
+	"requestTakeback": true,
+	"respondTakeback": true,
+	"rematch":         true,
+	"chat":            true,
+	"claimDraw":       true,
+}
+
+var ErrInvalidMove = errors.New("Invalid move")
+
+var ErrNotYourTurn = errors.New("Not your turn")
+
+var ErrGameOver = errors.New("Game is over")
+
+// moveErrorCodes maps the rejections of ApplyMove to the error codes sent
+// back to the moving client.
+var moveErrorCodes = map[error]string{
+	ErrInvalidMove: ErrorCodeIllegalMove,
+	ErrNotYourTurn: ErrorCodeNotYourTurn,
+	ErrGameOver:    ErrorCodeGameOver,
+	ErrNotReady:    ErrorCodeNotReady,
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: CheckOrigin,
+}
+
+func GenerateMoveAnswerMessage(game *Game, move MoveMessage) ([]byte, error) {
+	pos := game.Game.Position()
+	moves := game.Game.Moves()
+	ended := game.Game.Outcome() != chess.NoOutcome
+
+	answer := MoveAnswer{
+		GameID:      move.GameID,
+		Move:        move.Move,
+		Fen:         pos.String(),
+		Seq:         game.Sequence,
+		Ply:         len(moves),
+		InCheck:     IsInCheck(pos.Board(), pos.Turn()),
+		IsCheckmate: ended && game.Game.Method() == chess.Checkmate,
+		IsStalemate: ended && game.Game.Method() == chess.Stalemate,
 	}
 
-	data, err := json.Marshal(againstMsg)
+	if len(moves) > 0 {
+		answer.LastMoveUci = moves[len(moves)-1].String()
+		answer.LastMove = GameLastMove(game)
+	}
+
+	data, err := json.Marshal(answer)
 	if err != nil {
 		return nil, err
 	}
 
-	against := WebsocketMessage{
-		Type:    "against",
+	answerMsg := WebsocketMessage{
+		Type:    "move",
 		Payload: string(data),
 	}
 
-	data, err = json.Marshal(against)
+	moveData, err := json.Marshal(answerMsg)
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return moveData, nil
 }
 
-func HandleJoin(
-	wsMsg WebsocketMessage,
-	newClient *Client,
-) error {
-	var join JoinMessage
-	err := json.Unmarshal([]byte(wsMsg.Payload), &join)
+func GenerateMoveAckMessage(game *Game, move MoveMessage) ([]byte, error) {
+	ack := MoveAck{
+		GameID: move.GameID,
+		Move:   move.Move,
+		Seq:    game.Sequence,
+		Ply:    len(game.Game.Moves()),
+		Fen:    game.Game.Position().String(),
+	}
+
+	data, err := json.Marshal(ack)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	game, ok := games[join.GameID]
-	if !ok {
-		return errors.New("Game not found")
+	ackMsg := WebsocketMessage{
+		Type:    "moveAck",
+		Payload: string(data),
 	}
 
-	data, err := GenerateAgainstMessage(game, newClient)
-	if err != nil {
-		return err
+	return json.Marshal(ackMsg)
+}
+
+// moveUpdates are the messages produced by a move. They are generated
+// while the game is locked so they all describe the same position.
+type moveUpdates struct {
+	ack      []byte
+	answer   []byte
+	clock    []byte
+	captured []byte
+	finished bool
+	opponent string
+}
+
+// ApplyMove validates and applies a move under the game lock, so of two
+// racing submissions the second is validated against the position after
+// the first.
+func ApplyMove(game *Game, move *MoveMessage, client *Client) (*moveUpdates, error) {
+	game.mu.Lock()
+	defer game.mu.Unlock()
+
+	return applyMoveLocked(game, move, client)
+}
+
+// applyMoveLocked is ApplyMove for callers that hold the game lock.
+func applyMoveLocked(game *Game, move *MoveMessage, client *Client) (*moveUpdates, error) {
+	if game.Game.Outcome() != chess.NoOutcome {
+		return nil, ErrGameOver
 	}
 
-	err = newClient.Conn.WriteMessage(websocket.TextMessage, data)
-	if err != nil {
-		return err
+	if WaitingForReady(game) {
+		return nil, ErrNotReady
 	}
 
-	return nil
-}
+	if !IsPlayersTurn(game, client.ID) {
+		return nil, ErrNotYourTurn
+	}
 
-func WsHandler(c *gin.Context, id string) error {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	mover := game.Game.Position().Turn()
+
+	m, ok := IsLegalMove(game, move.Move)
+	if !ok {
+		return nil, ErrInvalidMove
+	}
+
+	// broadcast the move normalized to the game's notation
+	move.Move = FormatMove(game, game.Game.Position(), m)
+
+	// only captures and promotions change the material on the board
+	materialChanged := CapturedPiece(game.Game.Position(), m) != chess.NoPiece ||
+		m.Promo() != chess.NoPieceType
+
+	err := game.Game.Move(m)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	newClient := &Client{
-		ID:   id,
-		Conn: conn,
+	cacheValidMoves(game.Game.Position())
+
+	game.Sequence++
+	game.UpdatedAt = time.Now()
+
+	if game.DrawOffer != nil && game.DrawOffer.By == mover {
+		game.DrawOffer = nil
 	}
 
-	connectedClients = append(connectedClients, newClient)
+	// a takeback request refers to the move before this one
+	game.TakebackRequest = nil
 
-	helloMsg := HelloMessage{
-		ID: id,
+	// the clock, and with it the increment, is only punched for a
+	// completed move that passed the turn to the opponent; rejected
+	// submissions returned above without touching it
+	if game.Clock != nil && game.Game.Position().Turn() != mover {
+		flagged := game.Clock.Punch(mover, time.Now())
+
+		// a result on the board (checkmate, stalemate, automatic draws)
+		// takes precedence over a flag falling on the same move
+		if flagged && game.Game.Outcome() == chess.NoOutcome {
+			game.Game.Resign(mover)
+			game.TimedOut = true
+		}
 	}
 
-	data, err := json.Marshal(helloMsg)
+	updates := &moveUpdates{}
+
+	updates.ack, err = GenerateMoveAckMessage(game, *move)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	hello := WebsocketMessage{
-		Type:    "hello",
-		Payload: string(data),
+	if game.WhitePlayerId == client.ID {
+		updates.opponent = game.BlackPlayerId
+	} else {
+		updates.opponent = game.WhitePlayerId
 	}
 
-	data, err = json.Marshal(hello)
+	updates.answer, err = GenerateMoveAnswerMessage(game, *move)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = conn.WriteMessage(websocket.TextMessage, data)
-	if err != nil {
-		return err
+	if game.Clock != nil {
+		updates.clock, err = GenerateClockMessage(move.GameID, game.Clock)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	defer func() {
-		for i, client := range connectedClients {
-			if client.ID == newClient.ID {
-				connectedClients = append(connectedClients[:i], connectedClients[i+1:]...)
-				break
-			}
+	if materialChanged {
+		updates.captured, err = GenerateCapturedPiecesMessage(move.GameID, game)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	// automatic endings (checkmate, stalemate, fivefold repetition, the
+	// seventy-five-move rule, insufficient material) are set by the move
+	// itself; the fifty-move rule and threefold repetition stay claimable
+	if game.Game.Outcome() != chess.NoOutcome {
+		updates.finished = true
+		FinalizeGame(move.GameID, game)
+	}
+
+	return updates, nil
+}
+
+func HandleMove(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var move MoveMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &move)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(move.GameID)
+	if !ok {
+		sendErr := SendError(client, move.GameID, ErrorCodeGameNotFound, "Game not found")
+		if sendErr != nil {
+			slog.Warn("sending error failed", "game_id", move.GameID, "client_id", client.ID, "error", sendErr)
+		}
+
+		return errors.New("Game not found")
+	}
+
+	updates, err := ApplyMove(game, &move, client)
+	if err != nil {
+		// tell the client why its move was rejected so it can reset the board
+		if code, ok := moveErrorCodes[err]; ok {
+			sendErr := SendError(client, move.GameID, code, err.Error())
+			if sendErr != nil {
+				slog.Warn("sending error failed", "game_id", move.GameID, "client_id", client.ID, "error", sendErr)
+			}
+		}
+
+		return err
+	}
+
+	MarkDirty(move.GameID)
+
+	err = client.Send(updates.ack)
+	if err != nil {
+		slog.Warn("sending move ack failed", "game_id", move.GameID, "client_id", client.ID, "error", err)
+	}
+
+	BroadcastMove(move.GameID, game, updates)
+
+	if updates.opponent == BotPlayerID {
+		PlayBotMove(move.GameID, game)
+	}
+
+	return nil
+}
+
+// BroadcastMove sends the updates of a move to everyone in the game but
+// the mover, who gets the ack instead.
+func BroadcastMove(gameID string, game *Game, updates *moveUpdates) {
+	switch updates.opponent {
+	case "", BotPlayerID:
+	default:
+		SendToPlayer(gameID, updates.opponent, updates.answer)
+	}
+
+	SendToSpectators(gameID, updates.answer)
+
+	for _, data := range [][]byte{updates.clock, updates.captured} {
+		if data != nil {
+			BroadcastToGame(gameID, data)
+		}
+	}
+
+	if updates.finished {
+		BroadcastOutcome(gameID, game)
+	}
+}
+
+// IsLegalMove parses a move in the game's notation and looks it up among
+// the legal moves of the current position without applying it.
+func IsLegalMove(game *Game, moveStr string) (*chess.Move, bool) {
+	pos := game.Game.Position()
+
+	decoded, err := NotationFor(game.Notation).Decode(pos, moveStr)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, m := range pos.ValidMoves() {
+		if m.String() == decoded.String() {
+			return m, true
+		}
+	}
+
+	return nil, false
+}
+
+func HandleValidateBatch(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var batch ValidateBatchMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &batch)
+	if err != nil {
+		return err
+	}
+
+	if len(batch.Moves) > maxValidateBatchSize {
+		return errors.New("Batch too large")
+	}
+
+	game, ok := GetGame(batch.GameID)
+	if !ok {
+		return errors.New("Game not found")
+	}
+
+	game.mu.RLock()
+
+	finished := game.Game.Outcome() != chess.NoOutcome
+	results := make([]MoveValidation, 0, len(batch.Moves))
+
+	for _, moveStr := range batch.Moves {
+		result := MoveValidation{Move: moveStr}
+
+		m, ok := IsLegalMove(game, moveStr)
+		if ok && !finished {
+			result.Legal = true
+			result.Check = m.HasTag(chess.Check)
+			result.Capture = m.HasTag(chess.Capture) || m.HasTag(chess.EnPassant)
+		}
+
+		results = append(results, result)
+	}
+
+	game.mu.RUnlock()
+
+	data, err := json.Marshal(ValidateBatchAnswer{
+		GameID:  batch.GameID,
+		Results: results,
+	})
+	if err != nil {
+		return err
+	}
+
+	answer := WebsocketMessage{
+		Type:    "validateBatch",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+func PlayerIdForColor(game *Game, color chess.Color) string {
+	if color == chess.White {
+		return game.WhitePlayerId
+	}
+
+	return game.BlackPlayerId
+}
+
+// PlayerColor returns the color the player plays in the game.
+func PlayerColor(game *Game, playerID string) (chess.Color, bool) {
+	switch {
+	case playerID == "":
+		return chess.NoColor, false
+	case playerID == game.WhitePlayerId:
+		return chess.White, true
+	case playerID == game.BlackPlayerId:
+		return chess.Black, true
+	default:
+		return chess.NoColor, false
+	}
+}
+
+// IsPlayersTurn reports whether the player owns the side to move.
+func IsPlayersTurn(game *Game, playerID string) bool {
+	color, ok := PlayerColor(game, playerID)
+
+	return ok && color == game.Game.Position().Turn()
+}
+
+// IsPlayerConnected reports whether the player has a connection that is
+// currently viewing the game.
+func IsPlayerConnected(gameID string, playerID string) bool {
+	if playerID == "" {
+		return false
+	}
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range gameClients[gameID] {
+		if client.ID == playerID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BroadcastOutcome sends the outcome of a finished game to its players and
+// spectators, each in the locale of their connection.
+func BroadcastOutcome(gameID string, game *Game) {
+	// the game is locked before the clients, see gamesMu
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	messages := make(map[string][]byte)
+
+	for _, client := range gameClients[gameID] {
+		data, ok := messages[client.Locale]
+		if !ok {
+			var err error
+			data, err = GenerateOutcomeMessage(gameID, game, client.Locale)
+			if err != nil {
+				slog.Error("generating outcome failed", "game_id", gameID, "error", err)
+				return
+			}
+
+			messages[client.Locale] = data
+		}
+
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// SendToPlayer sends data to the player's connections that are currently
+// viewing the game.
+func SendToPlayer(gameID string, playerID string, data []byte) {
+	if playerID == "" {
+		return
+	}
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range gameClients[gameID] {
+		if client.ID != playerID {
+			continue
+		}
+
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// BroadcastToPlayers sends data to both players of the game who are
+// currently viewing it.
+func BroadcastToPlayers(gameID string, game *Game, data []byte) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range gameClients[gameID] {
+		if client.ID != game.WhitePlayerId && client.ID != game.BlackPlayerId {
+			continue
+		}
+
+		err := client.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", gameID, "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// OutcomeMethod names how the game ended. Losses on time are stored as a
+// resignation in the chess game and reported as "Timeout".
+func OutcomeMethod(game *Game) string {
+	if game.TimedOut {
+		return "Timeout"
+	}
+
+	return game.Game.Method().String()
+}
+
+func GenerateOutcomeMessage(gameID string, game *Game, locale string) ([]byte, error) {
+	outcomeMsg := OutcomeMessage{
+		GameID:  gameID,
+		Outcome: game.Game.Outcome().String(),
+		Winner:  "",
+		Method:  OutcomeMethod(game),
+	}
+
+	switch game.Game.Outcome() {
+	case chess.WhiteWon:
+		outcomeMsg.Winner = ColorWhite
+		outcomeMsg.Text = OutcomeText(locale, "outcome.whiteWon", outcomeMsg.Method)
+	case chess.BlackWon:
+		outcomeMsg.Winner = ColorBlack
+		outcomeMsg.Text = OutcomeText(locale, "outcome.blackWon", outcomeMsg.Method)
+	case chess.Draw:
+		outcomeMsg.Text = OutcomeText(locale, "outcome.draw", outcomeMsg.Method)
+	}
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		outcomeMsg.Reason = OutcomeReason(locale, outcomeMsg.Method)
+	}
+
+	data, err := json.Marshal(outcomeMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	outcome := WebsocketMessage{
+		Type:    "outcome",
+		Payload: string(data),
+	}
+
+	return json.Marshal(outcome)
+}
+
+func GenerateAgainstMessage(game *Game, client *Client) ([]byte, error) {
+	againstMsg := AgainstMessage{
+		ID:    "",
+		Color: "",
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return nil, errors.New("Player not in game")
+	}
+
+	againstMsg.Color = ColorCode(color.Other())
+	againstMsg.ID = PlayerIdForColor(game, color.Other())
+	againstMsg.LastMove = GameLastMove(game)
+
+	data, err := json.Marshal(againstMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	against := WebsocketMessage{
+		Type:    "against",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(against)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func IsPlayer(game *Game, playerID string) bool {
+	return playerID != "" && (game.WhitePlayerId == playerID || game.BlackPlayerId == playerID)
+}
+
+// JoinsAsSpectator reports whether the client views the game read-only.
+// Observer connections do so even with the id of a player, so they never
+// take up a player's seat.
+func JoinsAsSpectator(game *Game, client *Client) bool {
+	return client.Observer || !IsPlayer(game, client.ID)
+}
+
+// GenerateSpectatorMessage is the color-neutral counterpart of the against
+// message for clients that don't play in the game.
+func GenerateSpectatorMessage(gameID string, game *Game) ([]byte, error) {
+	data, err := json.Marshal(SpectatorMessage{
+		GameID:        gameID,
+		WhitePlayerId: game.WhitePlayerId,
+		BlackPlayerId: game.BlackPlayerId,
+		Role:          "spectator",
+		LastMove:      GameLastMove(game),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spectator := WebsocketMessage{
+		Type:    "players",
+		Payload: string(data),
+	}
+
+	return json.Marshal(spectator)
+}
+
+func HandleJoin(
+	wsMsg WebsocketMessage,
+	newClient *Client,
+) error {
+	var join JoinMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &join)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(join.GameID)
+	if !ok {
+		return errors.New("Game not found")
+	}
+
+	return JoinGame(newClient, join.GameID, game, JoinsAsSpectator(game, newClient))
+}
+
+// HandleSpectate joins a game read-only, even for one of its players.
+func HandleSpectate(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var spectate SpectateMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &spectate)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(spectate.GameID)
+	if !ok {
+		return SendError(client, spectate.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	err = JoinGame(client, spectate.GameID, game, true)
+	if err != nil {
+		return err
+	}
+
+	// spectators can join mid-game, so they get the full board as well
+	game.mu.RLock()
+	data, err := GenerateStateMessage(spectate.GameID, game)
+	game.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+// JoinGame sends the client its role in the game and the initial state and
+// subscribes it to the game's updates.
+func JoinGame(newClient *Client, gameID string, game *Game, spectator bool) error {
+	err := AddGameClient(newClient, gameID, spectator)
+	if errors.Is(err, ErrGameFull) {
+		sendErr := SendError(newClient, gameID, ErrorCodeGameFull, "Game is full")
+		if sendErr != nil {
+			return sendErr
+		}
+
+		return err
+	}
+
+	var data []byte
+
+	game.mu.RLock()
+	if spectator {
+		data, err = GenerateSpectatorMessage(gameID, game)
+	} else {
+		data, err = GenerateAgainstMessage(game, newClient)
+	}
+	game.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	err = newClient.Send(data)
+	if err != nil {
+		return err
+	}
+
+	if spectator {
+		RecordView(gameID, game)
+	} else {
+		game.mu.Lock()
+		game.Abandoned = false
+		game.mu.Unlock()
+	}
+
+	// the captured pieces are only broadcast on captures, so send the
+	// current state for the initial sync
+	game.mu.RLock()
+	data, err = GenerateCapturedPiecesMessage(gameID, game)
+	game.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	err = newClient.Send(data)
+	if err != nil {
+		return err
+	}
+
+	return SendChatHistory(newClient, gameID, game)
+}
+
+func HandleGetPgn(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var getPgn GetPgnMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &getPgn)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(getPgn.GameID)
+	if !ok {
+		return SendError(client, getPgn.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	game.mu.RLock()
+	pgn := ExportPGN(game)
+	game.mu.RUnlock()
+
+	data, err := json.Marshal(PgnAnswer{
+		GameID: getPgn.GameID,
+		Pgn:    pgn,
+	})
+	if err != nil {
+		return err
+	}
+
+	answer := WebsocketMessage{
+		Type:    "pgn",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+func GenerateStateMessage(id string, game *Game) ([]byte, error) {
+	moves := make([]string, 0)
+	positions := game.Game.Positions()
+	for i, m := range game.Game.Moves() {
+		moves = append(moves, FormatMove(game, positions[i], m))
+	}
+
+	stateMsg := StateMessage{
+		GameID:        id,
+		Fen:           game.Game.Position().String(),
+		Turn:          ColorCode(game.Game.Position().Turn()),
+		Outcome:       game.Game.Outcome().String(),
+		Moves:         moves,
+		Notation:      game.Notation,
+		DrawsDisabled: game.DrawsDisabled,
+		ViewCount:     game.ViewCount,
+	}
+
+	data, err := json.Marshal(stateMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	state := WebsocketMessage{
+		Type:    "state",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// RecordView counts a spectator starting to watch the game. The count is
+// never decremented.
+func RecordView(id string, game *Game) {
+	game.mu.Lock()
+	game.ViewCount++
+	game.mu.Unlock()
+
+	MarkDirty(id)
+}
+
+func HandleSwitch(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var switchMsg SwitchMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &switchMsg)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(switchMsg.GameID)
+	if !ok {
+		return errors.New("Game not found")
+	}
+
+	// moving GameID is what stops broadcasts for the previous game. The
+	// client gets the same messages as for a join, plus the full board.
+	err = JoinGame(client, switchMsg.GameID, game, JoinsAsSpectator(game, client))
+	if err != nil {
+		return err
+	}
+
+	game.mu.RLock()
+	data, err := GenerateStateMessage(switchMsg.GameID, game)
+	game.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+func WsHandler(c *gin.Context, id string, resumeGameID string) error {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return err
+	}
+
+	newClient := &Client{
+		ID:       id,
+		Conn:     conn,
+		Observer: c.Query("role") == "observer",
+		Locale:   ParseLocale(c.Query("locale")),
+	}
+
+	token, err := IssueReconnectToken(id, time.Now())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	newClient.ReconnectToken = token
+
+	var resumeGame *Game
+	if resumeGameID != "" {
+		resumeGame, _ = GetGame(resumeGameID)
+	}
+
+	helloMsg := HelloMessage{
+		ID:             id,
+		ReconnectToken: token,
+	}
+	if resumeGame != nil {
+		helloMsg.GameID = resumeGameID
+	}
+
+	data, err := json.Marshal(helloMsg)
+	if err != nil {
+		CloseReconnectSession(token, "", time.Now())
+		conn.Close()
+		return err
+	}
+
+	hello := WebsocketMessage{
+		Type:    "hello",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(hello)
+	if err != nil {
+		CloseReconnectSession(token, "", time.Now())
+		conn.Close()
+		return err
+	}
+
+	// only register the client once the hello went through, otherwise a
+	// dead connection would stay in connectedClients
+	err = newClient.Send(data)
+	if err != nil {
+		CloseReconnectSession(token, "", time.Now())
+		conn.Close()
+		return err
+	}
+
+	clientsMu.Lock()
+	connectedClients = append(connectedClients, newClient)
+	clientsMu.Unlock()
+
+	defer func() {
+		clientsMu.Lock()
+		for i, client := range connectedClients {
+			if client == newClient {
+				connectedClients = append(connectedClients[:i], connectedClients[i+1:]...)
+				break
+			}
+		}
+		CloseReconnectSession(newClient.ReconnectToken, newClient.GameID, time.Now())
+		setClientGameLocked(newClient, "", false)
+		clientsMu.Unlock()
 		conn.Close()
 	}()
 
-	for {
-		_, msg, err := conn.ReadMessage()
+	stopHeartbeat := StartHeartbeat(newClient)
+	defer stopHeartbeat()
+
+	// the resume bundle puts the client back into its game and sends the
+	// current state
+	if resumeGame != nil {
+		err := AddGameClient(newClient, resumeGameID, JoinsAsSpectator(resumeGame, newClient))
+		if errors.Is(err, ErrGameFull) {
+			err = SendError(newClient, resumeGameID, ErrorCodeGameFull, "Game is full")
+			if err != nil {
+				return err
+			}
+		} else {
+			resumeGame.mu.RLock()
+			data, err := GenerateStateMessage(resumeGameID, resumeGame)
+			resumeGame.mu.RUnlock()
+			if err != nil {
+				return err
+			}
+
+			err = newClient.Send(data)
+			if err != nil {
+				return err
+			}
+		}
+	} else if !newClient.Observer {
+		// a player that reconnects with its id instead of a token gets
+		// its running games back without joining them again
+		err := ResumePlayerGames(newClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			// read errors are permanent, so any of them ends the
+			// connection. Clients closing normally or going away are
+			// expected, everything else like a missed heartbeat or a
+			// dropped connection is logged.
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				slog.Warn("connection closed unexpectedly", "client_id", newClient.ID, "error", err)
+			}
+			break
+		}
+
+		var wsMsg WebsocketMessage
+		err = json.Unmarshal(msg, &wsMsg)
+		if err != nil {
+			break
+		}
+
+		_, spectator := ClientGame(newClient)
+		if (newClient.Observer || spectator) && mutatingMessageTypes[wsMsg.Type] {
+			err := SendError(newClient, "", ErrorCodeReadOnly, "Observers and spectators cannot send "+wsMsg.Type)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+			continue
+		}
+
+		switch wsMsg.Type {
+		case "leave":
+			err := HandleLeave(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+
+			// leaving ends the connection, the deferred cleanup
+			// unregisters the client
+			return nil
+		case "spectate":
+			err := HandleSpectate(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "join":
+			err := HandleJoin(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "switch":
+			err := HandleSwitch(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "getPgn":
+			err := HandleGetPgn(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "squareMoves":
+			err := HandleSquareMoves(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "possibleMovesBySquare":
+			err := HandlePossibleMovesBySquare(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "claimDraw":
+			err := HandleClaimDraw(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "chat":
+			err := HandleChat(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "rematch":
+			err := HandleRematch(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "requestTakeback":
+			err := HandleRequestTakeback(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "respondTakeback":
+			err := HandleRespondTakeback(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "addTime":
+			err := HandleAddTime(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "resign":
+			err := HandleResign(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "offerDraw":
+			err := HandleOfferDraw(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "acceptDraw":
+			err := HandleAcceptDraw(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "declineDraw":
+			err := HandleDeclineDraw(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "confirmDraw":
+			err := HandleConfirmDraw(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "getCaptured":
+			err := HandleGetCaptured(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "ready":
+			err := HandleReady(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "validateBatch":
+			err := HandleValidateBatch(wsMsg, newClient)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		case "move":
+			err := HandleMove(wsMsg, newClient)
+
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+
+			if err == nil {
+				newClient.IllegalMoves = 0
+			} else if errors.Is(err, ErrInvalidMove) {
+				newClient.IllegalMoves++
+
+				if newClient.IllegalMoves >= MaxIllegalMoves {
+					closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Too many illegal moves")
+					err := newClient.SendControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+					if err != nil {
+						slog.Warn("closing connection failed", "client_id", newClient.ID, "error", err)
+					}
+
+					return nil
+				}
+			}
+		default:
+			if !StrictMessageTypes {
+				break
+			}
+
+			err := SendError(newClient, "", ErrorCodeUnknownType, "Unknown message type "+wsMsg.Type)
+			if err != nil {
+				LogMessageError(newClient, wsMsg, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func GenerateHeatmap(game *Game) map[string]int {
+	heatmap := make(map[string]int)
+
+	for sq := chess.A1; sq <= chess.H8; sq++ {
+		heatmap[sq.String()] = 0
+	}
+
+	for _, pos := range game.Game.Positions() {
+		for sq := range pos.Board().SquareMap() {
+			heatmap[sq.String()]++
+		}
+	}
+
+	return heatmap
+}
+
+// PieceChar returns the FEN letter of a piece, uppercase for white.
+func PieceChar(piece chess.Piece) string {
+	char := piece.Type().String()
+	if piece.Color() == chess.White {
+		return strings.ToUpper(char)
+	}
+
+	return char
+}
+
+// GenerateBoard64 returns the piece placement as 64 characters, ranks 8
+// to 1 and files a to h, with '.' for empty squares.
+func GenerateBoard64(board *chess.Board) string {
+	var sb strings.Builder
+
+	for rank := chess.Rank8; rank >= chess.Rank1; rank-- {
+		for file := chess.FileA; file <= chess.FileH; file++ {
+			piece := board.Piece(chess.NewSquare(file, rank))
+			if piece == chess.NoPiece {
+				sb.WriteString(".")
+			} else {
+				sb.WriteString(PieceChar(piece))
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// StoreGame converts a game into its persisted form. It takes the game
+// lock, so callers must not hold it. The clock and annotations are copied,
+// so the result can be encoded after the lock is released.
+func StoreGame(game *Game) (StoredGame, error) {
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+
+	pgn, err := game.Game.MarshalText()
+	if err != nil {
+		return StoredGame{}, err
+	}
+
+	var clock *Clock
+	if game.Clock != nil {
+		clock = game.Clock.Copy()
+	}
+
+	storedGame := StoredGame{
+		Version:       StoredGameVersion,
+		PGNStr:        string(pgn),
+		WhitePlayerId: game.WhitePlayerId,
+		BlackPlayerId: game.BlackPlayerId,
+		Clock:         clock,
+		DrawsDisabled: game.DrawsDisabled,
+		Notation:      game.Notation,
+		TimedOut:      game.TimedOut,
+		Variations:    game.Variations,
+		Annotations:   maps.Clone(game.Annotations),
+		RequireReady:  game.RequireReady,
+		ViewCount:     game.ViewCount,
+		Sequence:      game.Sequence,
+		Abandoned:     game.Abandoned,
+		BotDifficulty: game.BotDifficulty,
+		CreatedAt:     game.CreatedAt,
+		UpdatedAt:     game.UpdatedAt,
+	}
+
+	return storedGame, nil
+}
+
+// SnapshotGames returns the persisted form of every game, loaded or not.
+func SnapshotGames() (StoredGames, error) {
+	stored := make(StoredGames)
+
+	gamesMu.RLock()
+
+	// in lazy mode games that were never loaded or got evicted only exist
+	// in the index
+	for id, storedGame := range storedIndex {
+		stored[id] = storedGame
+	}
+
+	for id, game := range games {
+		storedGame, err := StoreGame(game)
+		if err != nil {
+			gamesMu.RUnlock()
+			return nil, err
+		}
+
+		stored[id] = storedGame
+	}
+
+	gamesMu.RUnlock()
+
+	return stored, nil
+}
+
+// SaveGame persists a single game, loaded or not. A game that no longer
+// exists is removed from the store, unless only its file is left.
+func SaveGame(id string) error {
+	gamesMu.RLock()
+	game, loaded := games[id]
+	storedGame, indexed := storedIndex[id]
+	unloaded := unloadedGames[id]
+	gamesMu.RUnlock()
+
+	if !loaded && !indexed {
+		if unloaded {
+			return nil
+		}
+
+		return DeleteStoredGame(id)
+	}
+
+	if loaded {
+		var err error
+		storedGame, err = StoreGame(game)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(storedGame)
+	if err != nil {
+		return err
+	}
+
+	return PersistGame(id, data)
+}
+
+// SaveGames writes every game and removes the files of games that no
+// longer exist. Files of games LoadGames could not restore are kept.
+// Single changes should use SaveGame.
+func SaveGames() error {
+	stored, err := SnapshotGames()
+	if err != nil {
+		return err
+	}
+
+	for id, storedGame := range stored {
+		data, err := json.Marshal(storedGame)
+		if err != nil {
+			return err
+		}
+
+		err = PersistGame(id, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	persisted, err := ReadStoredGames()
+	if err != nil {
+		return err
+	}
+
+	gamesMu.RLock()
+	unloaded := maps.Clone(unloadedGames)
+	gamesMu.RUnlock()
+
+	for id := range persisted {
+		if _, ok := stored[id]; ok || unloaded[id] {
+			continue
+		}
+
+		err = DeleteStoredGame(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateStoredGame upgrades a game written by an older server to the
+// current StoredGameVersion, filling in defaults for fields that did not
+// exist yet.
+func MigrateStoredGame(storedGame StoredGame) (StoredGame, error) {
+	if storedGame.Version > StoredGameVersion {
+		return storedGame, fmt.Errorf("unsupported stored game version %d", storedGame.Version)
+	}
+
+	if storedGame.Version == 0 {
+		// version 0 had no version field and no clocks, so these games
+		// are untimed
+		storedGame.Clock = nil
+		storedGame.Version = 1
+	}
+
+	if storedGame.Version == 1 {
+		// version 1 games always used UCI move strings
+		storedGame.Notation = DefaultNotation
+		storedGame.Version = 2
+	}
+
+	return storedGame, nil
+}
+
+// RestoreGame rebuilds a game from its persisted form and finalizes it
+// if it already has an outcome.
+func RestoreGame(id string, storedGame StoredGame) (*Game, error) {
+	newGame, err := NewGameFromStored(storedGame)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheValidMoves(newGame.Game.Positions()...)
+
+	if newGame.Game.Outcome() != chess.NoOutcome {
+		FinalizeGame(id, newGame)
+	}
+
+	return newGame, nil
+}
+
+// NewGameFromStored rebuilds a game from its persisted form without
+// finalizing or registering it.
+func NewGameFromStored(storedGame StoredGame) (*Game, error) {
+	storedGame, err := MigrateStoredGame(storedGame)
+	if err != nil {
+		return nil, err
+	}
+
+	game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+
+	if err := game.UnmarshalText([]byte(storedGame.PGNStr)); err != nil {
+		return nil, err
+	}
+
+	// the clock is copied, so a game restored from the index never
+	// changes the index entry
+	var clock *Clock
+	if storedGame.Clock != nil {
+		clock = storedGame.Clock.Copy()
+	}
+
+	newGame := &Game{
+		Game:          game,
+		WhitePlayerId: storedGame.WhitePlayerId,
+		BlackPlayerId: storedGame.BlackPlayerId,
+		Clock:         clock,
+		DrawsDisabled: storedGame.DrawsDisabled,
+		Notation:      storedGame.Notation,
+		TimedOut:      storedGame.TimedOut,
+		Variations:    storedGame.Variations,
+		Annotations:   storedGame.Annotations,
+		RequireReady:  storedGame.RequireReady,
+		ViewCount:     storedGame.ViewCount,
+		Sequence:      storedGame.Sequence,
+		Abandoned:     storedGame.Abandoned,
+		BotDifficulty: storedGame.BotDifficulty,
+		CreatedAt:     storedGame.CreatedAt,
+		UpdatedAt:     storedGame.UpdatedAt,
+	}
+
+	// games saved before sequence numbers existed start from their ply
+	if newGame.Sequence < len(game.Moves()) {
+		newGame.Sequence = len(game.Moves())
+	}
+
+	return newGame, nil
+}
+
+func LoadGames() error {
+	storedGames, err := ReadStoredGames()
+	if err != nil {
+		return err
+	}
+
+	// lazy mode only indexes the games, GetGame restores them on access
+	if LazyLoadGames {
+		records := RecordStoredGames(storedGames)
+
+		gamesMu.Lock()
+		games = make(map[string]*Game)
+		storedIndex = storedGames
+		indexRecords = records
+		unloadedGames = make(map[string]bool)
+		gamesMu.Unlock()
+
+		return nil
+	}
+
+	// the games are restored aside and only swapped in once all of them
+	// could be, a partial load would look like deleted games to SaveGames
+	loaded := make(map[string]*Game, len(storedGames))
+	for id, storedGame := range storedGames {
+		newGame, err := RestoreGame(id, storedGame)
+		if err != nil {
+			gamesMu.Lock()
+			unloadedGames = make(map[string]bool, len(storedGames))
+			for id := range storedGames {
+				unloadedGames[id] = true
+			}
+			gamesMu.Unlock()
+
+			return fmt.Errorf("game %s: %w", id, err)
+		}
+
+		loaded[id] = newGame
+	}
+
+	gamesMu.Lock()
+	games = loaded
+	storedIndex = make(StoredGames)
+	indexRecords = make(map[string]GameRecord)
+	unloadedGames = make(map[string]bool)
+	gamesMu.Unlock()
+
+	return nil
+}
+
+const maxValidateBatchSize = 64
+const maxSimulateMoves = 500
+
+const maxEvalGraphDepth = 3
+const maxEvalGraphPositions = 400
+const evalGraphTimeout = 100 * time.Millisecond
+
+var games = make(map[string]*Game)
+var connectedClients = make([]*Client, 0)
+
+// unloadedGames holds the ids of stored games that are not in memory
+// without lazy loading: those LoadGames failed to restore and finished
+// games EvictGame dropped. SaveGame and SaveGames never delete their
+// files.
+var unloadedGames = make(map[string]bool)
+
+// gamesMu guards games, storedIndex, indexRecords and unloadedGames,
+// clientsMu guards connectedClients, gameClients and the GameID and
+// Spectator of registered clients. When both are needed gamesMu is taken
+// first.
+var gamesMu sync.RWMutex
+var clientsMu sync.RWMutex
+
+// NewServer returns the HTTP server serving the router on addr.
+func NewServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:    addr,
+		Handler: NewRouter(),
+		// a stalled upgrade request is dropped instead of holding the
+		// connection open
+		ReadHeaderTimeout: WsHandshakeTimeout,
+	}
+}
+
+// Serve serves HTTP on the listener. With a certificate configured the
+// websocket is served as wss on the same port.
+func Serve(server *http.Server, listener net.Listener) error {
+	if TLSCertFile != "" && TLSKeyFile != "" {
+		return server.ServeTLS(listener, TLSCertFile, TLSKeyFile)
+	}
+
+	return server.Serve(listener)
+}
+
+// NewRouter registers the HTTP and websocket routes.
+func NewRouter() *gin.Engine {
+	r := gin.Default()
+
+	// the probes don't touch the games, so they stay fast under load
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	r.GET("/readyz", func(c *gin.Context) {
+		if !IsReady() {
+			message := LoadError()
+			if message == "" {
+				message = "Shutting down"
+			}
+
+			c.JSON(503, gin.H{"status": "unavailable", "message": message})
+			return
+		}
+
+		c.JSON(200, gin.H{"status": "ready"})
+	})
+
+	r.GET("/ws", func(c *gin.Context) {
+		queryId := c.Query("id")
+		var id string
+		var resumeGameID string
+
+		if reconnectToken := c.Query("reconnectToken"); reconnectToken != "" {
+			session, ok := ConsumeReconnectToken(reconnectToken, time.Now())
+			if !ok {
+				c.JSON(401, gin.H{"message": "Invalid reconnect token"})
+				return
+			}
+
+			id = session.PlayerID
+			resumeGameID = session.GameID
+		} else if queryId == "" {
+			id = uuid.New().String()
+		} else if queryId == BotPlayerID {
+			c.JSON(400, gin.H{"message": "Reserved player id"})
+			return
+		} else {
+			id = queryId
+		}
+
+		wsHandlers.Add(1)
+		defer wsHandlers.Done()
+
+		err := WsHandler(c, id, resumeGameID)
+		if err != nil {
+			slog.Error("websocket connection failed", "client_id", id, "error", err)
+		}
+	})
+
+	r.GET("/games", func(c *gin.Context) {
+		status := c.Query("status")
+		if status != "" && status != GameStatusActive && status != GameStatusFinished {
+			c.JSON(400, gin.H{"message": "Invalid status"})
+			return
+		}
+
+		sortBy := c.Query("sort")
+		if sortBy != "" && !IsValidGameSort(sortBy) {
+			c.JSON(400, gin.H{"message": "Invalid sort"})
+			return
+		}
+
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			c.JSON(400, gin.H{"message": "Invalid offset"})
+			return
+		}
+
+		// without a limit all games from the offset on are listed
+		limit := 0
+		if c.Query("limit") != "" {
+			limit, err = strconv.Atoi(c.Query("limit"))
+			if err != nil || limit < 1 {
+				c.JSON(400, gin.H{"message": "Invalid limit"})
+				return
+			}
+		}
+
+		summaries := ListGames(status, sortBy)
+
+		c.Header("X-Total-Count", strconv.Itoa(len(summaries)))
+		c.JSON(200, PageGameSummaries(summaries, offset, limit))
+	})
+
+	r.GET("/game/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		positions := game.Game.Positions()
+		createdAt, updatedAt := game.CreatedAt, game.UpdatedAt
+		game.mu.RUnlock()
+
+		fens := make([]string, 0)
+
+		for _, pos := range positions {
+			fens = append(fens, pos.String())
+		}
+
+		// the body stays a plain list of FENs, the timestamps go in headers
+		c.Header("X-Created-At", createdAt.UTC().Format(time.RFC3339))
+		c.Header("X-Updated-At", updatedAt.UTC().Format(time.RFC3339))
+		c.JSON(200, fens)
+	})
+
+	r.GET("/game/:id/moves", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		history := MoveHistory(game)
+		game.mu.RUnlock()
+
+		c.JSON(200, history)
+	})
+
+	r.GET("/game/:id/heatmap", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		heatmap := GenerateHeatmap(game)
+		game.mu.RUnlock()
+
+		c.JSON(200, heatmap)
+	})
+
+	r.GET("/game/:id/board64", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, gin.H{"board": GenerateBoard64(pos.Board())})
+	})
+
+	r.GET("/game/:id/pgn", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pgn := ExportPGN(game)
+		game.mu.RUnlock()
+
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pgn\"", id))
+		c.Data(200, "application/x-chess-pgn", []byte(pgn))
+	})
+
+	r.GET("/game/:id/placement", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		// just the piece placement field of the FEN, without castling
+		// rights, en passant square and move counters
+		c.JSON(200, gin.H{
+			"placement": pos.Board().String(),
+			"turn":      ColorCode(pos.Turn()),
+		})
+	})
+
+	// the live position only, for clients that don't need the history
+	r.GET("/game/:id/fen", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, gin.H{
+			"fen":            pos.String(),
+			"turn":           ColorCode(pos.Turn()),
+			"fullmoveNumber": MoveNumber(pos),
+		})
+	})
+
+	r.GET("/game/:id/turn", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		outcome := game.Game.Outcome()
+		turn := game.Game.Position().Turn()
+		game.mu.RUnlock()
+
+		if outcome != chess.NoOutcome {
+			c.JSON(200, gin.H{
+				"terminal": true,
+				"outcome":  outcome.String(),
+			})
+			return
+		}
+
+		playerId := PlayerIdForColor(game, turn)
+
+		c.JSON(200, gin.H{
+			"terminal": false,
+			"turn":     ColorCode(turn),
+			"playerId": playerId,
+			"online":   IsPlayerConnected(id, playerId),
+		})
+	})
+
+	r.GET("/game/:id/check", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+		turn := pos.Turn()
+		kingSq := KingSquare(pos.Board(), turn)
+
+		attackers := make([]string, 0)
+		if kingSq != chess.NoSquare {
+			for _, sq := range AttackersOf(pos.Board(), kingSq, turn.Other()) {
+				attackers = append(attackers, sq.String())
+			}
+		}
+
+		kingSquare := ""
+		if kingSq != chess.NoSquare {
+			kingSquare = kingSq.String()
+		}
+
+		c.JSON(200, gin.H{
+			"inCheck":    len(attackers) > 0,
+			"kingSquare": kingSquare,
+			"attackers":  attackers,
+		})
+	})
+
+	r.GET("/game/:id/pins", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, gin.H{
+			"color": ColorCode(pos.Turn()),
+			"pins":  Pins(pos.Board(), pos.Turn()),
+		})
+	})
+
+	r.GET("/game/:id/phase", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, gin.H{
+			"phase":           GamePhase(pos),
+			"moveNumber":      MoveNumber(pos),
+			"nonPawnMaterial": NonPawnMaterial(pos.Board()),
+		})
+	})
+
+	r.GET("/game/:id/captured", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		captured := CapturedPieces(game.Game)
+		game.mu.RUnlock()
+
+		// white and black list the pieces of that color that were taken
+		c.JSON(200, gin.H{
+			"white": GroupCapturedPieces(captured[chess.White]),
+			"black": GroupCapturedPieces(captured[chess.Black]),
+		})
+	})
+
+	r.GET("/game/:id/hash", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, gin.H{"hash": PositionHash(pos)})
+	})
+
+	r.GET("/game/:id/png", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(defaultPNGSize)))
+		if err != nil || size < minPNGSize || size > maxPNGSize {
+			c.JSON(400, gin.H{"message": "Invalid size"})
+			return
+		}
+
+		orientation := c.DefaultQuery("orientation", ColorWhite)
+		if orientation != ColorWhite && orientation != ColorBlack {
+			c.JSON(400, gin.H{"message": "Invalid orientation"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		data, err := RenderBoardPNG(pos.Board(), size, orientation == ColorBlack)
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		c.Data(200, "image/png", data)
+	})
+
+	r.GET("/game/:id/perft/divide", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+		if err != nil || depth < 1 || depth > maxPerftDepth {
+			c.JSON(400, gin.H{"message": "Invalid depth"})
+			return
+		}
+
+		game.mu.RLock()
+		pos := game.Game.Position()
+		game.mu.RUnlock()
+
+		c.JSON(200, PerftDivide(pos, depth))
+	})
+
+	r.GET("/game/:id/evalgraph", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+		if err != nil || depth < 0 || depth > maxEvalGraphDepth {
+			c.JSON(400, gin.H{"message": "Invalid depth"})
+			return
+		}
+
+		game.mu.RLock()
+		positions := game.Game.Positions()
+		game.mu.RUnlock()
+
+		if len(positions) > maxEvalGraphPositions {
+			c.JSON(400, gin.H{"message": "Game too long"})
+			return
+		}
+
+		scores := make([]int, 0, len(positions))
+		for _, pos := range positions {
+			scores = append(scores, Evaluate(pos, depth, evalGraphTimeout))
+		}
+
+		c.JSON(200, gin.H{"depth": depth, "scores": scores})
+	})
+
+	r.POST("/game", func(c *gin.Context) {
+		id := uuid.New().String()
+
+		// the key is reserved before the game exists, so a retry racing
+		// the first request can't create a second game. It is told to
+		// retry until the first request added the game, which may still
+		// fail and release the key.
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if idempotencyKey != "" {
+			if existingId, reserved := ReserveIdempotencyKey(idempotencyKey, id, time.Now()); !reserved {
+				existingGame, ok := GetGame(existingId)
+				if !ok {
+					c.JSON(409, gin.H{"message": "A request with this key is still in progress, retry later"})
+					return
+				}
+
+				c.JSON(200, NewCreateGameResponse(existingId, existingGame))
+				return
+			}
+		}
+
+		var request CreateGameRequest
+		err := c.BindJSON(&request)
 		if err != nil {
-			break
+			ReleaseIdempotencyKey(idempotencyKey, id)
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
 		}
 
-		var wsMsg WebsocketMessage
-		err = json.Unmarshal(msg, &wsMsg)
+		newGame, err := NewGameFromRequest(request)
 		if err != nil {
-			break
+			ReleaseIdempotencyKey(idempotencyKey, id)
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
 		}
 
-		switch wsMsg.Type {
-		case "leave":
-			break
-		case "join":
-			err := HandleJoin(wsMsg, newClient)
-			if err != nil {
-				fmt.Println(err)
+		err = AddGame(id, newGame)
+		if err != nil {
+			ReleaseIdempotencyKey(idempotencyKey, id)
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		// a bot playing white opens right away
+		PlayBotMove(id, newGame)
+
+		c.JSON(200, NewCreateGameResponse(id, newGame))
+	})
+
+	r.POST("/matchmake", func(c *gin.Context) {
+		var request MatchmakeRequest
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
+		}
+
+		if request.PlayerID == "" {
+			c.JSON(400, gin.H{"message": "playerId is required"})
+			return
+		}
+
+		playerID, err := NormalizePlayerID(request.PlayerID)
+		if err != nil {
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
+		}
+
+		if playerID == BotPlayerID {
+			c.JSON(400, gin.H{"message": "Reserved player id"})
+			return
+		}
+
+		response, err := Matchmake(playerID)
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, response)
+	})
+
+	r.DELETE("/matchmake/:playerId", func(c *gin.Context) {
+		if !LeaveMatchmaking(c.Param("playerId")) {
+			c.JSON(404, gin.H{"message": "Player not queued"})
+			return
+		}
+
+		c.Status(204)
+	})
+
+	r.DELETE("/game/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		game.mu.RLock()
+		inProgress := game.Game.Outcome() == chess.NoOutcome && !game.Abandoned
+		game.mu.RUnlock()
+
+		// only finished or abandoned games can be removed
+		if inProgress {
+			c.JSON(409, gin.H{"message": "Game in progress"})
+			return
+		}
+
+		CloseGameClients(id, "Game deleted")
+
+		err := DeleteGame(id)
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, gin.H{"id": id})
+	})
+
+	r.POST("/game/:id/simulate", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		var request SimulateRequest
+		err := c.BindJSON(&request)
+		if err != nil || len(request.Moves) > maxSimulateMoves {
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
+		}
+
+		// work on a clone so the stored game is never touched
+		game.mu.RLock()
+		simulated := &Game{
+			Game:     game.Game.Clone(),
+			Notation: game.Notation,
+		}
+		game.mu.RUnlock()
+
+		failedIndex := -1
+		for i, moveStr := range request.Moves {
+			if simulated.Game.Outcome() != chess.NoOutcome {
+				failedIndex = i
+				break
+			}
+
+			m, ok := IsLegalMove(simulated, moveStr)
+			if !ok {
+				failedIndex = i
+				break
 			}
-		case "move":
-			err := HandleMove(wsMsg, newClient)
 
+			err := simulated.Game.Move(m)
 			if err != nil {
-				fmt.Println(err)
+				failedIndex = i
+				break
 			}
-		default:
-			break
 		}
-	}
 
-	return nil
-}
+		c.JSON(200, gin.H{
+			"fen":         simulated.Game.Position().String(),
+			"legal":       failedIndex == -1,
+			"failedIndex": failedIndex,
+			"outcome":     simulated.Game.Outcome().String(),
+			"method":      simulated.Game.Method().String(),
+		})
+	})
 
-func SaveGames() error {
-	stored := make(StoredGames)
+	r.POST("/game/:id/variations", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
 
-	for id, game := range games {
-		pgn, err := game.Game.MarshalText()
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		var request AddVariationRequest
+		err := c.BindJSON(&request)
 		if err != nil {
-			return err
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
 		}
 
-		storedGame := StoredGame{
-			PGNStr:        string(pgn),
-			WhitePlayerId: game.WhitePlayerId,
-			BlackPlayerId: game.BlackPlayerId,
+		game.mu.Lock()
+		moves, err := ValidateVariation(game, request.Ply, request.Moves)
+		if err != nil {
+			game.mu.Unlock()
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
 		}
 
-		stored[id] = storedGame
-	}
+		game.Variations = append(game.Variations, Variation{
+			Ply:   request.Ply,
+			Moves: moves,
+		})
+		tree := GenerateMoveTree(game)
+		game.mu.Unlock()
 
-	data, err := json.Marshal(stored)
-	if err != nil {
-		return err
-	}
+		err = SaveGame(id)
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
 
-	return os.WriteFile("games.json", data, 0644)
-}
+		c.JSON(200, tree)
+	})
 
-func LoadGames() error {
-	data, err := os.ReadFile("games.json")
-	if err != nil {
-		return err
-	}
+	r.PUT("/game/:id/annotations/:ply", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
 
-	storedGames := make(StoredGames)
-	if err := json.Unmarshal(data, &storedGames); err != nil {
-		return err
-	}
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
 
-	games = make(map[string]*Game)
-	for id, storedGame := range storedGames {
-		game := chess.NewGame(chess.UseNotation(chess.LongAlgebraicNotation{}))
+		ply, err := strconv.Atoi(c.Param("ply"))
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid ply"})
+			return
+		}
 
-		if err := game.UnmarshalText([]byte(storedGame.PGNStr)); err != nil {
-			return err
+		var request SetAnnotationRequest
+		err = c.BindJSON(&request)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
 		}
 
-		newGame := &Game{
-			Game:          game,
-			WhitePlayerId: storedGame.WhitePlayerId,
-			BlackPlayerId: storedGame.BlackPlayerId,
+		nag, err := ParseNAG(request.NAG)
+		if err != nil {
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
 		}
 
-		games[id] = newGame
-	}
+		if nag == 0 && request.Comment == "" {
+			c.JSON(400, gin.H{"message": "Annotation is empty"})
+			return
+		}
 
-	return nil
-}
+		game.mu.Lock()
+		err = ValidateAnnotationPly(game, ply)
+		if err != nil {
+			game.mu.Unlock()
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
+		}
 
-var games = make(map[string]*Game)
-var connectedClients = make([]*Client, 0)
+		if game.Annotations == nil {
+			game.Annotations = make(map[int]Annotation)
+		}
+		game.Annotations[ply] = Annotation{NAG: nag, Comment: request.Comment}
+		annotations := maps.Clone(game.Annotations)
+		game.mu.Unlock()
 
-func main() {
-	r := gin.Default()
-	err := LoadGames()
-	if err != nil {
-		fmt.Println(err)
-	}
+		err = SaveGame(id)
+		if err != nil {
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
+		}
 
-	r.GET("/ws", func(c *gin.Context) {
-		queryId := c.Query("id")
-		var id string
+		c.JSON(200, annotations)
+	})
 
-		if queryId == "" {
-			id = uuid.New().String()
-		} else {
-			id = queryId
+	r.DELETE("/game/:id/annotations/:ply", func(c *gin.Context) {
+		id := c.Param("id")
+		game, ok := GetGame(id)
+
+		if !ok {
+			c.JSON(404, gin.H{"message": "Game not found"})
+			return
+		}
+
+		ply, err := strconv.Atoi(c.Param("ply"))
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid ply"})
+			return
+		}
+
+		game.mu.Lock()
+		err = ValidateAnnotationPly(game, ply)
+		if err != nil {
+			game.mu.Unlock()
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
 		}
 
-		err := WsHandler(c, id)
+		delete(game.Annotations, ply)
+		annotations := maps.Clone(game.Annotations)
+		game.mu.Unlock()
+
+		err = SaveGame(id)
 		if err != nil {
-			fmt.Println(err)
+			c.JSON(500, gin.H{"message": "Internal server error"})
+			return
 		}
+
+		c.JSON(200, annotations)
 	})
 
-	r.GET("/game/:id", func(c *gin.Context) {
+	r.GET("/game/:id/tree", func(c *gin.Context) {
 		id := c.Param("id")
-		game, ok := games[id]
+		game, ok := GetGame(id)
 
 		if !ok {
 			c.JSON(404, gin.H{"message": "Game not found"})
 			return
 		}
 
-		fens := make([]string, 0)
-
-		for _, pos := range game.Game.Positions() {
-			fens = append(fens, pos.String())
-		}
+		game.mu.RLock()
+		tree := GenerateMoveTree(game)
+		game.mu.RUnlock()
 
-		c.JSON(200, fens)
+		c.JSON(200, tree)
 	})
 
-	r.POST("/game", func(c *gin.Context) {
-		id := uuid.New().String()
-		var request CreateGameRequest
+	r.POST("/analysis", func(c *gin.Context) {
+		var request AnalysisRequest
 		err := c.BindJSON(&request)
 		if err != nil {
 			c.JSON(400, gin.H{"message": "Bad request"})
 			return
 		}
 
-		fenStr := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
-		fen, err := chess.FEN(fenStr)
+		analysis, err := AnalyzeFEN(request.Fen)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Invalid FEN"})
+			return
+		}
+
+		c.JSON(200, analysis)
+	})
+
+	r.GET("/players/:id/stats", func(c *gin.Context) {
+		c.JSON(200, ComputePlayerStats(c.Param("id")))
+	})
+
+	r.GET("/players/:id/to-move", func(c *gin.Context) {
+		c.JSON(200, GamesAwaitingMove(c.Param("id")))
+	})
+
+	admin := r.Group("/admin", AdminAuth())
+
+	admin.GET("/export", func(c *gin.Context) {
+		archive, err := ExportState(time.Now())
 		if err != nil {
 			c.JSON(500, gin.H{"message": "Internal server error"})
 			return
 		}
 
-		game := chess.NewGame(fen, chess.UseNotation(chess.LongAlgebraicNotation{}))
+		c.Header("Content-Disposition", "attachment; filename=\"chess-api-export.json\"")
+		c.JSON(200, archive)
+	})
 
-		newGame := &Game{
-			Game:          game,
-			WhitePlayerId: "",
-			BlackPlayerId: "",
+	admin.POST("/import", func(c *gin.Context) {
+		mode := c.DefaultQuery("mode", "merge")
+		if mode != "merge" && mode != "replace" {
+			c.JSON(400, gin.H{"message": "Invalid mode"})
+			return
 		}
 
-		if request.PreferredColor == "w" {
-			newGame.WhitePlayerId = request.Player1
-			newGame.BlackPlayerId = request.Player2
-		} else if request.PreferredColor == "b" {
-			newGame.WhitePlayerId = request.Player2
-			newGame.BlackPlayerId = request.Player1
-		} else {
-			randomNumber := rand.IntN(1-0) + 0
-			if randomNumber == 0 {
-				newGame.WhitePlayerId = request.Player1
-				newGame.BlackPlayerId = request.Player2
-			} else {
-				newGame.WhitePlayerId = request.Player2
-				newGame.BlackPlayerId = request.Player1
-			}
+		var archive StateArchive
+		err := c.BindJSON(&archive)
+		if err != nil {
+			c.JSON(400, gin.H{"message": "Bad request"})
+			return
+		}
+
+		imported, err := ImportState(archive, mode == "replace")
+		if err != nil {
+			c.JSON(400, gin.H{"message": err.Error()})
+			return
 		}
 
-		games[id] = newGame
+		c.JSON(200, gin.H{"imported": imported})
+	})
+
+	admin.DELETE("/games", func(c *gin.Context) {
+		if c.Query("confirm") != "true" {
+			c.JSON(400, gin.H{"message": "Confirmation required"})
+			return
+		}
 
-		err = SaveGames()
+		removed, err := ClearGames()
 		if err != nil {
 			c.JSON(500, gin.H{"message": "Internal server error"})
 			return
 		}
 
-		c.JSON(200, gin.H{"id": id})
+		c.JSON(200, gin.H{"removed": removed})
 	})
 
-	err = r.Run(":4000")
+	return r
+}
+
+func main() {
+	LoadConfig()
+
+	LoadGamesForReadiness()
+
+	workers, cancelWorkers := context.WithCancel(context.Background())
+	var workersDone sync.WaitGroup
+
+	workersDone.Add(2)
+	go func() {
+		defer workersDone.Done()
+		RunClockSweeper(workers)
+	}()
+	go func() {
+		defer workersDone.Done()
+		RunPersistWorker(workers)
+	}()
+
+	server := NewServer(":4000")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err == nil {
+			err = Serve(server, listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "error", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := Shutdown(shutdownCtx, server, func() {
+		cancelWorkers()
+		workersDone.Wait()
+	})
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("shutdown failed", "error", err)
 	}
 }
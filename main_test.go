@@ -0,0 +1,1518 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/notnil/chess"
+)
+
+func TestHeatmapCountsOccupiedSquares(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "heat", CreateGameRequest{})
+	playMoves(t, "heat", game, "e2e4", "e7e5", "g1f3")
+
+	recorder := doRequest(t, http.MethodGet, "/game/heat/heatmap", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+
+	var heatmap map[string]int
+	decodeJSON(t, recorder, &heatmap)
+
+	// four positions: the start and one after each move
+	want := map[string]int{
+		"e1": 4, // the king never moved
+		"e4": 3, // occupied after 1. e4
+		"e2": 1, // vacated by 1. e4
+		"f3": 1, // only after 2. Nf3
+		"g1": 3,
+		"e5": 2,
+		"d4": 0,
+	}
+	for square, count := range want {
+		if heatmap[square] != count {
+			t.Errorf("heatmap[%s] = %d, want %d", square, heatmap[square], count)
+		}
+	}
+
+	if len(heatmap) != 64 {
+		t.Errorf("heatmap has %d squares, want 64", len(heatmap))
+	}
+}
+
+func TestHeatmapUnknownGame(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, http.MethodGet, "/game/missing/heatmap", nil)
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", recorder.Code)
+	}
+}
+
+func TestCreateGameRejectsSelfPlay(t *testing.T) {
+	resetState(t)
+
+	for _, players := range [][2]string{{"alice", "alice"}, {"alice", " alice "}} {
+		recorder := doRequest(t, http.MethodPost, "/game", CreateGameRequest{
+			Player1: players[0],
+			Player2: players[1],
+		})
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("players %q: status = %d, want 400", players, recorder.Code)
+		}
+	}
+
+	if len(games) != 0 {
+		t.Fatalf("%d games created, want none", len(games))
+	}
+
+	recorder := doRequest(t, http.MethodPost, "/game", CreateGameRequest{
+		Player1: "alice",
+		Player2: "bob",
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("distinct players: status = %d, want 200", recorder.Code)
+	}
+}
+
+func TestCreateGameValidatesPlayerIDs(t *testing.T) {
+	resetState(t)
+
+	for name, request := range map[string]CreateGameRequest{
+		"blank":     {Player1: "   ", Player2: "bob"},
+		"identical": {Player1: "bob", Player2: "bob"},
+		"too long":  {Player1: strings.Repeat("a", maxPlayerIDLength+1), Player2: "bob"},
+	} {
+		recorder := doRequest(t, http.MethodPost, "/game", request)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", name, recorder.Code)
+		}
+	}
+
+	recorder := doRequest(t, http.MethodPost, "/game", CreateGameRequest{
+		Player1:        " alice ",
+		PreferredColor: ColorWhite,
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("omitted id: status = %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response CreateGameResponse
+	decodeJSON(t, recorder, &response)
+
+	if response.WhitePlayerId != "alice" {
+		t.Errorf("white %q, want the trimmed alice", response.WhitePlayerId)
+	}
+	if _, err := uuid.Parse(response.BlackPlayerId); err != nil {
+		t.Errorf("black %q, want a generated uuid", response.BlackPlayerId)
+	}
+}
+
+func TestSwitchMovesBroadcastsToTheNewGame(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "A", CreateGameRequest{Player1: "bob", Player2: "alice", PreferredColor: ColorWhite})
+	gameB := newTestGame(t, "B", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+	gameB.Chat = []ChatEntry{{GameID: "B", PlayerID: "carol", Text: "hi"}}
+
+	server := newTestServer(t)
+
+	// alice is resumed into her game A on connect
+	alice := dialWS(t, server, "id=alice")
+	alice.expect("against")
+
+	bob := dialWS(t, server, "id=bob")
+	bob.expect("against")
+	carol := dialWS(t, server, "id=carol")
+	carol.expect("against")
+
+	alice.send("switch", SwitchMessage{GameID: "B"})
+	alice.expect("players")
+	alice.expect("capturedPieces")
+	alice.expect("chatHistory")
+
+	var state StateMessage
+	alice.expect("state", &state)
+	if state.GameID != "B" {
+		t.Fatalf("state for %q, want B", state.GameID)
+	}
+
+	bob.send("move", MoveMessage{GameID: "A", Move: "e2e4"})
+	bob.expect("moveAck")
+	// bob's read loop is sequential, so the answer also means the move
+	// broadcast for A is done
+	bob.send("getPgn", GetPgnMessage{GameID: "A"})
+	bob.expect("pgn")
+
+	carol.send("move", MoveMessage{GameID: "B", Move: "d2d4"})
+	carol.expect("moveAck")
+
+	var answer MoveAnswer
+	alice.expect("move", &answer)
+	if answer.GameID != "B" || answer.Move != "d2d4" {
+		t.Fatalf("alice got move %s in %s, want d2d4 in B", answer.Move, answer.GameID)
+	}
+}
+
+func TestSwitchClearsAbandoned(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "A", CreateGameRequest{})
+	game.Abandoned = true
+
+	server := newTestServer(t)
+	// without an id the connection isn't resumed into any game
+	client := dialWS(t, server, "")
+	client.send("switch", SwitchMessage{GameID: "A"})
+	client.expect("state")
+
+	// a spectator doesn't bring the game back
+	game.mu.Lock()
+	abandoned := game.Abandoned
+	game.mu.Unlock()
+	if !abandoned {
+		t.Fatal("spectator cleared Abandoned")
+	}
+
+	// bob is resumed into A on connect, which clears the flag as well
+	bob := dialWS(t, server, "id=bob")
+	bob.expect("against")
+
+	game.mu.Lock()
+	game.Abandoned = true
+	game.mu.Unlock()
+
+	bob.send("switch", SwitchMessage{GameID: "A"})
+	bob.expect("state")
+
+	game.mu.Lock()
+	abandoned = game.Abandoned
+	game.mu.Unlock()
+	if abandoned {
+		t.Fatal("player switching in didn't clear Abandoned")
+	}
+}
+
+func TestBoard64StartingPosition(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "b64", CreateGameRequest{})
+
+	recorder := doRequest(t, http.MethodGet, "/game/b64/board64", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+
+	var response struct {
+		Board string `json:"board"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	want := "rnbqkbnr" +
+		"pppppppp" +
+		"........" +
+		"........" +
+		"........" +
+		"........" +
+		"PPPPPPPP" +
+		"RNBQKBNR"
+	if response.Board != want {
+		t.Fatalf("board = %q, want %q", response.Board, want)
+	}
+}
+
+func TestBoard64AfterMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "b64", CreateGameRequest{})
+	playMoves(t, "b64", game, "e2e4")
+
+	board := GenerateBoard64(game.Game.Position().Board())
+	// e4 is the fifth character of rank 4, e2 of rank 2
+	if board[4*8+4] != 'P' || board[6*8+4] != '.' {
+		t.Fatalf("board after 1. e4 = %q", board)
+	}
+}
+
+func TestIllegalMovesDisconnectPastThreshold(t *testing.T) {
+	resetState(t)
+
+	limit := MaxIllegalMoves
+	MaxIllegalMoves = 3
+	t.Cleanup(func() { MaxIllegalMoves = limit })
+
+	newTestGame(t, "abuse", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	illegal := func() {
+		t.Helper()
+		alice.send("move", MoveMessage{GameID: "abuse", Move: "e2e5"})
+		alice.expect("error")
+	}
+
+	// two illegal moves, then a legal one resets the count
+	illegal()
+	illegal()
+	alice.send("move", MoveMessage{GameID: "abuse", Move: "e2e4"})
+	alice.expect("moveAck")
+	bob.send("move", MoveMessage{GameID: "abuse", Move: "e7e5"})
+	bob.expect("moveAck")
+
+	illegal()
+	illegal()
+	alice.send("move", MoveMessage{GameID: "abuse", Move: "g1f3"})
+	alice.expect("moveAck")
+	bob.send("move", MoveMessage{GameID: "abuse", Move: "b8c6"})
+	bob.expect("moveAck")
+
+	// three in a row close the connection
+	illegal()
+	illegal()
+	alice.send("move", MoveMessage{GameID: "abuse", Move: "e2e5"})
+
+	alice.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := alice.conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Fatalf("read error %v, want a policy violation close", err)
+		}
+		break
+	}
+}
+
+func TestEvalGraphScoresEveryPosition(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "eval", CreateGameRequest{})
+	playMoves(t, "eval", game, "e2e4", "e7e5", "f1c4", "b8c6", "d1h5", "g8f6", "h5f7")
+
+	recorder := doRequest(t, http.MethodGet, "/game/eval/evalgraph?depth=1", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+
+	var response struct {
+		Depth  int   `json:"depth"`
+		Scores []int `json:"scores"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	if len(response.Scores) != len(game.Game.Positions()) {
+		t.Fatalf("%d scores for %d positions", len(response.Scores), len(game.Game.Positions()))
+	}
+
+	for i, score := range response.Scores {
+		if score < -MateScore || score > MateScore {
+			t.Errorf("score %d at ply %d is out of range", score, i)
+		}
+	}
+
+	// the game ends in mate for white
+	if last := response.Scores[len(response.Scores)-1]; last <= 0 {
+		t.Errorf("final score %d, want white ahead", last)
+	}
+}
+
+func TestEvalGraphRejectsDeepSearches(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "eval", CreateGameRequest{})
+
+	recorder := doRequest(t, http.MethodGet, "/game/eval/evalgraph?depth=10", nil)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", recorder.Code)
+	}
+}
+
+func TestValidateBatchMixedCandidates(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "batch", CreateGameRequest{})
+	playMoves(t, "batch", game, "e2e4", "d7d5")
+	fen := game.Game.Position().String()
+
+	server := newTestServer(t)
+	client := dialWS(t, server, "")
+	client.send("validateBatch", ValidateBatchMessage{
+		GameID: "batch",
+		Moves:  []string{"e4d5", "f1b5", "g1f3", "e2e4", "e4e6", "zz"},
+	})
+
+	var answer ValidateBatchAnswer
+	client.expect("validateBatch", &answer)
+
+	want := []MoveValidation{
+		{Move: "e4d5", Legal: true, Capture: true},
+		{Move: "f1b5", Legal: true, Check: true},
+		{Move: "g1f3", Legal: true},
+		{Move: "e2e4"},
+		{Move: "e4e6"},
+		{Move: "zz"},
+	}
+	if len(answer.Results) != len(want) {
+		t.Fatalf("%d results, want %d", len(answer.Results), len(want))
+	}
+	for i, result := range answer.Results {
+		if result != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+
+	if game.Game.Position().String() != fen {
+		t.Fatal("validating moves changed the position")
+	}
+}
+
+func TestValidateBatchIsBounded(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "batch", CreateGameRequest{})
+
+	server := newTestServer(t)
+	client := dialWS(t, server, "")
+
+	moves := make([]string, maxValidateBatchSize+1)
+	for i := range moves {
+		moves[i] = "e2e4"
+	}
+	client.send("validateBatch", ValidateBatchMessage{GameID: "batch", Moves: moves})
+	client.send("getPgn", GetPgnMessage{GameID: "batch"})
+
+	msg, ok := client.read(2 * time.Second)
+	if !ok || msg.Type != "pgn" {
+		t.Fatalf("got %q, want the oversized batch to be dropped", msg.Type)
+	}
+}
+
+type turnResponse struct {
+	Terminal bool   `json:"terminal"`
+	Outcome  string `json:"outcome"`
+	Turn     string `json:"turn"`
+	PlayerID string `json:"playerId"`
+	Online   bool   `json:"online"`
+}
+
+func getTurn(t *testing.T, id string) turnResponse {
+	t.Helper()
+
+	recorder := doRequest(t, http.MethodGet, "/game/"+id+"/turn", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+
+	var response turnResponse
+	decodeJSON(t, recorder, &response)
+
+	return response
+}
+
+func TestTurnReportsPlayerAndPresence(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "turn", CreateGameRequest{})
+	playMoves(t, "turn", game, "e2e4")
+
+	want := turnResponse{Turn: ColorBlack, PlayerID: "bob"}
+	if got := getTurn(t, "turn"); got != want {
+		t.Fatalf("turn = %+v, want %+v", got, want)
+	}
+
+	server := newTestServer(t)
+	dialPlayer(t, server, "bob")
+
+	want.Online = true
+	if got := getTurn(t, "turn"); got != want {
+		t.Fatalf("with bob connected: turn = %+v, want %+v", got, want)
+	}
+}
+
+func TestTurnIsTerminalAfterCheckmate(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "turn", CreateGameRequest{})
+	playMoves(t, "turn", game, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	want := turnResponse{Terminal: true, Outcome: "0-1"}
+	if got := getTurn(t, "turn"); got != want {
+		t.Fatalf("turn = %+v, want %+v", got, want)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key, and returns their paths and a pool trusting the certificate.
+func writeTestCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "chess-api test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestServeWebsocketOverTLS(t *testing.T) {
+	resetState(t)
+
+	certFile, keyFile, pool := writeTestCertificate(t)
+
+	certSetting, keySetting := TLSCertFile, TLSKeyFile
+	TLSCertFile, TLSKeyFile = certFile, keyFile
+	t.Cleanup(func() { TLSCertFile, TLSKeyFile = certSetting, keySetting })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: NewRouter()}
+	go Serve(server, listener)
+	t.Cleanup(func() { server.Close() })
+
+	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	conn, _, err := dialer.Dial("wss://"+listener.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatalf("wss dial: %v", err)
+	}
+	defer conn.Close()
+
+	var hello WebsocketMessage
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	err = conn.ReadJSON(&hello)
+	if err != nil || hello.Type != "hello" {
+		t.Fatalf("first message %q, %v, want hello", hello.Type, err)
+	}
+
+	// plain HTTP is not served on the TLS port
+	_, _, err = websocket.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws", nil)
+	if err == nil {
+		t.Fatal("plain ws connection succeeded on the TLS port")
+	}
+}
+
+func TestColorCodesAreConsistent(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "colors", CreateGameRequest{})
+
+	// against names the opponent and its color
+	for client, want := range map[string]string{"alice": ColorBlack, "bob": ColorWhite} {
+		data, err := GenerateAgainstMessage(game, &Client{ID: client})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var against AgainstMessage
+		decodeWebsocketPayload(t, data, &against)
+		if against.Color != want {
+			t.Errorf("%s: against color %q, want %q", client, against.Color, want)
+		}
+	}
+
+	playMoves(t, "colors", game, "f2f3")
+
+	data, err := GenerateStateMessage("colors", game)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state StateMessage
+	decodeWebsocketPayload(t, data, &state)
+	if state.Turn != ColorBlack {
+		t.Errorf("state turn %q, want %q", state.Turn, ColorBlack)
+	}
+
+	var turn struct {
+		Turn string `json:"turn"`
+	}
+	decodeJSON(t, doRequest(t, http.MethodGet, "/game/colors/turn", nil), &turn)
+	if turn.Turn != ColorBlack {
+		t.Errorf("turn endpoint %q, want %q", turn.Turn, ColorBlack)
+	}
+
+	playMoves(t, "colors", game, "e7e5", "g2g4", "d8h4")
+
+	data, err = GenerateOutcomeMessage("colors", game, DefaultLocale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outcome OutcomeMessage
+	decodeWebsocketPayload(t, data, &outcome)
+	if outcome.Winner != ColorBlack {
+		t.Errorf("outcome winner %q, want %q", outcome.Winner, ColorBlack)
+	}
+	if outcome.Text == "" || outcome.Text == outcome.Winner {
+		t.Errorf("outcome text %q should be human-readable", outcome.Text)
+	}
+}
+
+// failAfterHandshakeConn lets the websocket handshake through and fails
+// every later write.
+type failAfterHandshakeConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failAfterHandshakeConn) Write(p []byte) (int, error) {
+	c.writes++
+	if c.writes > 1 {
+		return 0, errors.New("write failed")
+	}
+
+	return c.Conn.Write(p)
+}
+
+type failingHijacker struct {
+	http.ResponseWriter
+}
+
+func (w failingHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &failAfterHandshakeConn{Conn: conn}, rw, nil
+}
+
+func TestFailedHelloDoesNotLeakClient(t *testing.T) {
+	resetState(t)
+
+	result := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := gin.CreateTestContext(failingHijacker{w})
+		c.Request = r
+		result <- WsHandler(c, "alice", "")
+	}))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("handler succeeded despite the failed hello")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not return")
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if len(connectedClients) != 0 {
+		t.Errorf("%d clients left registered, want 0", len(connectedClients))
+	}
+}
+
+func TestSpectatorJoinsIncrementViewCount(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "watched", CreateGameRequest{})
+
+	server := newTestServer(t)
+
+	// each connection is served by its own goroutine, so the joins
+	// run concurrently and none of them may be lost
+	spectators := make([]*testConn, 0)
+	for _, id := range []string{"carol", "dave", "erin"} {
+		spectators = append(spectators, dialWS(t, server, "id="+id))
+	}
+	for _, spectator := range spectators {
+		spectator.send("spectate", SpectateMessage{GameID: "watched"})
+	}
+	for _, spectator := range spectators {
+		spectator.expect("players")
+	}
+
+	// leaving doesn't decrement, joining again counts again
+	spectator := dialWS(t, server, "id=carol")
+	spectator.send("spectate", SpectateMessage{GameID: "watched"})
+	spectator.expect("players")
+
+	game, _ := GetGame("watched")
+	waitFor(t, "four views", func() bool {
+		game.mu.Lock()
+		defer game.mu.Unlock()
+		return game.ViewCount == 4
+	})
+
+	err := SaveGame("watched")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gamesMu.Lock()
+	games = make(map[string]*Game)
+	gamesMu.Unlock()
+
+	err = LoadGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, ok := GetGame("watched")
+	if !ok {
+		t.Fatal("game not loaded")
+	}
+	if loaded.ViewCount != 4 {
+		t.Errorf("view count %d after loading, want 4", loaded.ViewCount)
+	}
+}
+
+func TestUnknownMessageTypeStrict(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+	conn := dialWS(t, server, "id=alice")
+
+	conn.send("bogus", struct{}{})
+
+	var errorMsg ErrorMessage
+	conn.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeUnknownType {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeUnknownType)
+	}
+	if !strings.Contains(errorMsg.Message, "bogus") {
+		t.Errorf("error message %q doesn't echo the type", errorMsg.Message)
+	}
+}
+
+func TestUnknownMessageTypeLenient(t *testing.T) {
+	resetState(t)
+
+	StrictMessageTypes = false
+	t.Cleanup(func() { StrictMessageTypes = true })
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	conn := dialWS(t, server, "id=carol")
+
+	conn.send("bogus", struct{}{})
+	conn.send("getPgn", GetPgnMessage{GameID: "game-1"})
+
+	for _, msg := range conn.readUntil("pgn") {
+		if msg.Type == "error" {
+			t.Errorf("unexpected error in lenient mode: %s", msg.Payload)
+		}
+	}
+}
+
+func TestMoveAckMatchesBroadcastSequence(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	var ack MoveAck
+	var broadcast MoveAnswer
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck", &ack)
+	bob.expect("move", &broadcast)
+	firstSeq := ack.Seq
+
+	bob.send("move", MoveMessage{GameID: "game-1", Move: "e7e5"})
+	bob.expect("moveAck")
+	alice.expect("move")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "g1f3"})
+	alice.expect("moveAck", &ack)
+	bob.expect("move", &broadcast)
+
+	if ack.Seq != broadcast.Seq {
+		t.Errorf("ack seq %d, broadcast seq %d", ack.Seq, broadcast.Seq)
+	}
+	if ack.Seq != firstSeq+2 {
+		t.Errorf("third move has seq %d, want %d", ack.Seq, firstSeq+2)
+	}
+	if ack.Move != "g1f3" || ack.Ply != 3 || ack.Ply != broadcast.Ply {
+		t.Errorf("ack %+v, broadcast ply %d", ack, broadcast.Ply)
+	}
+
+	game.mu.Lock()
+	fen := game.Game.Position().String()
+	game.mu.Unlock()
+	if ack.Fen != fen {
+		t.Errorf("ack fen %s, want %s", ack.Fen, fen)
+	}
+}
+
+func TestJoinSendsRoleSpecificMessages(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4")
+
+	server := newTestServer(t)
+
+	spectator := dialWS(t, server, "id=carol")
+	spectator.send("join", JoinMessage{GameID: "game-1"})
+
+	var players SpectatorMessage
+	spectator.expect("players", &players)
+	if players.WhitePlayerId != "alice" || players.BlackPlayerId != "bob" || players.Role != "spectator" {
+		t.Errorf("spectator got %+v", players)
+	}
+	if players.LastMove == nil || players.LastMove.From != "e2" || players.LastMove.To != "e4" {
+		t.Errorf("spectator got last move %+v, want e2e4", players.LastMove)
+	}
+
+	// players get the opponent instead
+	player := dialPlayer(t, server, "bob")
+	player.send("join", JoinMessage{GameID: "game-1"})
+
+	var against AgainstMessage
+	player.expect("against", &against)
+	if against.ID != "alice" || against.Color != ColorWhite {
+		t.Errorf("player got %+v, want alice with white", against)
+	}
+}
+
+func TestStalledUpgradeIsAborted(t *testing.T) {
+	resetState(t)
+
+	previous := WsHandshakeTimeout
+	WsHandshakeTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { WsHandshakeTimeout = previous })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(listener.Addr().String())
+	go Serve(server, listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the upgrade request never finishes its headers
+	_, err = conn.Write([]byte("GET /ws?id=alice HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+
+	// the server may answer with an error status, but then has to close
+	_, err = io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection still open after %s: %v", time.Since(start), err)
+	}
+}
+
+func TestInterleavedMovesFromTwoClients(t *testing.T) {
+	resetState(t)
+
+	// pings are written by the heartbeat goroutine next to the moves
+	previous := PingInterval
+	PingInterval = time.Millisecond
+	t.Cleanup(func() {
+		// the connections are closed by then, restore the interval once
+		// their handlers are gone
+		waitFor(t, "the clients to disconnect", func() bool {
+			clientsMu.RLock()
+			defer clientsMu.RUnlock()
+
+			return len(connectedClients) == 0
+		})
+		PingInterval = previous
+	})
+
+	newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		InitialSeconds: 300,
+	})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+	carol := dialWS(t, server, "id=carol")
+	carol.send("spectate", SpectateMessage{GameID: "game-1"})
+	carol.expect("players")
+
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				recorder := httptest.NewRecorder()
+				NewRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/games", nil))
+			}
+		}
+	}()
+
+	// every move is sent as soon as the opponent's move arrives, while the
+	// server is still writing the opponent's updates and chat messages to
+	// the same connections
+	moves := []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "d2d3", "f8c5", "b1c3", "d7d6"}
+	players := []*testConn{alice, bob}
+	for i, move := range moves {
+		players[i%2].send("move", MoveMessage{GameID: "game-1", Move: move})
+		for range 5 {
+			players[i%2].send("chat", ChatMessage{GameID: "game-1", Text: "gl"})
+		}
+
+		var answer MoveAnswer
+		players[(i+1)%2].expect("move", &answer)
+		if answer.Move != move {
+			t.Fatalf("opponent got move %s, want %s", answer.Move, move)
+		}
+	}
+
+	close(done)
+	<-polled
+
+	for _, move := range moves {
+		var answer MoveAnswer
+		carol.expect("move", &answer)
+		if answer.Move != move {
+			t.Fatalf("spectator got move %s, want %s", answer.Move, move)
+		}
+	}
+
+	game, _ := GetGame("game-1")
+	game.mu.Lock()
+	plies := len(game.Game.Moves())
+	game.mu.Unlock()
+	if plies != len(moves) {
+		t.Errorf("game has %d plies, want %d", plies, len(moves))
+	}
+}
+
+func TestMoveBroadcastsStayInTheirGame(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "A", CreateGameRequest{})
+	newTestGame(t, "B", CreateGameRequest{Player1: "carol", Player2: "dave", PreferredColor: ColorWhite})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+	carol := dialPlayer(t, server, "carol")
+	dave := dialPlayer(t, server, "dave")
+
+	alice.send("move", MoveMessage{GameID: "A", Move: "e2e4"})
+	alice.expect("moveAck")
+	carol.send("move", MoveMessage{GameID: "B", Move: "d2d4"})
+	carol.expect("moveAck")
+
+	for _, c := range []struct {
+		conn   *testConn
+		gameID string
+		move   string
+	}{
+		{bob, "A", "e2e4"},
+		{dave, "B", "d2d4"},
+	} {
+		var answer MoveAnswer
+		c.conn.expect("move", &answer)
+		if answer.GameID != c.gameID || answer.Move != c.move {
+			t.Fatalf("got move %s in %s, want %s in %s", answer.Move, answer.GameID, c.move, c.gameID)
+		}
+	}
+
+	type viewer struct {
+		name   string
+		conn   *testConn
+		gameID string
+	}
+	senders := []viewer{{"alice", alice, "A"}, {"carol", carol, "B"}}
+	receivers := []viewer{{"bob", bob, "A"}, {"dave", dave, "B"}}
+
+	// the senders broadcast their moves before handling their next
+	// message, so once they answered getPgn every stray broadcast has
+	// arrived before the receivers' pgn answers
+	for _, round := range [][]viewer{senders, append(senders, receivers...)} {
+		for _, v := range round {
+			v.conn.send("getPgn", GetPgnMessage{GameID: v.gameID})
+			for _, msg := range v.conn.readUntil("pgn") {
+				if msg.Type != "move" && msg.Type != "clock" && msg.Type != "capturedPieces" {
+					continue
+				}
+
+				var payload struct {
+					GameID string `json:"gameId"`
+				}
+				err := json.Unmarshal([]byte(msg.Payload), &payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if payload.GameID != v.gameID {
+					t.Errorf("%s in %s got %s for %s", v.name, v.gameID, msg.Type, payload.GameID)
+				}
+			}
+		}
+	}
+}
+
+func TestConcurrentIdenticalMovesApplyOnce(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	// a double-submitting client sends the same move twice at once
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = ApplyMove(game, &MoveMessage{GameID: "game-1", Move: "e2e4"}, &Client{ID: "alice"})
+		}()
+	}
+	wg.Wait()
+
+	applied := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			applied++
+		case !errors.Is(err, ErrNotYourTurn):
+			t.Errorf("stale move rejected with %v, want %v", err, ErrNotYourTurn)
+		}
+	}
+	if applied != 1 {
+		t.Fatalf("%d moves applied, want 1", applied)
+	}
+
+	game.mu.RLock()
+	plies := len(game.Game.Moves())
+	game.mu.RUnlock()
+	if plies != 1 {
+		t.Errorf("game has %d plies, want 1", plies)
+	}
+}
+
+func TestReadersRunConcurrentlyWithMoves(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		players := []string{"alice", "bob"}
+		for i, move := range []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "d2d3", "f8c5"} {
+			_, err := ApplyMove(game, &MoveMessage{GameID: "game-1", Move: move}, &Client{ID: players[i%2]})
+			if err != nil {
+				t.Errorf("move %s: %v", move, err)
+				return
+			}
+
+			// leave the readers time to run between the moves
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	paths := []string{
+		"/game/game-1",
+		"/game/game-1/heatmap",
+		"/game/game-1/board64",
+		"/game/game-1/placement",
+		"/game/game-1/turn",
+		"/game/game-1/check",
+		"/game/game-1/pins",
+		"/game/game-1/phase",
+		"/game/game-1/captured",
+		"/game/game-1/hash",
+		"/game/game-1/png",
+		"/game/game-1/perft/divide",
+		"/game/game-1/evalgraph",
+		"/game/game-1/tree",
+		"/games",
+		"/players/alice/stats",
+		"/players/alice/to-move",
+	}
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		for _, path := range paths {
+			recorder := doRequest(t, "GET", path, nil)
+			if recorder.Code != 200 {
+				t.Fatalf("GET %s: status %d", path, recorder.Code)
+			}
+		}
+
+		recorder := doRequest(t, "POST", "/game/game-1/simulate", SimulateRequest{})
+		if recorder.Code != 200 {
+			t.Fatalf("simulate: status %d", recorder.Code)
+		}
+	}
+}
+
+func TestRejectedMovesSendErrors(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	for _, c := range []struct {
+		conn   *testConn
+		gameID string
+		move   string
+		code   string
+	}{
+		{alice, "game-1", "e2e5", ErrorCodeIllegalMove},
+		{bob, "game-1", "e7e5", ErrorCodeNotYourTurn},
+		{alice, "missing", "e2e4", ErrorCodeGameNotFound},
+	} {
+		c.conn.send("move", MoveMessage{GameID: c.gameID, Move: c.move})
+
+		var errMsg ErrorMessage
+		c.conn.expect("error", &errMsg)
+		if errMsg.Code != c.code || errMsg.GameID != c.gameID || errMsg.Message == "" {
+			t.Errorf("move %s in %s: error %+v, want code %s", c.move, c.gameID, errMsg, c.code)
+		}
+	}
+
+	game, _ := GetGame("game-1")
+	game.mu.RLock()
+	plies := len(game.Game.Moves())
+	game.mu.RUnlock()
+	if plies != 0 {
+		t.Errorf("%d moves applied, want none", plies)
+	}
+}
+
+func TestCreateGameReportsFirstMover(t *testing.T) {
+	resetState(t)
+
+	for _, c := range []struct {
+		fen       string
+		mover     string
+		moverId   string
+		firstMove string
+	}{
+		{"", ColorWhite, "alice", "e2e4"},
+		{"4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", ColorBlack, "bob", "e8d8"},
+	} {
+		recorder := doRequest(t, "POST", "/game", CreateGameRequest{
+			Player1:        "alice",
+			Player2:        "bob",
+			PreferredColor: ColorWhite,
+			StartingFen:    c.fen,
+		})
+		if recorder.Code != 200 {
+			t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+		}
+
+		var response CreateGameResponse
+		decodeJSON(t, recorder, &response)
+		if response.FirstMover != c.mover || response.FirstMoverId != c.moverId {
+			t.Errorf("fen %q: first mover %s (%s), want %s (%s)", c.fen, response.FirstMover, response.FirstMoverId, c.mover, c.moverId)
+		}
+
+		// the reported player is the one allowed to make the first move
+		game, _ := GetGame(response.ID)
+		_, err := ApplyMove(game, &MoveMessage{GameID: response.ID, Move: c.firstMove}, &Client{ID: response.FirstMoverId})
+		if err != nil {
+			t.Errorf("fen %q: first move by %s: %v", c.fen, response.FirstMoverId, err)
+		}
+	}
+}
+
+func TestPlacementIsFirstFenField(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4", "c7c5", "g1f3")
+
+	recorder := doRequest(t, "GET", "/game/game-1/placement", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Placement string `json:"placement"`
+		Turn      string `json:"turn"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	fen := game.Game.Position().String()
+	placement, _, _ := strings.Cut(fen, " ")
+	if response.Placement != placement {
+		t.Errorf("placement %q, want %q from %q", response.Placement, placement, fen)
+	}
+	if response.Placement != "rnbqkbnr/pp1ppppp/8/2p5/4P3/5N2/PPPP1PPP/RNBQKB1R" {
+		t.Errorf("placement %q after 1. e4 c5 2. Nf3", response.Placement)
+	}
+	if response.Turn != ColorBlack {
+		t.Errorf("turn %q, want %s", response.Turn, ColorBlack)
+	}
+}
+
+func TestFenReturnsTheLivePosition(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4", "c7c5", "g1f3", "d7d6")
+
+	recorder := doRequest(t, "GET", "/game/game-1/fen", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		Fen            string `json:"fen"`
+		Turn           string `json:"turn"`
+		FullmoveNumber int    `json:"fullmoveNumber"`
+	}
+	decodeJSON(t, recorder, &response)
+
+	if response.Fen != "rnbqkbnr/pp2pppp/3p4/2p5/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq - 0 3" {
+		t.Errorf("fen %q after 1. e4 c5 2. Nf3 d6", response.Fen)
+	}
+	if response.Turn != ColorWhite {
+		t.Errorf("turn %q, want %s", response.Turn, ColorWhite)
+	}
+	if response.FullmoveNumber != 3 {
+		t.Errorf("fullmove number %d, want 3", response.FullmoveNumber)
+	}
+
+	recorder = doRequest(t, "GET", "/game/missing/fen", nil)
+	if recorder.Code != 404 {
+		t.Errorf("unknown game: status %d, want 404", recorder.Code)
+	}
+}
+
+func TestCreateGameFromCustomFen(t *testing.T) {
+	resetState(t)
+
+	fen := "8/8/8/4k3/8/8/4P3/4K3 w - - 0 1"
+	recorder := doRequest(t, "POST", "/game", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorBlack,
+		StartingFen:    fen,
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response CreateGameResponse
+	decodeJSON(t, recorder, &response)
+
+	game, ok := GetGame(response.ID)
+	if !ok {
+		t.Fatal("game not registered")
+	}
+	if got := game.Game.Position().String(); got != fen {
+		t.Errorf("game starts at %s, want %s", got, fen)
+	}
+	if game.WhitePlayerId != "bob" || game.BlackPlayerId != "alice" {
+		t.Errorf("white %s, black %s, want bob and alice", game.WhitePlayerId, game.BlackPlayerId)
+	}
+
+	// the custom position survives the store
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreGame(response.ID, storedGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Game.Position().String(); got != fen {
+		t.Errorf("restored game is at %s, want %s", got, fen)
+	}
+}
+
+func TestCreateGameRejectsMalformedFen(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "POST", "/game", CreateGameRequest{
+		Player1:     "alice",
+		Player2:     "bob",
+		StartingFen: "not a fen",
+	})
+	if recorder.Code != 400 {
+		t.Fatalf("status %d, want 400", recorder.Code)
+	}
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	decodeJSON(t, recorder, &response)
+	if !strings.HasPrefix(response.Message, "Invalid starting FEN") {
+		t.Errorf("message %q, want it to name the invalid FEN", response.Message)
+	}
+
+	gamesMu.RLock()
+	count := len(games)
+	gamesMu.RUnlock()
+	if count != 0 {
+		t.Errorf("%d games created", count)
+	}
+}
+
+// moveAnswer returns the move broadcast for the last move of the game.
+func moveAnswer(t *testing.T, id string, game *Game) MoveAnswer {
+	t.Helper()
+
+	data, err := GenerateMoveAnswerMessage(game, MoveMessage{GameID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var answer MoveAnswer
+	decodeWebsocketPayload(t, data, &answer)
+
+	return answer
+}
+
+func TestMoveAnswerFlagsCheckAndCheckmate(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	playMoves(t, "game-1", game, "e2e4")
+	answer := moveAnswer(t, "game-1", game)
+	if answer.InCheck || answer.IsCheckmate || answer.IsStalemate {
+		t.Errorf("quiet move flagged: %+v", answer)
+	}
+
+	playMoves(t, "game-1", game, "f7f6", "d1h5")
+	answer = moveAnswer(t, "game-1", game)
+	if !answer.InCheck || answer.IsCheckmate {
+		t.Errorf("Qh5+ flagged check %v, checkmate %v", answer.InCheck, answer.IsCheckmate)
+	}
+	if answer.LastMoveUci != "d1h5" {
+		t.Errorf("last move %q, want d1h5", answer.LastMoveUci)
+	}
+
+	mated := newTestGame(t, "game-2", CreateGameRequest{})
+	playMoves(t, "game-2", mated, "f2f3", "e7e5", "g2g4", "d8h4")
+	answer = moveAnswer(t, "game-2", mated)
+	if !answer.InCheck || !answer.IsCheckmate || answer.IsStalemate {
+		t.Errorf("Qh4# flagged check %v, checkmate %v, stalemate %v", answer.InCheck, answer.IsCheckmate, answer.IsStalemate)
+	}
+}
+
+func TestMoveAnswerCarriesTheLastMoveSquares(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4")
+
+	answer := moveAnswer(t, "game-1", game)
+	if answer.LastMove == nil || *answer.LastMove != (LastMove{From: "e2", To: "e4"}) {
+		t.Errorf("last move %+v, want e2-e4", answer.LastMove)
+	}
+
+	playMoves(t, "game-1", game, "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "e1g1")
+
+	answer = moveAnswer(t, "game-1", game)
+	if answer.LastMove == nil || *answer.LastMove != (LastMove{From: "e1", To: "g1"}) {
+		t.Errorf("last move %+v, want the king's e1-g1", answer.LastMove)
+	}
+
+	promoting := newTestGame(t, "game-2", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		StartingFen:    "8/P7/8/8/8/8/8/k6K w - - 0 1",
+	})
+	playMoves(t, "game-2", promoting, "a7a8q")
+
+	answer = moveAnswer(t, "game-2", promoting)
+	if answer.LastMove == nil || *answer.LastMove != (LastMove{From: "a7", To: "a8", Promotion: "q"}) {
+		t.Errorf("last move %+v, want a7-a8 promoting to a queen", answer.LastMove)
+	}
+}
+
+func TestJoinCarriesTheLastMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+
+	var against AgainstMessage
+	dialWS(t, server, "id=alice").expect("against", &against)
+	if against.LastMove != nil {
+		t.Errorf("last move %+v in a new game, want none", against.LastMove)
+	}
+
+	playMoves(t, "game-1", game, "e2e4")
+
+	dialWS(t, server, "id=bob").expect("against", &against)
+	if against.LastMove == nil || *against.LastMove != (LastMove{From: "e2", To: "e4"}) {
+		t.Errorf("last move %+v on joining, want e2-e4", against.LastMove)
+	}
+}
+
+func TestClosingTheConnectionEndsTheHandler(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+
+	// one client says goodbye, the other just drops the connection
+	clean := dialWS(t, server, "id=alice")
+	dropped := dialWS(t, server, "id=bob")
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := clean.conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped.conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		wsHandlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handlers still running after the connections closed")
+	}
+
+	if isConnected("alice") || isConnected("bob") {
+		t.Error("closed clients are still connected")
+	}
+}
+
+func TestOutcomeReasonForAutomaticDraws(t *testing.T) {
+	tests := []struct {
+		name   string
+		fen    string
+		move   string
+		reason string
+	}{
+		{"stalemate", "k7/8/8/2Q5/8/8/8/7K w - - 0 1", "c5b6", "stalemate"},
+		{"king vs king", "k7/8/8/8/8/8/1r6/K7 w - - 0 1", "a1b2", "insufficient material"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+
+			game := newTestGame(t, "draw", CreateGameRequest{
+				Player1:        "alice",
+				Player2:        "bob",
+				PreferredColor: ColorWhite,
+				StartingFen:    test.fen,
+			})
+			playMoves(t, "draw", game, test.move)
+
+			data, err := GenerateOutcomeMessage("draw", game, DefaultLocale)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var outcome OutcomeMessage
+			decodeWebsocketPayload(t, data, &outcome)
+
+			if outcome.Outcome != chess.Draw.String() || outcome.Winner != "" {
+				t.Errorf("outcome %q won by %q, want a draw", outcome.Outcome, outcome.Winner)
+			}
+			if outcome.Reason != test.reason {
+				t.Errorf("reason %q, want %q", outcome.Reason, test.reason)
+			}
+		})
+	}
+}
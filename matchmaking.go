@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+type MatchmakeRequest struct {
+	PlayerID string `json:"playerId"`
+}
+
+// MatchmakeResponse is returned by POST /matchmake. Status is "waiting"
+// while the player is queued and "matched" once it has a game.
+type MatchmakeResponse struct {
+	Status string `json:"status"`
+	GameID string `json:"gameId,omitempty"`
+}
+
+const (
+	MatchStatusWaiting = "waiting"
+	MatchStatusMatched = "matched"
+)
+
+type MatchedMessage struct {
+	GameID        string `json:"gameId"`
+	WhitePlayerId string `json:"whitePlayerId"`
+	BlackPlayerId string `json:"blackPlayerId"`
+}
+
+// matchQueue holds the waiting players in arrival order, matchedGames the
+// game each matched player got until that game is over. creatingMatches
+// holds the ids of matched games that are still being added.
+var matchQueue = make([]string, 0)
+var matchedGames = make(map[string]string)
+var creatingMatches = make(map[string]bool)
+var matchMu sync.Mutex
+
+// Matchmake queues the player or pairs it with the longest waiting one.
+// Asking again while queued or matched returns the current state instead
+// of queueing the player twice.
+func Matchmake(playerID string) (MatchmakeResponse, error) {
+	matchMu.Lock()
+
+	if gameID, ok := matchedGames[playerID]; ok {
+		// the game doesn't exist until AddGame returns
+		if creatingMatches[gameID] {
+			matchMu.Unlock()
+			return MatchmakeResponse{Status: MatchStatusWaiting}, nil
+		}
+
+		if game, ok := GetGame(gameID); ok {
+			game.mu.RLock()
+			running := game.Game.Outcome() == chess.NoOutcome
+			game.mu.RUnlock()
+
+			if running {
+				matchMu.Unlock()
+				return MatchmakeResponse{Status: MatchStatusMatched, GameID: gameID}, nil
+			}
+		}
+
+		delete(matchedGames, playerID)
+	}
+
+	if slices.Contains(matchQueue, playerID) {
+		matchMu.Unlock()
+		return MatchmakeResponse{Status: MatchStatusWaiting}, nil
+	}
+
+	if len(matchQueue) == 0 {
+		matchQueue = append(matchQueue, playerID)
+		matchMu.Unlock()
+		return MatchmakeResponse{Status: MatchStatusWaiting}, nil
+	}
+
+	opponent := matchQueue[0]
+
+	// colors are picked at random since neither player asked for one
+	game, err := NewGameFromRequest(CreateGameRequest{
+		Player1: opponent,
+		Player2: playerID,
+	})
+	if err != nil {
+		matchMu.Unlock()
+		return MatchmakeResponse{}, err
+	}
+
+	gameID := uuid.New().String()
+	matchQueue = matchQueue[1:]
+	matchedGames[opponent] = gameID
+	matchedGames[playerID] = gameID
+	creatingMatches[gameID] = true
+	matchMu.Unlock()
+
+	// AddGame writes the game to disk, matchMu is not held for that
+	err = AddGame(gameID, game)
+
+	matchMu.Lock()
+	delete(creatingMatches, gameID)
+	if err != nil {
+		// the opponent keeps its place at the front of the queue
+		delete(matchedGames, opponent)
+		delete(matchedGames, playerID)
+		matchQueue = append([]string{opponent}, matchQueue...)
+	}
+	matchMu.Unlock()
+
+	if err != nil {
+		return MatchmakeResponse{}, err
+	}
+
+	err = NotifyMatched(gameID, game)
+	if err != nil {
+		slog.Warn("notifying matched players failed", "game_id", gameID, "error", err)
+	}
+
+	return MatchmakeResponse{Status: MatchStatusMatched, GameID: gameID}, nil
+}
+
+// LeaveMatchmaking removes the player from the queue. It reports whether
+// the player was queued.
+func LeaveMatchmaking(playerID string) bool {
+	matchMu.Lock()
+	defer matchMu.Unlock()
+
+	i := slices.Index(matchQueue, playerID)
+	if i < 0 {
+		return false
+	}
+
+	matchQueue = slices.Delete(matchQueue, i, i+1)
+
+	return true
+}
+
+// NotifyMatched sends a "matched" message to every connection of the two
+// players, wherever they are.
+func NotifyMatched(gameID string, game *Game) error {
+	data, err := json.Marshal(MatchedMessage{
+		GameID:        gameID,
+		WhitePlayerId: game.WhitePlayerId,
+		BlackPlayerId: game.BlackPlayerId,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "matched",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	var errs []error
+	for _, client := range connectedClients {
+		if !IsPlayer(game, client.ID) {
+			continue
+		}
+
+		err := client.Send(data)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
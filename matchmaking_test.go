@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+func matchmake(t *testing.T, playerID string) MatchmakeResponse {
+	t.Helper()
+
+	recorder := doRequest(t, "POST", "/matchmake", MatchmakeRequest{PlayerID: playerID})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response MatchmakeResponse
+	decodeJSON(t, recorder, &response)
+
+	return response
+}
+
+func TestMatchmakePairsTwoPlayers(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+	alice := dialWS(t, server, "id=alice")
+
+	if response := matchmake(t, "alice"); response.Status != MatchStatusWaiting {
+		t.Fatalf("first player %+v, want waiting", response)
+	}
+
+	// asking again doesn't queue alice twice
+	if response := matchmake(t, "alice"); response.Status != MatchStatusWaiting {
+		t.Fatalf("queued player asking again %+v, want waiting", response)
+	}
+
+	response := matchmake(t, "bob")
+	if response.Status != MatchStatusMatched || response.GameID == "" {
+		t.Fatalf("second player %+v, want matched", response)
+	}
+
+	var matched MatchedMessage
+	alice.expect("matched", &matched)
+	if matched.GameID != response.GameID {
+		t.Errorf("alice matched into %q, bob into %q", matched.GameID, response.GameID)
+	}
+
+	game, ok := GetGame(response.GameID)
+	if !ok {
+		t.Fatal("matched game not found")
+	}
+	players := map[string]bool{game.WhitePlayerId: true, game.BlackPlayerId: true}
+	if !players["alice"] || !players["bob"] {
+		t.Errorf("game between %q and %q, want alice and bob", game.WhitePlayerId, game.BlackPlayerId)
+	}
+
+	gamesMu.RLock()
+	count := len(games)
+	gamesMu.RUnlock()
+	if count != 1 {
+		t.Errorf("%d games, want 1", count)
+	}
+
+	// a matched player asking again gets the same game
+	if again := matchmake(t, "alice"); again.Status != MatchStatusMatched || again.GameID != response.GameID {
+		t.Errorf("matched player asking again %+v, want %q", again, response.GameID)
+	}
+}
+
+func TestMatchmakeRejectsTheBotID(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "POST", "/matchmake", MatchmakeRequest{PlayerID: " " + BotPlayerID + " "})
+	if recorder.Code != 400 {
+		t.Fatalf("status %d, want 400", recorder.Code)
+	}
+
+	matchMu.Lock()
+	queued := len(matchQueue)
+	matchMu.Unlock()
+	if queued != 0 {
+		t.Errorf("%d players queued, want none", queued)
+	}
+}
+
+func TestMatchStillBeingAddedIsWaiting(t *testing.T) {
+	resetState(t)
+
+	matchMu.Lock()
+	matchedGames["alice"] = "game-1"
+	creatingMatches["game-1"] = true
+	matchMu.Unlock()
+
+	// the game id is only handed out once the game exists
+	if response := matchmake(t, "alice"); response.Status != MatchStatusWaiting || response.GameID != "" {
+		t.Errorf("player of a match being added %+v, want waiting", response)
+	}
+
+	matchMu.Lock()
+	gameID := matchedGames["alice"]
+	matchMu.Unlock()
+	if gameID != "game-1" {
+		t.Errorf("match dropped while being added, got %q", gameID)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import "github.com/notnil/chess"
+
+// DefaultNotation is the move notation of games that don't choose one.
+// It matches the plain "e2e4" strings clients always sent.
+const DefaultNotation = "uci"
+
+var notations = map[string]chess.Notation{
+	"uci": chess.UCINotation{},
+	"san": chess.AlgebraicNotation{},
+	"lan": chess.LongAlgebraicNotation{},
+}
+
+func IsValidNotation(name string) bool {
+	_, ok := notations[name]
+	return ok
+}
+
+func NotationFor(name string) chess.Notation {
+	notation, ok := notations[name]
+	if !ok {
+		return notations[DefaultNotation]
+	}
+
+	return notation
+}
+
+// FormatMove encodes m, played from pos, in the game's notation.
+func FormatMove(game *Game, pos *chess.Position, m *chess.Move) string {
+	return NotationFor(game.Notation).Encode(pos, m)
+}
+
+type HistoryMove struct {
+	Number int    `json:"number"`
+	Color  string `json:"color"`
+	UCI    string `json:"uci"`
+	SAN    string `json:"san"`
+	Fen    string `json:"fen"`
+}
+
+// MoveHistory lists the moves of the game in UCI and SAN, each with the
+// FEN of the position after it.
+func MoveHistory(game *Game) []HistoryMove {
+	positions := game.Game.Positions()
+	moves := game.Game.Moves()
+	history := make([]HistoryMove, 0, len(moves))
+
+	for i, m := range moves {
+		pos := positions[i]
+
+		history = append(history, HistoryMove{
+			Number: MoveNumber(pos),
+			Color:  ColorCode(pos.Turn()),
+			UCI:    chess.UCINotation{}.Encode(pos, m),
+			SAN:    chess.AlgebraicNotation{}.Encode(pos, m),
+			Fen:    positions[i+1].String(),
+		})
+	}
+
+	return history
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMovesUseTheGameNotation(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		Notation:       "san",
+	})
+
+	playMoves(t, "game-1", game, "e4", "e5", "Nf3")
+
+	_, err := ApplyMove(game, &MoveMessage{GameID: "game-1", Move: "b8c6"}, &Client{ID: "bob"})
+	if err == nil {
+		t.Error("UCI move accepted in a SAN game")
+	}
+
+	data, err := GenerateStateMessage("game-1", game)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state StateMessage
+	decodeWebsocketPayload(t, data, &state)
+
+	if state.Notation != "san" {
+		t.Errorf("notation %q, want san", state.Notation)
+	}
+	if want := []string{"e4", "e5", "Nf3"}; !reflect.DeepEqual(state.Moves, want) {
+		t.Errorf("moves %v, want %v", state.Moves, want)
+	}
+}
+
+func TestDefaultNotationIsUCI(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4", "e7e5")
+
+	data, err := GenerateStateMessage("game-1", game)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var state StateMessage
+	decodeWebsocketPayload(t, data, &state)
+
+	if state.Notation != DefaultNotation {
+		t.Errorf("notation %q, want %q", state.Notation, DefaultNotation)
+	}
+	if want := []string{"e2e4", "e7e5"}; !reflect.DeepEqual(state.Moves, want) {
+		t.Errorf("moves %v, want %v", state.Moves, want)
+	}
+}
+
+func TestCreateGameRejectsUnknownNotation(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "POST", "/game", CreateGameRequest{
+		Player1:  "alice",
+		Player2:  "bob",
+		Notation: "descriptive",
+	})
+	if recorder.Code != 400 {
+		t.Errorf("status %d, want 400", recorder.Code)
+	}
+}
+
+func TestMoveHistoryListsUCIAndSAN(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "e1g1")
+
+	recorder := doRequest(t, "GET", "/game/game-1/moves", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var history []HistoryMove
+	decodeJSON(t, recorder, &history)
+
+	var san []string
+	for _, move := range history {
+		san = append(san, move.SAN)
+	}
+	if want := []string{"e4", "e5", "Nf3", "Nc6", "Bc4", "Nf6", "O-O"}; !reflect.DeepEqual(san, want) {
+		t.Errorf("SAN %v, want %v", san, want)
+	}
+
+	castle := history[len(history)-1]
+	if castle.UCI != "e1g1" || castle.Number != 4 || castle.Color != ColorWhite {
+		t.Errorf("castling listed as %+v, want white's e1g1 on move 4", castle)
+	}
+	if castle.Fen != "r1bqkb1r/pppp1ppp/2n2n2/4p3/2B1P3/5N2/PPPP1PPP/RNBQ1RK1 b kq - 5 4" {
+		t.Errorf("fen after castling %q", castle.Fen)
+	}
+
+	if second := history[1]; second.Number != 1 || second.Color != ColorBlack {
+		t.Errorf("black's reply listed as move %d by %s", second.Number, second.Color)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestObserverMutatingMessagesAreRejected(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+
+	// the observer uses the id of the white player and still can't move
+	observer := dialWS(t, server, "id=alice&role=observer")
+
+	for msgType := range mutatingMessageTypes {
+		observer.send(msgType, MoveMessage{GameID: "game-1", Move: "e2e4"})
+
+		var errorMsg ErrorMessage
+		observer.expect("error", &errorMsg)
+		if errorMsg.Code != ErrorCodeReadOnly {
+			t.Errorf("%s: error code %q, want %q", msgType, errorMsg.Code, ErrorCodeReadOnly)
+		}
+	}
+
+	game.mu.Lock()
+	moves := len(game.Game.Moves())
+	game.mu.Unlock()
+	if moves != 0 {
+		t.Errorf("game has %d moves, want 0", moves)
+	}
+}
+
+func TestObserverReceivesBroadcasts(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	observer := dialWS(t, server, "id=overlay&role=observer")
+	observer.send("join", JoinMessage{GameID: "game-1"})
+	observer.expect("players")
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck")
+
+	var move MoveMessage
+	observer.expect("move", &move)
+	if move.Move != "e2e4" {
+		t.Errorf("observer got move %q, want e2e4", move.Move)
+	}
+}
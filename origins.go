@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// AllowedOrigins lists the origins browsers may open websockets from,
+// like "https://app.example.com". Entries may use * as a wildcard, e.g.
+// "http://localhost:*" for local development, and a lone "*" allows every
+// origin. Without entries only pages from the server's own host may
+// connect.
+var AllowedOrigins []string
+
+// ParseAllowedOrigins splits the comma separated ALLOWED_ORIGINS value.
+func ParseAllowedOrigins(value string) []string {
+	origins := make([]string, 0)
+
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins = append(origins, strings.ToLower(origin))
+		}
+	}
+
+	return origins
+}
+
+// CheckOrigin guards the websocket upgrade against cross-site websocket
+// hijacking. Requests without an Origin header don't come from a browser
+// and are let through.
+func CheckOrigin(r *http.Request) bool {
+	origin := strings.ToLower(r.Header.Get("Origin"))
+	if origin == "" {
+		return true
+	}
+
+	if len(AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+
+		if ok, _ := path.Match(allowed, origin); ok {
+			return true
+		}
+	}
+
+	return false
+}
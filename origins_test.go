@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocketOriginAllowlist(t *testing.T) {
+	resetState(t)
+
+	previous := AllowedOrigins
+	AllowedOrigins = ParseAllowedOrigins("https://app.example.com, http://localhost:*")
+	t.Cleanup(func() { AllowedOrigins = previous })
+
+	server := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?id=alice"
+
+	tests := []struct {
+		origin string
+		status int
+	}{
+		{"https://app.example.com", http.StatusSwitchingProtocols},
+		{"http://localhost:5173", http.StatusSwitchingProtocols},
+		{"https://evil.example.com", http.StatusForbidden},
+		{"http://app.example.com", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {test.origin}})
+		if conn != nil {
+			conn.Close()
+		}
+
+		if resp == nil {
+			t.Errorf("%s: no response: %v", test.origin, err)
+			continue
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.origin, resp.StatusCode, test.status)
+		}
+	}
+}
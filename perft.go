@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/notnil/chess"
+)
+
+const maxPerftDepth = 4
+
+type PerftDivideEntry struct {
+	Move  string `json:"move"`
+	Nodes int    `json:"nodes"`
+}
+
+type PerftDivideResult struct {
+	Depth int                `json:"depth"`
+	Moves []PerftDivideEntry `json:"moves"`
+	Total int                `json:"total"`
+}
+
+// Perft counts the leaf nodes of the legal move tree of the given depth.
+func Perft(pos *chess.Position, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+
+	moves := pos.ValidMoves()
+	if depth == 1 {
+		return len(moves)
+	}
+
+	nodes := 0
+	for _, m := range moves {
+		nodes += Perft(pos.Update(m), depth-1)
+	}
+
+	return nodes
+}
+
+// PerftDivide splits the perft count by root move, in UCI and sorted by
+// move like the divide output of common engines.
+func PerftDivide(pos *chess.Position, depth int) PerftDivideResult {
+	result := PerftDivideResult{
+		Depth: depth,
+		Moves: make([]PerftDivideEntry, 0),
+	}
+
+	for _, m := range pos.ValidMoves() {
+		nodes := Perft(pos.Update(m), depth-1)
+
+		result.Moves = append(result.Moves, PerftDivideEntry{
+			Move:  chess.UCINotation{}.Encode(pos, m),
+			Nodes: nodes,
+		})
+		result.Total += nodes
+	}
+
+	sort.Slice(result.Moves, func(i, j int) bool {
+		return result.Moves[i].Move < result.Moves[j].Move
+	})
+
+	return result
+}
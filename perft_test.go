@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestPerftDivideStartPosition(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	recorder := doRequest(t, "GET", "/game/game-1/perft/divide?depth=2", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var result PerftDivideResult
+	decodeJSON(t, recorder, &result)
+
+	// every one of the 20 first moves allows 20 replies
+	if len(result.Moves) != 20 {
+		t.Fatalf("%d root moves, want 20", len(result.Moves))
+	}
+	for _, entry := range result.Moves {
+		if entry.Nodes != 20 {
+			t.Errorf("%s has %d nodes, want 20", entry.Move, entry.Nodes)
+		}
+	}
+	if result.Total != 400 || result.Depth != 2 {
+		t.Errorf("total %d at depth %d, want 400 at depth 2", result.Total, result.Depth)
+	}
+}
+
+func TestPerftDivideAfterMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4")
+
+	recorder := doRequest(t, "GET", "/game/game-1/perft/divide?depth=3", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var result PerftDivideResult
+	decodeJSON(t, recorder, &result)
+
+	// perft(4) of the start position split by the first move
+	if result.Total != 13160 {
+		t.Errorf("total %d, want 13160", result.Total)
+	}
+}
+
+func TestPerftDivideDepthIsBounded(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	for _, depth := range []string{"0", "5", "x"} {
+		recorder := doRequest(t, "GET", "/game/game-1/perft/divide?depth="+depth, nil)
+		if recorder.Code != 400 {
+			t.Errorf("depth %s: status %d, want 400", depth, recorder.Code)
+		}
+	}
+}
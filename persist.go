@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PersistInterval is the minimum time between two flushes of the
+// persistence worker. Changes within it are written together, so a game
+// that gets several moves in quick succession is only written once.
+var PersistInterval = 200 * time.Millisecond
+
+var dirtyGames = make(map[string]bool)
+var dirtyMu sync.Mutex
+
+// persistSignal wakes the worker. It is buffered so MarkDirty never
+// blocks, a pending signal already covers every later change.
+var persistSignal = make(chan struct{}, 1)
+
+// MarkDirty queues a game for the persistence worker instead of writing it
+// right away.
+func MarkDirty(id string) {
+	dirtyMu.Lock()
+	dirtyGames[id] = true
+	dirtyMu.Unlock()
+
+	select {
+	case persistSignal <- struct{}{}:
+	default:
+	}
+}
+
+// FlushDirtyGames writes every queued game. It returns the first error
+// but still tries all games.
+func FlushDirtyGames() error {
+	dirtyMu.Lock()
+	ids := dirtyGames
+	dirtyGames = make(map[string]bool)
+	dirtyMu.Unlock()
+
+	var firstErr error
+	for id := range ids {
+		err := SaveGame(id)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// RunPersistWorker flushes dirty games at most every PersistInterval until
+// ctx is done, then flushes one last time so no change is lost.
+func RunPersistWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			flushDirtyGamesLogged()
+			return
+		case <-persistSignal:
+		}
+
+		flushDirtyGamesLogged()
+
+		select {
+		case <-ctx.Done():
+			flushDirtyGamesLogged()
+			return
+		case <-time.After(PersistInterval):
+		}
+	}
+}
+
+func flushDirtyGamesLogged() {
+	err := FlushDirtyGames()
+	if err != nil {
+		slog.Error("flushing dirty games failed", "error", err)
+	}
+}
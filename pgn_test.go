@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestGetPgnParsesBackToPosition(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4", "e7e5", "g1f3", "b8c6", "f1b5")
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("getPgn", GetPgnMessage{GameID: "game-1"})
+
+	var answer PgnAnswer
+	alice.expect("pgn", &answer)
+
+	if answer.GameID != "game-1" {
+		t.Errorf("answer for %q, want game-1", answer.GameID)
+	}
+
+	pgn, err := chess.PGN(strings.NewReader(answer.Pgn))
+	if err != nil {
+		t.Fatalf("parsing %q: %v", answer.Pgn, err)
+	}
+
+	parsed := chess.NewGame(pgn)
+	if got, want := parsed.Position().String(), game.Game.Position().String(); got != want {
+		t.Errorf("PGN leads to %s, want %s", got, want)
+	}
+}
+
+func TestGetPgnUnknownGame(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+	conn := dialWS(t, server, "id=alice")
+
+	conn.send("getPgn", GetPgnMessage{GameID: "missing"})
+
+	var errorMsg ErrorMessage
+	conn.expect("error", &errorMsg)
+
+	if errorMsg.Code != ErrorCodeGameNotFound {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeGameNotFound)
+	}
+}
+
+func TestExportPGNEscapesTagValues(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{Player1: `al"ice`, Player2: `b\ob`, PreferredColor: ColorWhite})
+
+	pgn := ExportPGN(game)
+	for _, tag := range []string{`[White "al\"ice"]`, `[Black "b\\ob"]`} {
+		if !strings.Contains(pgn, tag) {
+			t.Errorf("%s missing in %q", tag, pgn)
+		}
+	}
+}
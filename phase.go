@@ -0,0 +1,46 @@
+package main
+
+import "github.com/notnil/chess"
+
+const (
+	PhaseOpening    = "opening"
+	PhaseMiddlegame = "middlegame"
+	PhaseEndgame    = "endgame"
+)
+
+// openingMoves is the last full move that still counts as opening.
+const openingMoves = 10
+
+// endgameMaterial is the total non-pawn material of both sides, in
+// centipawns, at or below which a position is an endgame. The starting
+// position has 6400, a rook and a minor piece each is about 1650.
+const endgameMaterial = 2600
+
+// NonPawnMaterial sums the value of all knights, bishops, rooks and queens
+// on the board.
+func NonPawnMaterial(board *chess.Board) int {
+	material := 0
+
+	for _, piece := range board.SquareMap() {
+		if piece.Type() != chess.Pawn {
+			material += pieceValues[piece.Type()]
+		}
+	}
+
+	return material
+}
+
+// GamePhase classifies a position. Low material makes an endgame
+// regardless of the move number, otherwise the first openingMoves moves
+// are the opening and everything after is the middlegame.
+func GamePhase(pos *chess.Position) string {
+	if NonPawnMaterial(pos.Board()) <= endgameMaterial {
+		return PhaseEndgame
+	}
+
+	if MoveNumber(pos) <= openingMoves {
+		return PhaseOpening
+	}
+
+	return PhaseMiddlegame
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPhaseEndpoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		phase string
+	}{
+		{"starting position", StartingFEN, PhaseOpening},
+		{"middlegame by move number", "r1bq1rk1/pppp1ppp/2n2n2/2b1p3/2B1P3/2NP1N2/PPP2PPP/R1BQ1RK1 w - - 0 15", PhaseMiddlegame},
+		{"rook endgame", "8/5pk1/6p1/8/8/6P1/r4PK1/R7 w - - 0 40", PhaseEndgame},
+		// little material is an endgame even early on
+		{"early endgame", "4k3/pppp4/8/8/8/8/PPPP4/4K2R w - - 0 5", PhaseEndgame},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetState(t)
+
+			newTestGame(t, "phase", CreateGameRequest{StartingFen: test.fen})
+
+			recorder := doRequest(t, "GET", "/game/phase/phase", nil)
+			if recorder.Code != 200 {
+				t.Fatalf("status %d", recorder.Code)
+			}
+
+			var response struct {
+				Phase string `json:"phase"`
+			}
+			decodeJSON(t, recorder, &response)
+
+			if response.Phase != test.phase {
+				t.Errorf("phase %q, want %q", response.Phase, test.phase)
+			}
+		})
+	}
+}
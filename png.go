@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/notnil/chess"
+)
+
+const minPNGSize = 64
+const maxPNGSize = 1024
+const defaultPNGSize = 400
+
+var (
+	lightSquareColor = color.RGBA{240, 217, 181, 255}
+	darkSquareColor  = color.RGBA{181, 136, 99, 255}
+	whitePieceColor  = color.RGBA{250, 250, 250, 255}
+	blackPieceColor  = color.RGBA{30, 30, 30, 255}
+)
+
+// pieceGlyphs are 5x7 bitmaps of the piece letters drawn on top of each
+// piece disc, so rendering needs no fonts or image assets.
+var pieceGlyphs = map[chess.PieceType][7]string{
+	chess.King:   {"X...X", "X..X.", "X.X..", "XX...", "X.X..", "X..X.", "X...X"},
+	chess.Queen:  {".XXX.", "X...X", "X...X", "X...X", "X.X.X", "X..X.", ".XX.X"},
+	chess.Rook:   {"XXXX.", "X...X", "X...X", "XXXX.", "X.X..", "X..X.", "X...X"},
+	chess.Bishop: {"XXXX.", "X...X", "X...X", "XXXX.", "X...X", "X...X", "XXXX."},
+	chess.Knight: {"X...X", "XX..X", "X.X.X", "X..XX", "X...X", "X...X", "X...X"},
+	chess.Pawn:   {"XXXX.", "X...X", "X...X", "XXXX.", "X....", "X....", "X...."},
+}
+
+func fillRect(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			img.Set(x, y, c)
+		}
+	}
+}
+
+func drawPiece(img *image.RGBA, x0 int, y0 int, squareSize int, piece chess.Piece) {
+	fill := whitePieceColor
+	ink := blackPieceColor
+	if piece.Color() == chess.Black {
+		fill, ink = ink, fill
+	}
+
+	center := squareSize / 2
+	radius := squareSize * 2 / 5
+	outline := radius + max(1, squareSize/40)
+
+	for y := 0; y < squareSize; y++ {
+		for x := 0; x < squareSize; x++ {
+			dx := x - center
+			dy := y - center
+			dist := dx*dx + dy*dy
+
+			if dist <= radius*radius {
+				img.Set(x0+x, y0+y, fill)
+			} else if dist <= outline*outline {
+				img.Set(x0+x, y0+y, ink)
+			}
+		}
+	}
+
+	glyph := pieceGlyphs[piece.Type()]
+	cell := max(1, squareSize/14)
+	gx := x0 + center - cell*5/2
+	gy := y0 + center - cell*7/2
+
+	for row, line := range glyph {
+		for col, bit := range line {
+			if bit == 'X' {
+				fillRect(img, gx+col*cell, gy+row*cell, gx+(col+1)*cell, gy+(row+1)*cell, ink)
+			}
+		}
+	}
+}
+
+// RenderBoardPNG draws the board as a size x size PNG. With flipped set the
+// board is shown from black's side.
+func RenderBoardPNG(board *chess.Board, size int, flipped bool) ([]byte, error) {
+	squareSize := size / 8
+	img := image.NewRGBA(image.Rect(0, 0, squareSize*8, squareSize*8))
+
+	for rank := 0; rank < 8; rank++ {
+		for file := 0; file < 8; file++ {
+			col := file
+			row := 7 - rank
+			if flipped {
+				col = 7 - file
+				row = rank
+			}
+
+			x0 := col * squareSize
+			y0 := row * squareSize
+
+			squareColor := darkSquareColor
+			if (file+rank)%2 == 1 {
+				squareColor = lightSquareColor
+			}
+
+			fillRect(img, x0, y0, x0+squareSize, y0+squareSize, squareColor)
+
+			piece := board.Piece(chess.NewSquare(chess.File(file), chess.Rank(rank)))
+			if piece != chess.NoPiece {
+				drawPiece(img, x0, y0, squareSize, piece)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
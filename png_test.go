@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+)
+
+func TestBoardPNG(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "e2e4")
+
+	recorder := doRequest(t, "GET", "/game/game-1/png?size=240&orientation=b", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("content type %q, want image/png", contentType)
+	}
+
+	config, err := png.DecodeConfig(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding PNG: %v", err)
+	}
+	if config.Width != 240 || config.Height != 240 {
+		t.Errorf("image is %dx%d, want 240x240", config.Width, config.Height)
+	}
+}
+
+func TestBoardPNGErrors(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	if recorder := doRequest(t, "GET", "/game/missing/png", nil); recorder.Code != 404 {
+		t.Errorf("unknown game: status %d, want 404", recorder.Code)
+	}
+	if recorder := doRequest(t, "GET", "/game/game-1/png?size=1", nil); recorder.Code != 400 {
+		t.Errorf("tiny size: status %d, want 400", recorder.Code)
+	}
+	if recorder := doRequest(t, "GET", "/game/game-1/png?orientation=x", nil); recorder.Code != 400 {
+		t.Errorf("bad orientation: status %d, want 400", recorder.Code)
+	}
+}
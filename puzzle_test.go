@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestBlackToMoveFromStandardArray(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "puzzle", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		SideToMove:     ColorBlack,
+	})
+
+	if game.Game.Position().Turn() != chess.Black {
+		t.Fatalf("%s to move, want black", game.Game.Position().Turn())
+	}
+
+	_, err := ApplyMove(game, &MoveMessage{GameID: "puzzle", Move: "e2e4"}, &Client{ID: "alice"})
+	if !errors.Is(err, ErrNotYourTurn) {
+		t.Errorf("white moving first: %v, want %v", err, ErrNotYourTurn)
+	}
+
+	data, err := GeneratePossibleMovesBySquareMessage("puzzle", game, &Client{ID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer PossibleMovesBySquareAnswer
+	decodeWebsocketPayload(t, data, &answer)
+	if len(answer.Moves) != 0 {
+		t.Errorf("white gets possible moves %v while black is to move", answer.Moves)
+	}
+
+	data, err = GeneratePossibleMovesBySquareMessage("puzzle", game, &Client{ID: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeWebsocketPayload(t, data, &answer)
+	if len(answer.Moves["e7"]) != 2 {
+		t.Errorf("black gets possible moves %v, want e7 to move", answer.Moves)
+	}
+
+	playMoves(t, "puzzle", game, "e7e5", "e2e4")
+}
+
+func TestBlackToMoveFromCustomFEN(t *testing.T) {
+	resetState(t)
+
+	// black mates in one with Qd1
+	game := newTestGame(t, "puzzle", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		StartingFen:    "3q2k1/5ppp/8/8/8/8/5PPP/6K1 b - - 0 1",
+	})
+
+	_, err := ApplyMove(game, &MoveMessage{GameID: "puzzle", Move: "g1h1"}, &Client{ID: "alice"})
+	if !errors.Is(err, ErrNotYourTurn) {
+		t.Errorf("white moving first: %v, want %v", err, ErrNotYourTurn)
+	}
+
+	playMoves(t, "puzzle", game, "d8d1")
+
+	if game.Game.Outcome() != chess.BlackWon {
+		t.Errorf("outcome %s, want 0-1", game.Game.Outcome())
+	}
+}
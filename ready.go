@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type ReadyMessage struct {
+	GameID string `json:"gameId"`
+	Ready  bool   `json:"ready"`
+}
+
+type ReadyStateMessage struct {
+	GameID string `json:"gameId"`
+	White  bool   `json:"white"`
+	Black  bool   `json:"black"`
+}
+
+var ErrNotReady = errors.New("Players not ready")
+
+// WaitingForReady reports whether a game created with RequireReady still
+// waits for a player before the first move.
+func WaitingForReady(game *Game) bool {
+	if !game.RequireReady || len(game.Game.Moves()) > 0 {
+		return false
+	}
+
+	// the bot never sends "ready"
+	whiteReady := game.WhiteReady || game.WhitePlayerId == BotPlayerID
+	blackReady := game.BlackReady || game.BlackPlayerId == BotPlayerID
+
+	return !whiteReady || !blackReady
+}
+
+func GenerateReadyStateMessage(gameID string, game *Game) ([]byte, error) {
+	data, err := json.Marshal(ReadyStateMessage{
+		GameID: gameID,
+		White:  game.WhiteReady,
+		Black:  game.BlackReady,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := WebsocketMessage{
+		Type:    "ready",
+		Payload: string(data),
+	}
+
+	return json.Marshal(msg)
+}
+
+func HandleReady(
+	wsMsg WebsocketMessage,
+	client *Client,
+) error {
+	var ready ReadyMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &ready)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(ready.GameID)
+	if !ok {
+		return errors.New("Game not found")
+	}
+
+	game.mu.Lock()
+
+	if len(game.Game.Moves()) > 0 {
+		game.mu.Unlock()
+		return errors.New("Game already started")
+	}
+
+	if game.WhitePlayerId == client.ID {
+		game.WhiteReady = ready.Ready
+	} else if game.BlackPlayerId == client.ID {
+		game.BlackReady = ready.Ready
+	} else {
+		game.mu.Unlock()
+		return errors.New("Player not in game")
+	}
+
+	// the clock starts once both players are ready rather than at creation
+	if game.Clock != nil && !WaitingForReady(game) {
+		game.Clock.LastMoveAt = time.Now()
+	}
+
+	data, err := GenerateReadyStateMessage(ready.GameID, game)
+	game.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	BroadcastToPlayers(ready.GameID, game, data)
+
+	// with a bot playing white the game starts once the human is ready
+	PlayBotMove(ready.GameID, game)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMovesWaitForBothPlayersReady(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "bob",
+		PreferredColor: ColorWhite,
+		RequireReady:   true,
+	})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	expectNotReady := func() {
+		t.Helper()
+
+		alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+
+		var errorMsg ErrorMessage
+		alice.expect("error", &errorMsg)
+		if errorMsg.Code != ErrorCodeNotReady {
+			t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeNotReady)
+		}
+	}
+
+	expectNotReady()
+
+	alice.send("ready", ReadyMessage{GameID: "game-1", Ready: true})
+
+	var state ReadyStateMessage
+	bob.expect("ready", &state)
+	if !state.White || state.Black {
+		t.Errorf("ready state %+v, want only white ready", state)
+	}
+	alice.expect("ready")
+
+	expectNotReady()
+
+	bob.send("ready", ReadyMessage{GameID: "game-1", Ready: true})
+	alice.expect("ready", &state)
+	if !state.White || !state.Black {
+		t.Errorf("ready state %+v, want both ready", state)
+	}
+
+	alice.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	alice.expect("moveAck")
+	bob.expect("move")
+}
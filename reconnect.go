@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// ReconnectTokenTTL is how long a reconnect token stays valid after its
+// connection closed.
+var ReconnectTokenTTL = 5 * time.Minute
+
+// ReconnectSession is what a reconnect token resumes: the player id and
+// the game the connection was viewing.
+type ReconnectSession struct {
+	PlayerID string
+	GameID   string
+	// Open is set while the connection the token was issued to is still
+	// connected. ExpiresAt is only set once it closed.
+	Open      bool
+	ExpiresAt time.Time
+}
+
+var reconnectSessions = make(map[string]*ReconnectSession)
+var reconnectMu sync.Mutex
+
+// Expired reports whether the token's lifetime after its connection closed
+// is over. Tokens of open connections never expire.
+func (session *ReconnectSession) Expired(now time.Time) bool {
+	return !session.Open && now.After(session.ExpiresAt)
+}
+
+// IssueReconnectToken creates a single-use token resuming the player's
+// identity on a later connection.
+func IssueReconnectToken(playerID string, now time.Time) (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(buf)
+
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+
+	for t, session := range reconnectSessions {
+		if session.Expired(now) {
+			delete(reconnectSessions, t)
+		}
+	}
+
+	reconnectSessions[token] = &ReconnectSession{
+		PlayerID: playerID,
+		Open:     true,
+	}
+
+	return token, nil
+}
+
+// CloseReconnectSession records the game the connection was viewing when
+// it closed and starts the token's lifetime from now.
+func CloseReconnectSession(token string, gameID string, now time.Time) {
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+
+	session, ok := reconnectSessions[token]
+	if !ok {
+		return
+	}
+
+	session.GameID = gameID
+	session.Open = false
+	session.ExpiresAt = now.Add(ReconnectTokenTTL)
+}
+
+// ConsumeReconnectToken returns the session of a valid token and
+// invalidates the token.
+func ConsumeReconnectToken(token string, now time.Time) (ReconnectSession, bool) {
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+
+	session, ok := reconnectSessions[token]
+	if !ok {
+		return ReconnectSession{}, false
+	}
+
+	delete(reconnectSessions, token)
+
+	if session.Expired(now) {
+		return ReconnectSession{}, false
+	}
+
+	return *session, true
+}
+
+// PlayerActiveGames returns the ids of the unfinished games the player
+// plays in, least recently used first. Games indexed at the start were
+// not used since and come first.
+func PlayerActiveGames(playerID string) []string {
+	type activeGame struct {
+		id         string
+		lastAccess time.Time
+	}
+
+	active := make([]activeGame, 0)
+
+	ForEachGame(func(id string, record GameRecord) {
+		if playerID == "" || (record.WhitePlayerId != playerID && record.BlackPlayerId != playerID) {
+			return
+		}
+
+		if record.Outcome == chess.NoOutcome {
+			active = append(active, activeGame{id: id, lastAccess: record.LastAccess})
+		}
+	})
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].lastAccess.Before(active[j].lastAccess)
+	})
+
+	ids := make([]string, 0, len(active))
+	for _, game := range active {
+		ids = append(ids, game.id)
+	}
+
+	return ids
+}
+
+// ResumePlayerGames sends a player that connects again with its id the
+// state of each game it is playing, as if it had joined them. The client
+// ends up subscribed to the game it used most recently.
+func ResumePlayerGames(client *Client) error {
+	for _, id := range PlayerActiveGames(client.ID) {
+		game, ok := GetGame(id)
+		if !ok {
+			continue
+		}
+
+		// JoinGame already told the client that this game is full, the
+		// other games are still resumed
+		err := JoinGame(client, id, game, false)
+		if errors.Is(err, ErrGameFull) {
+			slog.Warn("resuming game failed", "game_id", id, "client_id", client.ID, "error", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := GeneratePossibleMovesBySquareMessage(id, game, client)
+		if err != nil {
+			return err
+		}
+
+		err = client.Send(data)
+		if err != nil {
+			return err
+		}
+
+		game.mu.RLock()
+		data, err = GenerateOutcomeMessage(id, game, client.Locale)
+		game.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		err = client.Send(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/notnil/chess"
+)
+
+// dialHello connects like dialWS and returns the hello message as well.
+func dialHello(t *testing.T, server *httptest.Server, query string) (*testConn, HelloMessage) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testConn{t: t, conn: conn}
+
+	var hello HelloMessage
+	c.expect("hello", &hello)
+
+	return c, hello
+}
+
+// dialRejected fails unless connecting with the query is refused with the
+// given status.
+func dialRejected(t *testing.T, server *httptest.Server, query string, status int) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("dialing %s succeeded, want status %d", url, status)
+	}
+	if resp == nil || resp.StatusCode != status {
+		t.Fatalf("dialing %s: %v, want status %d", url, err, status)
+	}
+}
+
+func sessionClosed(token string) bool {
+	reconnectMu.Lock()
+	defer reconnectMu.Unlock()
+
+	session, ok := reconnectSessions[token]
+	return ok && !session.Open
+}
+
+func TestReconnectTokenResumesSession(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice, hello := dialHello(t, server, "id=alice")
+	alice.expect("outcome")
+	bob := dialPlayer(t, server, "bob")
+
+	alice.conn.Close()
+	waitFor(t, "the session to close", func() bool { return sessionClosed(hello.ReconnectToken) })
+
+	resumed, resumedHello := dialHello(t, server, "reconnectToken="+hello.ReconnectToken)
+	if resumedHello.ID != "alice" || resumedHello.GameID != "game-1" {
+		t.Fatalf("resumed as %q in %q, want alice in game-1", resumedHello.ID, resumedHello.GameID)
+	}
+	if resumedHello.ReconnectToken == "" || resumedHello.ReconnectToken == hello.ReconnectToken {
+		t.Errorf("resumed connection got token %q, want a new one", resumedHello.ReconnectToken)
+	}
+
+	var state StateMessage
+	resumed.expect("state", &state)
+	if state.GameID != "game-1" {
+		t.Fatalf("state for %q, want game-1", state.GameID)
+	}
+
+	// the resumed connection plays the game again
+	resumed.send("move", MoveMessage{GameID: "game-1", Move: "e2e4"})
+	resumed.expect("moveAck")
+
+	var answer MoveAnswer
+	bob.expect("move", &answer)
+	if answer.Move != "e2e4" {
+		t.Fatalf("bob got move %s, want e2e4", answer.Move)
+	}
+}
+
+func TestReconnectTokenIsSingleUse(t *testing.T) {
+	resetState(t)
+
+	server := newTestServer(t)
+	alice, hello := dialHello(t, server, "id=alice")
+	alice.conn.Close()
+	waitFor(t, "the session to close", func() bool { return sessionClosed(hello.ReconnectToken) })
+
+	dialHello(t, server, "reconnectToken="+hello.ReconnectToken)
+	dialRejected(t, server, "reconnectToken="+hello.ReconnectToken, 401)
+}
+
+func TestReconnectTokenExpires(t *testing.T) {
+	resetState(t)
+
+	previous := ReconnectTokenTTL
+	ReconnectTokenTTL = 50 * time.Millisecond
+	t.Cleanup(func() { ReconnectTokenTTL = previous })
+
+	server := newTestServer(t)
+	alice, hello := dialHello(t, server, "id=alice")
+
+	// the lifetime starts when the connection closes, not when the token
+	// was issued
+	time.Sleep(100 * time.Millisecond)
+	alice.conn.Close()
+	waitFor(t, "the session to close", func() bool { return sessionClosed(hello.ReconnectToken) })
+
+	_, ok := ConsumeReconnectToken(hello.ReconnectToken, time.Now())
+	if !ok {
+		t.Fatal("token expired before its connection closed")
+	}
+
+	bob, hello := dialHello(t, server, "id=bob")
+	bob.conn.Close()
+	waitFor(t, "the session to close", func() bool { return sessionClosed(hello.ReconnectToken) })
+
+	time.Sleep(100 * time.Millisecond)
+	dialRejected(t, server, "reconnectToken="+hello.ReconnectToken, 401)
+}
+
+func TestOpenSessionsAreNotSwept(t *testing.T) {
+	resetState(t)
+
+	now := time.Now()
+	token, err := IssueReconnectToken("alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issuing another token long after sweeps expired sessions, but the
+	// first connection is still open
+	later := now.Add(10 * ReconnectTokenTTL)
+	_, err = IssueReconnectToken("bob", later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CloseReconnectSession(token, "game-1", later)
+
+	session, ok := ConsumeReconnectToken(token, later.Add(ReconnectTokenTTL/2))
+	if !ok {
+		t.Fatal("session of an open connection was swept")
+	}
+	if session.PlayerID != "alice" || session.GameID != "game-1" {
+		t.Errorf("session %+v, want alice in game-1", session)
+	}
+}
+
+func TestReconnectingPlayerGetsGameStateWithoutJoin(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	bob := dialPlayer(t, server, "bob")
+
+	// bob's connection drops mid-game
+	bob.conn.Close()
+	waitFor(t, "bob to disconnect", func() bool { return !isConnected("bob") })
+
+	playMoves(t, "game-1", game, "e2e4", "d7d5", "e4d5")
+
+	// a refresh connects with the same id and no join
+	bob = dialWS(t, server, "id=bob")
+
+	var against AgainstMessage
+	bob.expect("against", &against)
+	if against.ID != "alice" || against.Color != ColorWhite || against.LastMove == nil || against.LastMove.To != "d5" {
+		t.Errorf("against %+v, want alice with white after e4xd5", against)
+	}
+
+	var captured CapturedPiecesMessage
+	bob.expect("capturedPieces", &captured)
+	if captured.Black.Count != 1 {
+		t.Errorf("%d black pieces captured, want 1", captured.Black.Count)
+	}
+
+	var possible PossibleMovesBySquareAnswer
+	bob.expect("possibleMovesBySquare", &possible)
+	if len(possible.Moves) == 0 {
+		t.Error("black to move got no moves")
+	}
+
+	var outcome OutcomeMessage
+	bob.expect("outcome", &outcome)
+	if outcome.GameID != "game-1" || outcome.Outcome != chess.NoOutcome.String() {
+		t.Errorf("outcome %+v, want the running game-1", outcome)
+	}
+}
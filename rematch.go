@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/notnil/chess"
+)
+
+type RematchMessage struct {
+	GameID string `json:"gameId"`
+}
+
+// RematchRequestedMessage tells a player that the opponent wants a
+// rematch.
+type RematchRequestedMessage struct {
+	GameID string `json:"gameId"`
+	By     string `json:"by"`
+}
+
+type RematchReadyMessage struct {
+	GameID    string `json:"gameId"`
+	NewGameID string `json:"newGameId"`
+}
+
+// RematchRequest describes the rematch of a finished game: the same
+// start position and settings with the colors swapped. Each player keeps
+// their own clock settings.
+func RematchRequest(game *Game) CreateGameRequest {
+	request := CreateGameRequest{
+		Player1:           game.BlackPlayerId,
+		Player2:           game.WhitePlayerId,
+		PreferredColor:    ColorWhite,
+		DisableDrawOffers: game.DrawsDisabled,
+		Notation:          game.Notation,
+		RequireReady:      game.RequireReady,
+		BotDifficulty:     game.BotDifficulty,
+	}
+
+	for _, tag := range game.Game.TagPairs() {
+		if tag.Key == "FEN" {
+			request.StartingFen = tag.Value
+		}
+	}
+
+	if game.Clock != nil {
+		white := game.Clock.Black
+		black := game.Clock.White
+		request.WhiteClock = &white
+		request.BlackClock = &black
+		request.ClockStart = game.Clock.Start
+	}
+
+	return request
+}
+
+func GenerateRematchReadyMessage(gameID string, newGameID string) ([]byte, error) {
+	data, err := json.Marshal(RematchReadyMessage{
+		GameID:    gameID,
+		NewGameID: newGameID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(WebsocketMessage{
+		Type:    "rematchReady",
+		Payload: string(data),
+	})
+}
+
+// HandleRematch records a player's wish for a rematch of a finished game.
+// Once both players asked, the new game is created and both get its id.
+func HandleRematch(wsMsg WebsocketMessage, client *Client) error {
+	var rematch RematchMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &rematch)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(rematch.GameID)
+	if !ok {
+		return SendError(client, rematch.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return SendError(client, rematch.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	game.mu.Lock()
+
+	if game.Game.Outcome() == chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, rematch.GameID, ErrorCodeGameNotOver, "Game is not over yet")
+	}
+
+	// a late request of the second player gets the existing rematch
+	if game.RematchGameID != "" {
+		newGameID := game.RematchGameID
+		game.mu.Unlock()
+
+		data, err := GenerateRematchReadyMessage(rematch.GameID, newGameID)
+		if err != nil {
+			return err
+		}
+
+		return client.Send(data)
+	}
+
+	if color == chess.White {
+		game.WhiteRematch = true
+	} else {
+		game.BlackRematch = true
+	}
+
+	// the bot always agrees to a rematch
+	if PlayerIdForColor(game, color.Other()) == BotPlayerID {
+		game.WhiteRematch = true
+		game.BlackRematch = true
+	}
+
+	if !game.WhiteRematch || !game.BlackRematch {
+		game.mu.Unlock()
+
+		data, err := json.Marshal(RematchRequestedMessage{
+			GameID: rematch.GameID,
+			By:     ColorCode(color),
+		})
+		if err != nil {
+			return err
+		}
+
+		data, err = json.Marshal(WebsocketMessage{
+			Type:    "rematchRequested",
+			Payload: string(data),
+		})
+		if err != nil {
+			return err
+		}
+
+		SendToPlayer(rematch.GameID, PlayerIdForColor(game, color.Other()), data)
+
+		return nil
+	}
+
+	newGame, err := NewGameFromRequest(RematchRequest(game))
+	if err != nil {
+		game.mu.Unlock()
+		return err
+	}
+
+	newGameID := uuid.New().String()
+	game.RematchGameID = newGameID
+	game.mu.Unlock()
+
+	err = AddGame(newGameID, newGame)
+	if err != nil {
+		return err
+	}
+
+	data, err := GenerateRematchReadyMessage(rematch.GameID, newGameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastToPlayers(rematch.GameID, game, data)
+
+	PlayBotMove(newGameID, newGame)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRematchOnUnfinishedGameIsRejected(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("rematch", RematchMessage{GameID: "game-1"})
+
+	var errorMsg ErrorMessage
+	alice.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeGameNotOver {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeGameNotOver)
+	}
+}
+
+func TestRematchSwapsColors(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+	playMoves(t, "game-1", game, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	server := newTestServer(t)
+	alice := dialWS(t, server, "id=alice")
+	bob := dialWS(t, server, "id=bob")
+	alice.send("join", JoinMessage{GameID: "game-1"})
+	alice.expect("against")
+	bob.send("join", JoinMessage{GameID: "game-1"})
+	bob.expect("against")
+
+	alice.send("rematch", RematchMessage{GameID: "game-1"})
+	bob.expect("rematchRequested")
+	bob.send("rematch", RematchMessage{GameID: "game-1"})
+
+	var aliceReady, bobReady RematchReadyMessage
+	alice.expect("rematchReady", &aliceReady)
+	bob.expect("rematchReady", &bobReady)
+	if aliceReady.NewGameID == "" || aliceReady.NewGameID != bobReady.NewGameID {
+		t.Fatalf("players got rematches %q and %q, want one game", aliceReady.NewGameID, bobReady.NewGameID)
+	}
+
+	gamesMu.RLock()
+	count := len(games)
+	gamesMu.RUnlock()
+	if count != 2 {
+		t.Errorf("%d games, want the original and one rematch", count)
+	}
+
+	rematch, ok := GetGame(aliceReady.NewGameID)
+	if !ok {
+		t.Fatal("rematch not found")
+	}
+	if rematch.WhitePlayerId != "bob" || rematch.BlackPlayerId != "alice" {
+		t.Errorf("rematch white %q and black %q, want the colors swapped", rematch.WhitePlayerId, rematch.BlackPlayerId)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/notnil/chess"
+)
+
+type ResignMessage struct {
+	GameID string `json:"gameId"`
+	// Color is the resigning side, "w" or "b".
+	Color string `json:"color"`
+}
+
+func HandleResign(wsMsg WebsocketMessage, client *Client) error {
+	var resign ResignMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &resign)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(resign.GameID)
+	if !ok {
+		return SendError(client, resign.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok || ColorCode(color) != resign.Color {
+		return SendError(client, resign.GameID, ErrorCodeNotAPlayer, "Only the player of that color can resign")
+	}
+
+	game.mu.Lock()
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, resign.GameID, ErrorCodeGameOver, "Game is over")
+	}
+
+	game.Game.Resign(color)
+	game.DrawOffer = nil
+	FinalizeGame(resign.GameID, game)
+	game.mu.Unlock()
+
+	err = SaveGame(resign.GameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastOutcome(resign.GameID, game)
+
+	return nil
+}
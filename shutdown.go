@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ShutdownTimeout bounds how long a graceful shutdown waits for running
+// handlers and clients to disconnect.
+var ShutdownTimeout = 10 * time.Second
+
+// wsHandlers tracks running websocket handlers. http.Server.Shutdown
+// doesn't wait for hijacked connections, so shutdown waits for these
+// itself.
+var wsHandlers sync.WaitGroup
+
+// CloseAllClients sends a close frame with the reason to every connected
+// client. The handlers end once the client answers the close.
+func CloseAllClients(reason string) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, reason)
+
+	for _, client := range connectedClients {
+		err := client.SendControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		if err != nil {
+			slog.Warn("closing connection failed", "client_id", client.ID, "error", err)
+		}
+	}
+}
+
+// closeAllConns drops the connections of clients that didn't answer the
+// close frame in time.
+func closeAllConns() {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	for _, client := range connectedClients {
+		client.Conn.Close()
+	}
+}
+
+// Shutdown stops the server gracefully: it stops accepting requests, waits
+// for running HTTP handlers, disconnects all websocket clients, waits for
+// running bot searches and writes every game. stopWorkers must stop the background workers and return once
+// they are done. Connections still open when ctx is done are dropped, and
+// writes queued while the store is unavailable are retried until then.
+func Shutdown(ctx context.Context, server *http.Server, stopWorkers func()) error {
+	SetReady(false)
+
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("stopping server failed", "error", err)
+	}
+
+	CloseAllClients("Server shutting down")
+
+	done := make(chan struct{})
+	go func() {
+		wsHandlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		closeAllConns()
+		<-done
+	}
+
+	// bot moves still being searched land before the games are written
+	botSearches.Wait()
+
+	stopWorkers()
+
+	err = SaveGames()
+	if err != nil {
+		return err
+	}
+
+	return DrainPendingWrites(ctx)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+type simulateResponse struct {
+	Fen         string `json:"fen"`
+	Legal       bool   `json:"legal"`
+	FailedIndex int    `json:"failedIndex"`
+	Outcome     string `json:"outcome"`
+	Method      string `json:"method"`
+}
+
+func TestSimulateLegalLine(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "sim", CreateGameRequest{})
+	playMoves(t, "sim", game, "f2f3")
+	before := game.Game.Position().String()
+
+	recorder := doRequest(t, "POST", "/game/sim/simulate", SimulateRequest{
+		Moves: []string{"e7e5", "g2g4", "d8h4"},
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response simulateResponse
+	decodeJSON(t, recorder, &response)
+
+	if !response.Legal || response.FailedIndex != -1 {
+		t.Errorf("legal %v at %d, want a legal line", response.Legal, response.FailedIndex)
+	}
+	if response.Outcome != "0-1" || response.Method != "Checkmate" {
+		t.Errorf("outcome %s by %s, want 0-1 by Checkmate", response.Outcome, response.Method)
+	}
+	if response.Fen != "rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3" {
+		t.Errorf("fen %s", response.Fen)
+	}
+
+	if got := game.Game.Position().String(); got != before {
+		t.Errorf("stored game changed to %s", got)
+	}
+	if len(game.Game.Moves()) != 1 {
+		t.Errorf("stored game has %d moves, want 1", len(game.Game.Moves()))
+	}
+}
+
+func TestSimulateStopsAtIllegalMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "sim", CreateGameRequest{})
+	before := game.Game.Position().String()
+
+	recorder := doRequest(t, "POST", "/game/sim/simulate", SimulateRequest{
+		Moves: []string{"e2e4", "e7e5", "e4e5", "g1f3"},
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response simulateResponse
+	decodeJSON(t, recorder, &response)
+
+	if response.Legal || response.FailedIndex != 2 {
+		t.Errorf("legal %v at %d, want a failure at 2", response.Legal, response.FailedIndex)
+	}
+	if response.Fen != "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2" {
+		t.Errorf("fen %s, want the position before the illegal move", response.Fen)
+	}
+	if got := game.Game.Position().String(); got != before {
+		t.Errorf("stored game changed to %s", got)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestSpectatorsGetMovesButCannotMove(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	spectator := dialWS(t, server, "id=carol")
+	spectator.send("spectate", SpectateMessage{GameID: "game-1"})
+	spectator.expect("players")
+
+	summaries := listGames(t, "")
+	if len(summaries) != 1 || summaries[0].Spectators != 1 {
+		t.Fatalf("listing %+v, want one game with one spectator", summaries)
+	}
+
+	// spectators can't move, so they don't get any moves to pick from
+	spectator.send("possibleMovesBySquare", PossibleMovesBySquareMessage{GameID: "game-1"})
+
+	var possible PossibleMovesBySquareAnswer
+	spectator.expect("possibleMovesBySquare", &possible)
+	if len(possible.Moves) != 0 {
+		t.Errorf("spectator got moves %v, want none", possible.Moves)
+	}
+
+	// scholar's mate, capturing on the last move
+	moves := []struct {
+		player *testConn
+		move   string
+	}{
+		{alice, "e2e4"},
+		{bob, "e7e5"},
+		{alice, "f1c4"},
+		{bob, "b8c6"},
+		{alice, "d1h5"},
+		{bob, "g8f6"},
+		{alice, "h5f7"},
+	}
+	for _, m := range moves {
+		m.player.send("move", MoveMessage{GameID: "game-1", Move: m.move})
+		m.player.expect("moveAck")
+
+		var move MoveMessage
+		spectator.expect("move", &move)
+		if move.Move != m.move {
+			t.Errorf("spectator got move %q, want %q", move.Move, m.move)
+		}
+	}
+
+	var captured CapturedPiecesMessage
+	spectator.expect("capturedPieces", &captured)
+	if captured.Black.Count != 1 {
+		t.Errorf("spectator saw %d black pieces captured, want 1", captured.Black.Count)
+	}
+
+	var outcome OutcomeMessage
+	spectator.expect("outcome", &outcome)
+	if outcome.Winner != ColorCode(chess.White) {
+		t.Errorf("spectator saw winner %q, want white", outcome.Winner)
+	}
+
+	spectator.send("move", MoveMessage{GameID: "game-1", Move: "e8f7"})
+
+	var errorMsg ErrorMessage
+	spectator.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeReadOnly {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeReadOnly)
+	}
+
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+	if len(game.Game.Moves()) != 7 {
+		t.Errorf("game has %d moves, want 7", len(game.Game.Moves()))
+	}
+}
+
+func TestDetachingRacesWithSpectatorMessages(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	spectator := dialWS(t, server, "id=carol")
+	spectator.send("spectate", SpectateMessage{GameID: "game-1"})
+	spectator.expect("players")
+
+	// the race detector flags reads of the client's game that don't hold
+	// clientsMu while DetachGameClients resets it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			DetachGameClients("game-1")
+		}
+	}()
+
+	for range 20 {
+		spectator.send("possibleMovesBySquare", PossibleMovesBySquareMessage{GameID: "game-1"})
+		spectator.expect("possibleMovesBySquare")
+	}
+
+	<-done
+}
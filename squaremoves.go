@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/notnil/chess"
+)
+
+type SquareMovesMessage struct {
+	GameID string `json:"gameId"`
+	Square string `json:"square"`
+}
+
+type SquareMovesAnswer struct {
+	GameID string   `json:"gameId"`
+	Square string   `json:"square"`
+	Moves  []string `json:"moves"`
+}
+
+// ParseSquare parses a square in algebraic form like "e4".
+func ParseSquare(str string) (chess.Square, bool) {
+	if len(str) != 2 || str[0] < 'a' || str[0] > 'h' || str[1] < '1' || str[1] > '8' {
+		return chess.NoSquare, false
+	}
+
+	return chess.NewSquare(chess.File(str[0]-'a'), chess.Rank(str[1]-'1')), true
+}
+
+// MovesFromSquare returns the legal moves of the current position that
+// start on sq, in the game's notation.
+func MovesFromSquare(game *Game, sq chess.Square) []string {
+	pos := game.Game.Position()
+	moves := make([]string, 0)
+
+	for _, m := range game.Game.ValidMoves() {
+		if m.S1() == sq {
+			moves = append(moves, FormatMove(game, pos, m))
+		}
+	}
+
+	return moves
+}
+
+// HandleSquareMoves answers with the legal moves from a square. Only the
+// player to move gets moves, everyone else including spectators gets an
+// empty list.
+func HandleSquareMoves(wsMsg WebsocketMessage, client *Client) error {
+	var squareMoves SquareMovesMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &squareMoves)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(squareMoves.GameID)
+	if !ok {
+		return SendError(client, squareMoves.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	sq, ok := ParseSquare(squareMoves.Square)
+	if !ok {
+		return SendError(client, squareMoves.GameID, ErrorCodeInvalidSquare, "Invalid square")
+	}
+
+	_, spectator := ClientGame(client)
+
+	moves := make([]string, 0)
+	game.mu.RLock()
+	if !spectator && IsPlayersTurn(game, client.ID) {
+		moves = MovesFromSquare(game, sq)
+	}
+	game.mu.RUnlock()
+
+	data, err := json.Marshal(SquareMovesAnswer{
+		GameID: squareMoves.GameID,
+		Square: squareMoves.Square,
+		Moves:  moves,
+	})
+	if err != nil {
+		return err
+	}
+
+	answer := WebsocketMessage{
+		Type:    "squareMoves",
+		Payload: string(data),
+	}
+
+	data, err = json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+type PossibleMovesBySquareMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type PossibleMovesBySquareAnswer struct {
+	GameID string              `json:"gameId"`
+	Moves  map[string][]string `json:"moves"`
+}
+
+// PossibleMovesBySquare groups the legal moves of the current position by
+// origin square, mapping each to its destination squares.
+func PossibleMovesBySquare(game *Game) map[string][]string {
+	moves := make(map[string][]string)
+
+	for _, m := range game.Game.ValidMoves() {
+		from := m.S1().String()
+		to := m.S2().String()
+
+		// the four promotions of a pawn share one destination
+		if slices.Contains(moves[from], to) {
+			continue
+		}
+
+		moves[from] = append(moves[from], to)
+	}
+
+	for _, targets := range moves {
+		slices.Sort(targets)
+	}
+
+	return moves
+}
+
+// HandlePossibleMovesBySquare answers with all legal moves grouped by
+// origin square, under the same rules as HandleSquareMoves.
+func HandlePossibleMovesBySquare(wsMsg WebsocketMessage, client *Client) error {
+	var request PossibleMovesBySquareMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &request)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(request.GameID)
+	if !ok {
+		return SendError(client, request.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	data, err := GeneratePossibleMovesBySquareMessage(request.GameID, game, client)
+	if err != nil {
+		return err
+	}
+
+	return client.Send(data)
+}
+
+func GeneratePossibleMovesBySquareMessage(gameID string, game *Game, client *Client) ([]byte, error) {
+	_, spectator := ClientGame(client)
+
+	moves := make(map[string][]string)
+	game.mu.RLock()
+	if !spectator && IsPlayersTurn(game, client.ID) {
+		moves = PossibleMovesBySquare(game)
+	}
+	game.mu.RUnlock()
+
+	data, err := json.Marshal(PossibleMovesBySquareAnswer{
+		GameID: gameID,
+		Moves:  moves,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	answer := WebsocketMessage{
+		Type:    "possibleMovesBySquare",
+		Payload: string(data),
+	}
+
+	return json.Marshal(answer)
+}
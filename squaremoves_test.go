@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSquareMovesMessage(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	var answer SquareMovesAnswer
+
+	alice.send("squareMoves", SquareMovesMessage{GameID: "game-1", Square: "g1"})
+	alice.expect("squareMoves", &answer)
+	if want := []string{"g1f3", "g1h3"}; answer.Square != "g1" || !reflect.DeepEqual(answer.Moves, want) {
+		t.Errorf("white to move got %+v, want %v", answer, want)
+	}
+
+	// bob doesn't own the turn
+	bob.send("squareMoves", SquareMovesMessage{GameID: "game-1", Square: "g8"})
+	bob.expect("squareMoves", &answer)
+	if len(answer.Moves) != 0 {
+		t.Errorf("black got moves %v while white is to move", answer.Moves)
+	}
+
+	bob.send("squareMoves", SquareMovesMessage{GameID: "game-1", Square: "z9"})
+
+	var errorMsg ErrorMessage
+	bob.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeInvalidSquare {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeInvalidSquare)
+	}
+}
+
+func TestPossibleMovesBySquareInTheOpening(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("possibleMovesBySquare", PossibleMovesBySquareMessage{GameID: "game-1"})
+
+	var answer PossibleMovesBySquareAnswer
+	alice.expect("possibleMovesBySquare", &answer)
+
+	// eight pawns and two knights can move
+	if len(answer.Moves) != 10 {
+		t.Errorf("%d origin squares, want 10: %v", len(answer.Moves), answer.Moves)
+	}
+
+	want := map[string][]string{
+		"e2": {"e3", "e4"},
+		"b1": {"a3", "c3"},
+		"g1": {"f3", "h3"},
+	}
+	for from, targets := range want {
+		if !reflect.DeepEqual(answer.Moves[from], targets) {
+			t.Errorf("%s maps to %v, want %v", from, answer.Moves[from], targets)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import "github.com/notnil/chess"
+
+type ResultCounts struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+type PlayerStats struct {
+	PlayerID string                   `json:"playerId"`
+	Total    ResultCounts             `json:"total"`
+	White    ResultCounts             `json:"white"`
+	Black    ResultCounts             `json:"black"`
+	Methods  map[string]*ResultCounts `json:"methods"`
+}
+
+func (rc *ResultCounts) Add(outcome chess.Outcome, color chess.Color) {
+	switch {
+	case outcome == chess.Draw:
+		rc.Draws++
+	case (outcome == chess.WhiteWon) == (color == chess.White):
+		rc.Wins++
+	default:
+		rc.Losses++
+	}
+}
+
+// ComputePlayerStats aggregates the results of all finished games the
+// player took part in.
+func ComputePlayerStats(playerID string) PlayerStats {
+	stats := PlayerStats{
+		PlayerID: playerID,
+		Methods:  make(map[string]*ResultCounts),
+	}
+
+	ForEachGame(func(id string, record GameRecord) {
+		outcome := record.Outcome
+		if outcome == chess.NoOutcome {
+			return
+		}
+
+		var color chess.Color
+		switch playerID {
+		case record.WhitePlayerId:
+			color = chess.White
+		case record.BlackPlayerId:
+			color = chess.Black
+		default:
+			return
+		}
+
+		stats.Total.Add(outcome, color)
+
+		if color == chess.White {
+			stats.White.Add(outcome, color)
+		} else {
+			stats.Black.Add(outcome, color)
+		}
+
+		method := record.Method
+		if _, ok := stats.Methods[method]; !ok {
+			stats.Methods[method] = &ResultCounts{}
+		}
+
+		stats.Methods[method].Add(outcome, color)
+	})
+
+	return stats
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestPlayerStatsBreakdown(t *testing.T) {
+	resetState(t)
+
+	// alice gets mated with white
+	mated := newTestGame(t, "mated", CreateGameRequest{})
+	playMoves(t, "mated", mated, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	// alice wins with black by resignation
+	resigned := newTestGame(t, "resigned", CreateGameRequest{
+		Player1:        "carol",
+		Player2:        "alice",
+		PreferredColor: ColorWhite,
+	})
+	resigned.Game.Resign(chess.White)
+
+	// alice draws with white by agreement
+	drawn := newTestGame(t, "drawn", CreateGameRequest{})
+	err := drawn.Game.Draw(chess.DrawOffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// running games don't count
+	newTestGame(t, "running", CreateGameRequest{})
+
+	recorder := doRequest(t, "GET", "/players/alice/stats", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+
+	var stats PlayerStats
+	decodeJSON(t, recorder, &stats)
+
+	if want := (ResultCounts{Wins: 1, Losses: 1, Draws: 1}); stats.Total != want {
+		t.Errorf("total %+v, want %+v", stats.Total, want)
+	}
+	if want := (ResultCounts{Losses: 1, Draws: 1}); stats.White != want {
+		t.Errorf("as white %+v, want %+v", stats.White, want)
+	}
+	if want := (ResultCounts{Wins: 1}); stats.Black != want {
+		t.Errorf("as black %+v, want %+v", stats.Black, want)
+	}
+
+	methods := map[string]ResultCounts{
+		"Checkmate":   {Losses: 1},
+		"Resignation": {Wins: 1},
+		"DrawOffer":   {Draws: 1},
+	}
+	if len(stats.Methods) != len(methods) {
+		t.Errorf("methods %v, want %v", stats.Methods, methods)
+	}
+	for method, want := range methods {
+		got, ok := stats.Methods[method]
+		if !ok || *got != want {
+			t.Errorf("%s: %+v, want %+v", method, got, want)
+		}
+	}
+}
+
+func TestPlayerStatsWithoutGames(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, "GET", "/players/nobody/stats", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+
+	var stats PlayerStats
+	decodeJSON(t, recorder, &stats)
+
+	if stats.Total != (ResultCounts{}) || stats.White != (ResultCounts{}) || stats.Black != (ResultCounts{}) {
+		t.Errorf("stats %+v, want zeros", stats)
+	}
+	if len(stats.Methods) != 0 {
+		t.Errorf("methods %v, want none", stats.Methods)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrStoreUnavailable can be returned by a store that is temporarily
+// unreachable. Writes failing with it are queued and retried.
+var ErrStoreUnavailable = errors.New("Store unavailable")
+
+var ErrInvalidGameID = errors.New("Invalid game id")
+
+const storeRetryBaseDelay = 100 * time.Millisecond
+const storeRetryMaxDelay = 30 * time.Second
+
+// GamesDir holds one <id>.json file per game.
+var GamesDir = "data"
+
+// legacyGamesFile is where all games were stored in a single file before
+// they got one file each. It is only read if GamesDir doesn't exist.
+const legacyGamesFile = "games.json"
+
+// ValidGameID reports whether id can be used as a file name in GamesDir.
+func ValidGameID(id string) bool {
+	return id != "" && id != "." && id != ".." && filepath.Base(id) == id && !strings.ContainsAny(id, `/\`)
+}
+
+func gamePath(id string) string {
+	return filepath.Join(GamesDir, id+".json")
+}
+
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so a crash leaves either the old or the new content and
+// never a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+// writeStore persists the encoded game with the given id. The previous
+// version is kept as a .bak copy first, LoadGames falls back to it if the
+// game file can't be read.
+var writeStore = func(id string, data []byte) error {
+	err := os.MkdirAll(GamesDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	path := gamePath(id)
+
+	previous, err := os.ReadFile(path)
+	if err == nil && json.Valid(previous) {
+		err = writeFileAtomic(backupPath(path), previous)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// removeStore deletes the stored game with the given id and its backup.
+// Removing a game that was never stored is not an error.
+var removeStore = func(id string) error {
+	path := gamePath(id)
+
+	for _, name := range []string{path, backupPath(path)} {
+		err := os.Remove(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pendingWrites holds, per game, the newest encoding that could not be
+// written yet. A newer encoding of the same game supersedes the queued
+// one.
+var pendingWrites = make(map[string][]byte)
+var storeRetrying bool
+var storeMu sync.Mutex
+
+// IsTransientStoreError reports whether a failed write is worth retrying.
+func IsTransientStoreError(err error) bool {
+	if errors.Is(err, ErrStoreUnavailable) {
+		return true
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ENOSPC)
+}
+
+// PersistGame writes a single game. Transient failures are not reported
+// to the caller: the write is queued and retried with backoff until the
+// store recovers. Fatal failures are returned.
+func PersistGame(id string, data []byte) error {
+	if !ValidGameID(id) {
+		return ErrInvalidGameID
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	err := writeStore(id, data)
+	if err == nil {
+		delete(pendingWrites, id)
+		return nil
+	}
+
+	if !IsTransientStoreError(err) {
+		return err
+	}
+
+	slog.Warn("store unavailable, queueing write", "game_id", id, "error", err)
+	pendingWrites[id] = data
+
+	if !storeRetrying {
+		storeRetrying = true
+		go retryPendingWrites()
+	}
+
+	return nil
+}
+
+// DeleteStoredGame removes a game from the store, dropping any queued
+// write so it can't bring the game back.
+func DeleteStoredGame(id string) error {
+	if !ValidGameID(id) {
+		return ErrInvalidGameID
+	}
+
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	delete(pendingWrites, id)
+
+	return removeStore(id)
+}
+
+func retryPendingWrites() {
+	delay := storeRetryBaseDelay
+
+	for {
+		time.Sleep(delay)
+
+		done, err := FlushPendingWrites()
+		if done {
+			return
+		}
+
+		if !IsTransientStoreError(err) {
+			// keep the queued writes, the next save of a game tries again
+			slog.Error("giving up on queued write", "error", err)
+			storeMu.Lock()
+			storeRetrying = false
+			storeMu.Unlock()
+			return
+		}
+
+		delay *= 2
+		if delay > storeRetryMaxDelay {
+			delay = storeRetryMaxDelay
+		}
+	}
+}
+
+// FlushPendingWrites writes the queued games, if any. It reports whether
+// nothing is left to write.
+func FlushPendingWrites() (bool, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	for id, data := range pendingWrites {
+		err := writeStore(id, data)
+		if err != nil {
+			return false, err
+		}
+
+		delete(pendingWrites, id)
+	}
+
+	storeRetrying = false
+
+	return true, nil
+}
+
+// DrainPendingWrites retries the queued writes with backoff until all of
+// them are written or ctx is done. Writes still queued then are lost with
+// the process, so they are reported as an error.
+func DrainPendingWrites(ctx context.Context) error {
+	delay := storeRetryBaseDelay
+
+	for {
+		done, err := FlushPendingWrites()
+		if done {
+			return nil
+		}
+
+		if !IsTransientStoreError(err) {
+			return fmt.Errorf("%d queued writes not written: %w", PendingWriteCount(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d queued writes not written: %w", PendingWriteCount(), err)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > storeRetryMaxDelay {
+			delay = storeRetryMaxDelay
+		}
+	}
+}
+
+func PendingWriteCount() int {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	return len(pendingWrites)
+}
+
+// ReadStoredGames reads every game file in GamesDir. If the directory
+// doesn't exist yet, games from the legacy single file are read and
+// written out as separate files. Without either there are no games yet.
+func ReadStoredGames() (StoredGames, error) {
+	storedGames := make(StoredGames)
+
+	entries, err := os.ReadDir(GamesDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return readLegacyGames()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || !ValidGameID(id) {
+			continue
+		}
+
+		storedGame, err := readStoredGame(gamePath(id))
+		if err != nil {
+			backup, backupErr := readStoredGame(backupPath(gamePath(id)))
+			if backupErr != nil {
+				return nil, fmt.Errorf("game %s: %w", id, err)
+			}
+
+			slog.Warn("restored game from backup", "game_id", id, "error", err)
+			storedGame = backup
+		}
+
+		storedGames[id] = storedGame
+	}
+
+	return storedGames, nil
+}
+
+func readStoredGame(path string) (StoredGame, error) {
+	var storedGame StoredGame
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return storedGame, err
+	}
+
+	err = json.Unmarshal(data, &storedGame)
+
+	return storedGame, err
+}
+
+func readLegacyGames() (StoredGames, error) {
+	data, err := os.ReadFile(legacyGamesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(StoredGames), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	storedGames := make(StoredGames)
+	err = json.Unmarshal(data, &storedGames)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, storedGame := range storedGames {
+		data, err := json.Marshal(storedGame)
+		if err != nil {
+			return nil, err
+		}
+
+		err = PersistGame(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("game %s: %w", id, err)
+		}
+	}
+
+	return storedGames, nil
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeStoredJSON writes a raw game file into the store directory.
+func writeStoredJSON(t *testing.T, id string, fields map[string]any) {
+	t.Helper()
+
+	err := os.MkdirAll(GamesDir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(gamePath(id), data, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPGN(t *testing.T) string {
+	t.Helper()
+
+	game := newTestGame(t, "pgn-source", CreateGameRequest{})
+	playMoves(t, "pgn-source", game, "e2e4", "e7e5")
+
+	storedGame, err := StoreGame(game)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = EvictGame("pgn-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return storedGame.PGNStr
+}
+
+func TestLoadGamesMigratesVersionZero(t *testing.T) {
+	resetState(t)
+
+	// a file from before versioning: no version, clock or notation
+	writeStoredJSON(t, "old", map[string]any{
+		"pgn":           testPGN(t),
+		"whitePlayerId": "alice",
+		"blackPlayerId": "bob",
+		"clock":         map[string]any{"whiteMillis": 1000, "blackMillis": 1000},
+	})
+
+	err := LoadGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	game, ok := GetGame("old")
+	if !ok {
+		t.Fatal("game not loaded")
+	}
+
+	if game.Clock != nil {
+		t.Error("version 0 game got a clock")
+	}
+	if game.Notation != DefaultNotation {
+		t.Errorf("notation = %q, want %q", game.Notation, DefaultNotation)
+	}
+	if len(game.Game.Moves()) != 2 || game.WhitePlayerId != "alice" {
+		t.Errorf("game restored with %d moves and white %q", len(game.Game.Moves()), game.WhitePlayerId)
+	}
+
+	// saving writes the current version
+	err = SaveGame("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedGame, err := readStoredGame(gamePath("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedGame.Version != StoredGameVersion {
+		t.Errorf("saved version %d, want %d", storedGame.Version, StoredGameVersion)
+	}
+}
+
+func TestMigrateStoredGameVersionOne(t *testing.T) {
+	migrated, err := MigrateStoredGame(StoredGame{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated.Version != StoredGameVersion || migrated.Notation != DefaultNotation {
+		t.Fatalf("migrated to version %d with notation %q", migrated.Version, migrated.Notation)
+	}
+}
+
+func TestLoadGamesRejectsNewerVersions(t *testing.T) {
+	resetState(t)
+
+	writeStoredJSON(t, "future", map[string]any{
+		"version": StoredGameVersion + 1,
+		"pgn":     testPGN(t),
+	})
+
+	err := LoadGames()
+	if err == nil {
+		t.Fatal("loaded a game from a newer server")
+	}
+}
+
+func TestFailedLoadKeepsStoredGames(t *testing.T) {
+	resetState(t)
+
+	pgn := testPGN(t)
+	for _, id := range []string{"a", "b", "c"} {
+		writeStoredJSON(t, id, map[string]any{"version": StoredGameVersion, "pgn": pgn})
+	}
+	writeStoredJSON(t, "future", map[string]any{
+		"version": StoredGameVersion + 1,
+		"pgn":     pgn,
+	})
+
+	err := LoadGames()
+	if err == nil {
+		t.Fatal("loaded a game from a newer server")
+	}
+
+	// a shutdown after the failed load writes the games
+	err = SaveGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"a", "b", "c", "future"} {
+		_, err := os.Stat(gamePath(id))
+		if err != nil {
+			t.Errorf("game %s was lost: %v", id, err)
+		}
+	}
+}
+
+// flakyStore makes writeStore fail with ErrStoreUnavailable while down is
+// set.
+func flakyStore(t *testing.T) *atomic.Bool {
+	t.Helper()
+
+	var down atomic.Bool
+	write := writeStore
+	writeStore = func(id string, data []byte) error {
+		if down.Load() {
+			return ErrStoreUnavailable
+		}
+		return write(id, data)
+	}
+	t.Cleanup(func() {
+		// the retry goroutine may still be running, so the store is
+		// swapped back under its lock
+		storeMu.Lock()
+		writeStore = write
+		storeMu.Unlock()
+	})
+
+	return &down
+}
+
+func storedMoveCount(t *testing.T, id string) int {
+	t.Helper()
+
+	storedGame, err := readStoredGame(gamePath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	game, err := NewGameFromStored(storedGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(game.Game.Moves())
+}
+
+func TestFlakyStoreLosesNoMutations(t *testing.T) {
+	resetState(t)
+
+	down := flakyStore(t)
+
+	game := newTestGame(t, "flaky", CreateGameRequest{})
+
+	down.Store(true)
+
+	playMoves(t, "flaky", game, "e2e4")
+	err := SaveGame("flaky")
+	if err != nil {
+		t.Fatalf("transient failure reported: %v", err)
+	}
+
+	playMoves(t, "flaky", game, "e7e5")
+	err = SaveGame("flaky")
+	if err != nil {
+		t.Fatalf("transient failure reported: %v", err)
+	}
+
+	if PendingWriteCount() != 1 {
+		t.Fatalf("%d writes queued, want the newest one", PendingWriteCount())
+	}
+
+	down.Store(false)
+
+	waitFor(t, "the queue to drain", func() bool { return PendingWriteCount() == 0 })
+
+	if n := storedMoveCount(t, "flaky"); n != 2 {
+		t.Errorf("stored game has %d moves, want 2", n)
+	}
+}
+
+func TestFatalStoreErrorIsReported(t *testing.T) {
+	resetState(t)
+
+	write := writeStore
+	writeStore = func(id string, data []byte) error { return fs.ErrPermission }
+	t.Cleanup(func() { writeStore = write })
+
+	game, err := NewGameFromRequest(CreateGameRequest{Player1: "alice", Player2: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = AddGame("fatal", game)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("error %v, want %v", err, fs.ErrPermission)
+	}
+	if PendingWriteCount() != 0 {
+		t.Error("fatal failure was queued")
+	}
+}
+
+func TestShutdownFlushesQueuedWrites(t *testing.T) {
+	resetState(t)
+
+	down := flakyStore(t)
+
+	game := newTestGame(t, "queued", CreateGameRequest{})
+
+	down.Store(true)
+	playMoves(t, "queued", game, "e2e4")
+
+	// the store recovers while shutting down
+	time.AfterFunc(50*time.Millisecond, func() { down.Store(false) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := Shutdown(ctx, NewServer(""), func() {})
+	if err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	if n := storedMoveCount(t, "queued"); n != 1 {
+		t.Errorf("stored game has %d moves, want 1", n)
+	}
+}
+
+func TestShutdownReportsLostWrites(t *testing.T) {
+	resetState(t)
+
+	down := flakyStore(t)
+
+	game := newTestGame(t, "queued", CreateGameRequest{})
+
+	down.Store(true)
+	playMoves(t, "queued", game, "e2e4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := Shutdown(ctx, NewServer(""), func() {})
+	if !errors.Is(err, ErrStoreUnavailable) {
+		t.Errorf("error %v, want %v", err, ErrStoreUnavailable)
+	}
+
+	// drop the lost write, so the retry doesn't write it after the test
+	storeMu.Lock()
+	pendingWrites = make(map[string][]byte)
+	storeMu.Unlock()
+}
+
+func TestMoveOnlyRewritesItsGame(t *testing.T) {
+	resetState(t)
+
+	gameA := newTestGame(t, "A", CreateGameRequest{})
+	newTestGame(t, "B", CreateGameRequest{})
+
+	err := SaveGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a rewrite of B would bring its file back
+	err = os.Remove(gamePath("B"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// like HandleMove, which queues the moved game for the worker
+	playMoves(t, "A", gameA, "e2e4")
+	MarkDirty("A")
+
+	err = FlushDirtyGames()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moves := storedMoveCount(t, "A"); moves != 1 {
+		t.Errorf("A stored with %d moves, want 1", moves)
+	}
+
+	_, err = os.Stat(gamePath("B"))
+	if !os.IsNotExist(err) {
+		t.Errorf("B was rewritten by a move in A: %v", err)
+	}
+}
+
+func TestTruncatedGameFileFallsBackToBackup(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "A", CreateGameRequest{})
+
+	err := SaveGame("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playMoves(t, "A", game, "e2e4")
+
+	err = SaveGame("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(GamesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("store holds %d files, want the game and its backup", len(entries))
+	}
+
+	// a crash in the middle of a plain write leaves a truncated file
+	data, err := os.ReadFile(gamePath("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(gamePath("A"), data[:len(data)/2], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedGames, err := ReadStoredGames()
+	if err != nil {
+		t.Fatalf("reading the store: %v", err)
+	}
+
+	restored, err := NewGameFromStored(storedGames["A"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moves := len(restored.Game.Moves()); moves != 0 {
+		t.Errorf("restored game has %d moves, want the backup's 0", moves)
+	}
+}
+
+func TestRapidMovesAreWrittenTogether(t *testing.T) {
+	resetState(t)
+
+	var writes atomic.Int32
+	write := writeStore
+	writeStore = func(id string, data []byte) error {
+		writes.Add(1)
+		return write(id, data)
+	}
+
+	previousInterval := PersistInterval
+	PersistInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunPersistWorker(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		writeStore = write
+		PersistInterval = previousInterval
+	})
+
+	game := newTestGame(t, "A", CreateGameRequest{})
+
+	moves := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	for i := 0; i < 12; i++ {
+		playMoves(t, "A", game, moves[i%len(moves)])
+		MarkDirty("A")
+	}
+
+	// the shutdown flush writes whatever is still queued
+	cancel()
+	<-done
+
+	if n := writes.Load(); n > 4 {
+		t.Errorf("%d writes for 12 moves, want them coalesced", n)
+	}
+	if moves := storedMoveCount(t, "A"); moves != 12 {
+		t.Errorf("stored %d moves, want 12", moves)
+	}
+}
+
+func TestShutdownClosesSocketsAndSavesGames(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	// a move that only lives in memory
+	playMoves(t, "game-1", game, "e2e4")
+
+	closed := make(chan error, 1)
+	go func() {
+		// reading answers the close frame, which ends the handler
+		for {
+			_, _, err := alice.conn.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	err := Shutdown(ctx, NewServer(""), func() {})
+	if err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+
+	select {
+	case err := <-closed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("connection ended with %v, want a going away close", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("socket wasn't closed")
+	}
+
+	if n := storedMoveCount(t, "game-1"); n != 1 {
+		t.Errorf("stored game has %d moves, want 1", n)
+	}
+}
+
+func TestTimestampsAreUpdatedAndPersisted(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "A", CreateGameRequest{})
+	if game.CreatedAt.IsZero() || !game.UpdatedAt.Equal(game.CreatedAt) {
+		t.Fatalf("new game created %s, updated %s", game.CreatedAt, game.UpdatedAt)
+	}
+
+	// pretend the game was created an hour ago
+	createdAt := game.CreatedAt.Add(-time.Hour)
+	game.CreatedAt, game.UpdatedAt = createdAt, createdAt
+
+	before := time.Now()
+	playMoves(t, "A", game, "e2e4")
+
+	if !game.CreatedAt.Equal(createdAt) {
+		t.Errorf("move changed the creation time to %s", game.CreatedAt)
+	}
+	if game.UpdatedAt.Before(before) {
+		t.Errorf("updated %s, want the time of the move after %s", game.UpdatedAt, before)
+	}
+
+	err := SaveGame("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storedGame, err := readStoredGame(gamePath("A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewGameFromStored(storedGame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.CreatedAt.Equal(game.CreatedAt) || !loaded.UpdatedAt.Equal(game.UpdatedAt) {
+		t.Errorf("loaded created %s, updated %s, want %s and %s",
+			loaded.CreatedAt, loaded.UpdatedAt, game.CreatedAt, game.UpdatedAt)
+	}
+
+	recorder := doRequest(t, "GET", "/game/A", nil)
+	if got, want := recorder.Header().Get("X-Created-At"), createdAt.UTC().Format(time.RFC3339); got != want {
+		t.Errorf("created header %q, want %q", got, want)
+	}
+	if got, want := recorder.Header().Get("X-Updated-At"), game.UpdatedAt.UTC().Format(time.RFC3339); got != want {
+		t.Errorf("updated header %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// ClockSweepInterval is how often the sweeper looks for players who ran
+// out of time without moving.
+var ClockSweepInterval = 500 * time.Millisecond
+
+// sweeperNow is the sweeper's time source, replaceable to advance time in
+// tests.
+var sweeperNow = time.Now
+
+// FlagIfTimedOut ends the game on time when the side to move has used up
+// its clock by now. The caller must hold the game lock.
+func FlagIfTimedOut(id string, game *Game, now time.Time) bool {
+	if game.Clock == nil || game.Game.Outcome() != chess.NoOutcome || WaitingForReady(game) {
+		return false
+	}
+
+	turn := game.Game.Position().Turn()
+	if !game.Clock.Started(turn) {
+		return false
+	}
+
+	elapsed := now.Sub(game.Clock.LastMoveAt).Milliseconds()
+	if game.Clock.Remaining(turn)-elapsed > 0 {
+		return false
+	}
+
+	game.Clock.SetRemaining(turn, 0)
+	game.Game.Resign(turn)
+	game.TimedOut = true
+	FinalizeGame(id, game)
+
+	return true
+}
+
+// SweepClocks flags every game whose side to move ran out of time and
+// broadcasts the results. It returns the ids of the flagged games.
+func SweepClocks(now time.Time) []string {
+	flagged := make([]string, 0)
+	flaggedGames := make([]*Game, 0)
+
+	// indexed games are loaded when their flag fell and flagged below
+	for _, id := range IndexedTimeouts(now) {
+		GetGame(id)
+	}
+
+	gamesMu.RLock()
+	for id, game := range games {
+		game.mu.Lock()
+		if FlagIfTimedOut(id, game, now) {
+			flagged = append(flagged, id)
+			flaggedGames = append(flaggedGames, game)
+		}
+		game.mu.Unlock()
+	}
+	gamesMu.RUnlock()
+
+	if len(flagged) == 0 {
+		return flagged
+	}
+
+	for _, id := range flagged {
+		err := SaveGame(id)
+		if err != nil {
+			slog.Error("saving flagged game failed", "game_id", id, "error", err)
+		}
+	}
+
+	for i, id := range flagged {
+		game := flaggedGames[i]
+
+		game.mu.RLock()
+		data, err := GenerateClockMessage(id, game.Clock)
+		game.mu.RUnlock()
+		if err != nil {
+			slog.Error("generating clock message failed", "game_id", id, "error", err)
+			continue
+		}
+
+		BroadcastToGame(id, data)
+		BroadcastOutcome(id, game)
+	}
+
+	return flagged
+}
+
+// RunClockSweeper sweeps the clocks every ClockSweepInterval until ctx is
+// done. A single sweeper serves all games, so finished or deserted games
+// leave no goroutines behind.
+func RunClockSweeper(ctx context.Context) {
+	ticker := time.NewTicker(ClockSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			SweepClocks(sweeperNow())
+		}
+	}
+}
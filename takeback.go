@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+type TakebackRequestMessage struct {
+	GameID string `json:"gameId"`
+}
+
+type TakebackResponseMessage struct {
+	GameID string `json:"gameId"`
+	Accept bool   `json:"accept"`
+}
+
+type TakebackOfferMessage struct {
+	GameID string `json:"gameId"`
+	By     string `json:"by"`
+}
+
+// TakebackRequest is a pending request to take back the last move. It is
+// dropped by the next move.
+type TakebackRequest struct {
+	By chess.Color
+}
+
+// PopMove takes back the last move of the main line. notnil/chess can't
+// undo moves, so the game is rebuilt from its start position with all but
+// the last move. The clock is restored to before the move. Annotations
+// and variations beyond the new end are dropped.
+func PopMove(game *Game) error {
+	moves := game.Game.Moves()
+	if len(moves) == 0 {
+		return errors.New("No moves to take back")
+	}
+
+	positions := game.Game.Positions()
+
+	fen, err := chess.FEN(positions[0].String())
+	if err != nil {
+		return err
+	}
+
+	rebuilt := chess.NewGame(fen, chess.UseNotation(chess.LongAlgebraicNotation{}))
+	for _, tag := range game.Game.TagPairs() {
+		rebuilt.AddTagPair(tag.Key, tag.Value)
+	}
+
+	for i, m := range moves[:len(moves)-1] {
+		replayed, err := chess.UCINotation{}.Decode(positions[i], chess.UCINotation{}.Encode(positions[i], m))
+		if err != nil {
+			return err
+		}
+
+		err = rebuilt.Move(replayed)
+		if err != nil {
+			return err
+		}
+	}
+
+	game.Game = rebuilt
+	cacheValidMoves(rebuilt.Positions()...)
+
+	if game.Clock != nil {
+		game.Clock.Undo(time.Now())
+	}
+
+	ply := len(moves) - 1
+	for annotatedPly := range game.Annotations {
+		if annotatedPly > ply {
+			delete(game.Annotations, annotatedPly)
+		}
+	}
+
+	variations := make([]Variation, 0, len(game.Variations))
+	for _, variation := range game.Variations {
+		if variation.Ply <= ply {
+			variations = append(variations, variation)
+		}
+	}
+	game.Variations = variations
+
+	return nil
+}
+
+func HandleRequestTakeback(wsMsg WebsocketMessage, client *Client) error {
+	var request TakebackRequestMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &request)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(request.GameID)
+	if !ok {
+		return SendError(client, request.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return SendError(client, request.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	game.mu.Lock()
+
+	if game.Game.Outcome() != chess.NoOutcome {
+		game.mu.Unlock()
+		return SendError(client, request.GameID, ErrorCodeGameOver, "Game is over")
+	}
+
+	if len(game.Game.Moves()) == 0 {
+		game.mu.Unlock()
+		return SendError(client, request.GameID, ErrorCodeNoMoves, "No moves to take back")
+	}
+
+	game.TakebackRequest = &TakebackRequest{By: color}
+	game.mu.Unlock()
+
+	data, err := json.Marshal(TakebackOfferMessage{
+		GameID: request.GameID,
+		By:     ColorCode(color),
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "takebackRequest",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	SendToPlayer(request.GameID, PlayerIdForColor(game, color.Other()), data)
+
+	return nil
+}
+
+// HandleRespondTakeback answers the opponent's takeback request. When it
+// is accepted the last move is taken back, everyone viewing the game gets
+// a move answer without a move and the players their possible moves.
+func HandleRespondTakeback(wsMsg WebsocketMessage, client *Client) error {
+	var response TakebackResponseMessage
+	err := json.Unmarshal([]byte(wsMsg.Payload), &response)
+	if err != nil {
+		return err
+	}
+
+	game, ok := GetGame(response.GameID)
+	if !ok {
+		return SendError(client, response.GameID, ErrorCodeGameNotFound, "Game not found")
+	}
+
+	color, ok := PlayerColor(game, client.ID)
+	if !ok {
+		return SendError(client, response.GameID, ErrorCodeNotAPlayer, "Only players can do this")
+	}
+
+	game.mu.Lock()
+
+	request := game.TakebackRequest
+	if request == nil || request.By == color {
+		game.mu.Unlock()
+		return SendError(client, response.GameID, ErrorCodeNoTakeback, "No takeback request to answer")
+	}
+
+	game.TakebackRequest = nil
+
+	var answer []byte
+	var captured []byte
+	var clock []byte
+
+	if response.Accept {
+		err = PopMove(game)
+		if err != nil {
+			game.mu.Unlock()
+			return err
+		}
+
+		game.Sequence++
+		game.DrawOffer = nil
+
+		answer, err = GenerateMoveAnswerMessage(game, MoveMessage{GameID: response.GameID})
+		if err != nil {
+			game.mu.Unlock()
+			return err
+		}
+
+		captured, err = GenerateCapturedPiecesMessage(response.GameID, game)
+		if err != nil {
+			game.mu.Unlock()
+			return err
+		}
+
+		if game.Clock != nil {
+			clock, err = GenerateClockMessage(response.GameID, game.Clock)
+			if err != nil {
+				game.mu.Unlock()
+				return err
+			}
+		}
+	}
+
+	game.mu.Unlock()
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(WebsocketMessage{
+		Type:    "takebackResponse",
+		Payload: string(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	SendToPlayer(response.GameID, PlayerIdForColor(game, request.By), data)
+
+	if !response.Accept {
+		return nil
+	}
+
+	err = SaveGame(response.GameID)
+	if err != nil {
+		return err
+	}
+
+	BroadcastToGame(response.GameID, answer)
+	BroadcastToGame(response.GameID, captured)
+	if clock != nil {
+		BroadcastToGame(response.GameID, clock)
+	}
+
+	// the move to play changed for both players
+	for _, player := range GamePlayerClients(response.GameID) {
+		data, err := GeneratePossibleMovesBySquareMessage(response.GameID, game, player)
+		if err != nil {
+			return err
+		}
+
+		err = player.Send(data)
+		if err != nil {
+			slog.Warn("sending message failed", "game_id", response.GameID, "client_id", player.ID, "error", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTakebackWithoutMoves(t *testing.T) {
+	resetState(t)
+
+	newTestGame(t, "game-1", CreateGameRequest{})
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+
+	alice.send("requestTakeback", TakebackRequestMessage{GameID: "game-1"})
+
+	var errorMsg ErrorMessage
+	alice.expect("error", &errorMsg)
+	if errorMsg.Code != ErrorCodeNoMoves {
+		t.Errorf("error code %q, want %q", errorMsg.Code, ErrorCodeNoMoves)
+	}
+}
+
+func TestTakebackRestoresPositionAndClock(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "game-1", CreateGameRequest{
+		Player1:          "alice",
+		Player2:          "bob",
+		PreferredColor:   ColorWhite,
+		InitialSeconds:   60,
+		IncrementSeconds: 5,
+	})
+	playMoves(t, "game-1", game, "e2e4")
+
+	game.mu.RLock()
+	before := game.Game.Position().String()
+	whiteMillis := game.Clock.WhiteMillis
+	game.mu.RUnlock()
+
+	server := newTestServer(t)
+	alice := dialPlayer(t, server, "alice")
+	bob := dialPlayer(t, server, "bob")
+
+	// black thinks for 20 seconds and then wants the move back
+	game.mu.Lock()
+	game.Clock.LastMoveAt = time.Now().Add(-20 * time.Second)
+	game.mu.Unlock()
+
+	bob.send("move", MoveMessage{GameID: "game-1", Move: "e7e5"})
+	bob.expect("moveAck")
+
+	bob.send("requestTakeback", TakebackRequestMessage{GameID: "game-1"})
+	alice.expect("takebackRequest")
+	alice.send("respondTakeback", TakebackResponseMessage{GameID: "game-1", Accept: true})
+
+	var answer MoveAnswer
+	bob.expect("move", &answer)
+	if answer.Fen != before {
+		t.Errorf("fen %q after the takeback, want %q", answer.Fen, before)
+	}
+	if answer.Move != "" || answer.Ply != 1 || answer.LastMoveUci != "e2e4" {
+		t.Errorf("answer %+v after the takeback, want no move at ply 1 after e2e4", answer)
+	}
+
+	var clock ClockMessage
+	bob.expect("clock", &clock)
+	if clock.WhiteMillis != whiteMillis || clock.BlackMillis != 60_000 {
+		t.Errorf("clock %d/%d after the takeback, want %d/60000", clock.WhiteMillis, clock.BlackMillis, whiteMillis)
+	}
+
+	// black is to move again, so only bob gets moves to pick from
+	var possible PossibleMovesBySquareAnswer
+	bob.expect("possibleMovesBySquare", &possible)
+	if len(possible.Moves["e7"]) != 2 {
+		t.Errorf("bob can move %v from e7, want 2 moves", possible.Moves["e7"])
+	}
+
+	var none PossibleMovesBySquareAnswer
+	alice.expect("possibleMovesBySquare", &none)
+	if len(none.Moves) != 0 {
+		t.Errorf("alice got moves %v while black is to move", none.Moves)
+	}
+
+	game.mu.RLock()
+	defer game.mu.RUnlock()
+	if game.Clock.WhiteMoves != 1 || game.Clock.BlackMoves != 0 {
+		t.Errorf("clock counted %d white and %d black moves, want 1 and 0", game.Clock.WhiteMoves, game.Clock.BlackMoves)
+	}
+	if len(game.Clock.History) != 1 {
+		t.Errorf("clock has %d snapshots, want 1", len(game.Clock.History))
+	}
+}
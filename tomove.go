@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+type ToMoveEntry struct {
+	GameID string `json:"gameId"`
+	Color  string `json:"color"`
+	// Deadline is when the player's flag falls. It is omitted for
+	// untimed games and clocks that have not started yet.
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// MoveDeadline returns when the side to move runs out of time.
+func MoveDeadline(game *Game) (time.Time, bool) {
+	if game.Clock == nil {
+		return time.Time{}, false
+	}
+
+	turn := game.Game.Position().Turn()
+	if !game.Clock.Started(turn) || WaitingForReady(game) {
+		return time.Time{}, false
+	}
+
+	remaining := time.Duration(game.Clock.Remaining(turn)) * time.Millisecond
+
+	return game.Clock.LastMoveAt.Add(remaining), true
+}
+
+// GamesAwaitingMove lists the unfinished games in which it is the player's
+// turn, soonest deadline first. Games without a deadline come last.
+func GamesAwaitingMove(playerID string) []ToMoveEntry {
+	entries := make([]ToMoveEntry, 0)
+
+	ForEachGame(func(id string, record GameRecord) {
+		if record.Outcome != chess.NoOutcome {
+			return
+		}
+
+		toMove := record.WhitePlayerId
+		if record.Turn == chess.Black {
+			toMove = record.BlackPlayerId
+		}
+
+		if playerID == "" || toMove != playerID {
+			return
+		}
+
+		entries = append(entries, ToMoveEntry{
+			GameID:   id,
+			Color:    ColorCode(record.Turn),
+			Deadline: record.Deadline,
+		})
+	})
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Deadline, entries[j].Deadline
+		if a == nil || b == nil {
+			if a == nil && b == nil {
+				return entries[i].GameID < entries[j].GameID
+			}
+			return b == nil
+		}
+
+		return a.Before(*b)
+	})
+
+	return entries
+}
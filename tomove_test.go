@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGamesAwaitingMoveOrderedByDeadline(t *testing.T) {
+	resetState(t)
+
+	// alice is to move in all of these except "waiting" and "finished"
+	newTestGame(t, "untimed", CreateGameRequest{})
+
+	newTestGame(t, "slow", CreateGameRequest{
+		Player1:        "alice",
+		Player2:        "carol",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 600},
+		BlackClock:     &ClockSettings{BaseSeconds: 600},
+	})
+
+	fast := newTestGame(t, "fast", CreateGameRequest{
+		Player1:        "dave",
+		Player2:        "alice",
+		PreferredColor: ColorWhite,
+		WhiteClock:     &ClockSettings{BaseSeconds: 60},
+		BlackClock:     &ClockSettings{BaseSeconds: 60},
+	})
+	playMoves(t, "fast", fast, "e2e4")
+
+	waiting := newTestGame(t, "waiting", CreateGameRequest{Player1: "alice", Player2: "erin", PreferredColor: ColorWhite})
+	playMoves(t, "waiting", waiting, "e2e4")
+
+	finished := newTestGame(t, "finished", CreateGameRequest{Player1: "frank", Player2: "alice", PreferredColor: ColorWhite})
+	playMoves(t, "finished", finished, "f2f3", "e7e5", "g2g4", "d8h4")
+
+	recorder := doRequest(t, "GET", "/players/alice/to-move", nil)
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+
+	var entries []ToMoveEntry
+	decodeJSON(t, recorder, &entries)
+
+	want := []struct {
+		id    string
+		color string
+	}{
+		{"fast", ColorBlack},
+		{"slow", ColorWhite},
+		{"untimed", ColorWhite},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("entries %+v, want %v", entries, want)
+	}
+	for i, entry := range entries {
+		if entry.GameID != want[i].id || entry.Color != want[i].color {
+			t.Errorf("entry %d is %s as %s, want %s as %s", i, entry.GameID, entry.Color, want[i].id, want[i].color)
+		}
+	}
+
+	if entries[0].Deadline == nil || entries[2].Deadline != nil {
+		t.Errorf("deadlines %v and %v, want one for the timed game only", entries[0].Deadline, entries[2].Deadline)
+	}
+}
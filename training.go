@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+const maxTrainingAttempts = 1000
+
+var trainingPieceTypes = map[rune]chess.PieceType{
+	'K': chess.King,
+	'Q': chess.Queen,
+	'R': chess.Rook,
+	'B': chess.Bishop,
+	'N': chess.Knight,
+	'P': chess.Pawn,
+}
+
+// ParseTrainingCategory parses a material category like "KQ vs K" or
+// "KRvK" into the piece types for white and black.
+func ParseTrainingCategory(category string) ([]chess.PieceType, []chess.PieceType, error) {
+	normalized := strings.ReplaceAll(category, " ", "")
+	normalized = strings.Replace(normalized, "vs", "v", 1)
+
+	sides := strings.Split(normalized, "v")
+	if len(sides) != 2 {
+		return nil, nil, errors.New("Invalid training category")
+	}
+
+	pieces := make([][]chess.PieceType, 2)
+	for i, side := range sides {
+		kings := 0
+
+		for _, char := range strings.ToUpper(side) {
+			pieceType, ok := trainingPieceTypes[char]
+			if !ok {
+				return nil, nil, errors.New("Invalid training category")
+			}
+
+			if pieceType == chess.King {
+				kings++
+			}
+
+			pieces[i] = append(pieces[i], pieceType)
+		}
+
+		if kings != 1 {
+			return nil, nil, errors.New("Each side needs exactly one king")
+		}
+	}
+
+	return pieces[0], pieces[1], nil
+}
+
+func placeRandomPiece(squares map[chess.Square]chess.Piece, piece chess.Piece) {
+	for {
+		sq := chess.Square(rand.IntN(64))
+
+		if _, ok := squares[sq]; ok {
+			continue
+		}
+
+		if piece.Type() == chess.Pawn && (sq.Rank() == chess.Rank1 || sq.Rank() == chess.Rank8) {
+			continue
+		}
+
+		squares[sq] = piece
+		return
+	}
+}
+
+// GenerateTrainingFEN places the pieces of the given category on random
+// squares and returns a legal FEN with white to move where neither king
+// is in check.
+func GenerateTrainingFEN(category string) (string, error) {
+	white, black, err := ParseTrainingCategory(category)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; attempt < maxTrainingAttempts; attempt++ {
+		squares := make(map[chess.Square]chess.Piece)
+
+		for _, pieceType := range white {
+			placeRandomPiece(squares, chess.NewPiece(pieceType, chess.White))
+		}
+
+		for _, pieceType := range black {
+			placeRandomPiece(squares, chess.NewPiece(pieceType, chess.Black))
+		}
+
+		board := chess.NewBoard(squares)
+		if IsInCheck(board, chess.White) || IsInCheck(board, chess.Black) {
+			continue
+		}
+
+		fenStr := board.String() + " w - - 0 1"
+		fen, err := chess.FEN(fenStr)
+		if err != nil {
+			continue
+		}
+
+		game := chess.NewGame(fen)
+		if len(game.ValidMoves()) == 0 {
+			continue
+		}
+
+		return fenStr, nil
+	}
+
+	return "", errors.New("Could not generate training position")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func countPieces(board *chess.Board) map[chess.Piece]int {
+	counts := make(map[chess.Piece]int)
+	for _, piece := range board.SquareMap() {
+		counts[piece]++
+	}
+
+	return counts
+}
+
+func TestGenerateTrainingFENKQvsK(t *testing.T) {
+	// the squares are random, so check a batch of positions
+	for i := 0; i < 50; i++ {
+		fenStr, err := GenerateTrainingFEN("KQ vs K")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fen, err := chess.FEN(fenStr)
+		if err != nil {
+			t.Fatalf("invalid FEN %q: %v", fenStr, err)
+		}
+
+		game := chess.NewGame(fen)
+		pos := game.Position()
+		board := pos.Board()
+
+		want := map[chess.Piece]int{
+			chess.WhiteKing:  1,
+			chess.WhiteQueen: 1,
+			chess.BlackKing:  1,
+		}
+		counts := countPieces(board)
+		if len(counts) != len(want) {
+			t.Fatalf("%s: pieces = %v, want %v", fenStr, counts, want)
+		}
+		for piece, count := range want {
+			if counts[piece] != count {
+				t.Fatalf("%s: %d of %s, want %d", fenStr, counts[piece], piece, count)
+			}
+		}
+
+		if pos.Turn() != chess.White {
+			t.Fatalf("%s: black to move", fenStr)
+		}
+		if IsInCheck(board, chess.White) || IsInCheck(board, chess.Black) {
+			t.Fatalf("%s: a king is in check", fenStr)
+		}
+		if len(game.ValidMoves()) == 0 {
+			t.Fatalf("%s: no legal moves", fenStr)
+		}
+	}
+}
+
+func TestGenerateTrainingFENKeepsPawnsOffBackRanks(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		fenStr, err := GenerateTrainingFEN("KP v K")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fen, _ := chess.FEN(fenStr)
+		for sq, piece := range chess.NewGame(fen).Position().Board().SquareMap() {
+			if piece.Type() == chess.Pawn && (sq.Rank() == chess.Rank1 || sq.Rank() == chess.Rank8) {
+				t.Fatalf("%s: pawn on %s", fenStr, sq)
+			}
+		}
+	}
+}
+
+func TestParseTrainingCategoryRejectsInvalid(t *testing.T) {
+	for _, category := range []string{"", "KQ", "KQ vs Q", "KKQ vs K", "KX vs K"} {
+		_, _, err := ParseTrainingCategory(category)
+		if err == nil {
+			t.Errorf("ParseTrainingCategory(%q) succeeded", category)
+		}
+	}
+}
+
+func TestCreateTrainingGame(t *testing.T) {
+	resetState(t)
+
+	recorder := doRequest(t, http.MethodPost, "/game", CreateGameRequest{
+		Player1:          "alice",
+		Player2:          "bob",
+		TrainingCategory: "KR vs K",
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	var response CreateGameResponse
+	decodeJSON(t, recorder, &response)
+
+	game, ok := GetGame(response.ID)
+	if !ok {
+		t.Fatal("game not stored")
+	}
+
+	counts := countPieces(game.Game.Position().Board())
+	if counts[chess.WhiteRook] != 1 || counts[chess.WhiteKing] != 1 || counts[chess.BlackKing] != 1 || len(counts) != 3 {
+		t.Fatalf("pieces = %v, want KR vs K", counts)
+	}
+
+	recorder = doRequest(t, http.MethodPost, "/game", CreateGameRequest{
+		Player1:          "alice",
+		Player2:          "bob",
+		TrainingCategory: "KR vs",
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("invalid category: status = %d, want 400", recorder.Code)
+	}
+}
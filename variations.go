@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/notnil/chess"
+)
+
+// Variation is a side line branching off the main line after Ply half
+// moves. Moves are stored in UCI.
+type Variation struct {
+	Ply   int      `json:"ply"`
+	Moves []string `json:"moves"`
+}
+
+type AddVariationRequest struct {
+	Ply   int      `json:"ply"`
+	Moves []string `json:"moves"`
+}
+
+// MoveNode is a node of the move tree. The first child continues the
+// main line (or the variation), further children are variations.
+type MoveNode struct {
+	Ply      int         `json:"ply"`
+	Move     string      `json:"move"`
+	Children []*MoveNode `json:"children"`
+}
+
+// ValidateVariation replays the moves from the position after ply half
+// moves of the main line and returns them normalized to UCI.
+func ValidateVariation(game *Game, ply int, moves []string) ([]string, error) {
+	positions := game.Game.Positions()
+	if ply < 0 || ply >= len(positions) {
+		return nil, errors.New("Ply out of range")
+	}
+
+	if len(moves) == 0 {
+		return nil, errors.New("Variation has no moves")
+	}
+
+	fen, err := chess.FEN(positions[ply].String())
+	if err != nil {
+		return nil, err
+	}
+
+	branch := &Game{
+		Game:     chess.NewGame(fen),
+		Notation: game.Notation,
+	}
+
+	normalized := make([]string, 0, len(moves))
+	for _, moveStr := range moves {
+		m, ok := IsLegalMove(branch, moveStr)
+		if !ok {
+			return nil, ErrInvalidMove
+		}
+
+		err := branch.Game.Move(m)
+		if err != nil {
+			return nil, err
+		}
+
+		normalized = append(normalized, m.String())
+	}
+
+	return normalized, nil
+}
+
+func appendLine(parent *MoveNode, moves []string) {
+	node := parent
+	for _, moveStr := range moves {
+		child := &MoveNode{
+			Ply:      node.Ply + 1,
+			Move:     moveStr,
+			Children: make([]*MoveNode, 0),
+		}
+
+		node.Children = append(node.Children, child)
+		node = child
+	}
+}
+
+// GenerateMoveTree returns the main line as a chain from the root (the
+// start position) with every variation attached at its ply.
+func GenerateMoveTree(game *Game) *MoveNode {
+	mainLine := make([]string, 0)
+	for _, m := range game.Game.Moves() {
+		mainLine = append(mainLine, m.String())
+	}
+
+	root := &MoveNode{
+		Ply:      0,
+		Children: make([]*MoveNode, 0),
+	}
+
+	appendLine(root, mainLine)
+
+	nodes := []*MoveNode{root}
+	for node := root; len(node.Children) > 0; {
+		node = node.Children[0]
+		nodes = append(nodes, node)
+	}
+
+	for _, variation := range game.Variations {
+		if variation.Ply < len(nodes) {
+			appendLine(nodes[variation.Ply], variation.Moves)
+		}
+	}
+
+	return root
+}
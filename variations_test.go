@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// mainLine follows the first children of the tree.
+func mainLine(root *MoveNode) []*MoveNode {
+	nodes := []*MoveNode{root}
+	for node := root; len(node.Children) > 0; {
+		node = node.Children[0]
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+func TestVariationIsAttachedAtItsPly(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "analysis", CreateGameRequest{})
+	playMoves(t, "analysis", game, "e2e4", "e7e5", "g1f3")
+
+	recorder := doRequest(t, "POST", "/game/analysis/variations", AddVariationRequest{
+		Ply:   2,
+		Moves: []string{"f2f4", "e5f4"},
+	})
+	if recorder.Code != 200 {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var tree MoveNode
+	decodeJSON(t, doRequest(t, "GET", "/game/analysis/tree", nil), &tree)
+
+	nodes := mainLine(&tree)
+	if len(nodes) != 4 {
+		t.Fatalf("main line has %d nodes, want 4", len(nodes))
+	}
+
+	branchPoint := nodes[2]
+	if len(branchPoint.Children) != 2 {
+		t.Fatalf("ply 2 has %d children, want the main line and the variation", len(branchPoint.Children))
+	}
+
+	variation := branchPoint.Children[1]
+	if variation.Move != "f2f4" || variation.Ply != 3 {
+		t.Errorf("variation starts with %s at ply %d, want f2f4 at 3", variation.Move, variation.Ply)
+	}
+	if len(variation.Children) != 1 || variation.Children[0].Move != "e5f4" {
+		t.Errorf("variation continues with %+v, want e5f4", variation.Children)
+	}
+}
+
+func TestVariationRejectsIllegalMoves(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "analysis", CreateGameRequest{})
+	playMoves(t, "analysis", game, "e2e4")
+
+	recorder := doRequest(t, "POST", "/game/analysis/variations", AddVariationRequest{
+		Ply:   1,
+		Moves: []string{"e2e4"},
+	})
+	if recorder.Code != 400 {
+		t.Errorf("status %d, want 400", recorder.Code)
+	}
+	if len(game.Variations) != 0 {
+		t.Errorf("%d variations stored, want 0", len(game.Variations))
+	}
+}
+
+func TestConcurrentVariationsAreAllKept(t *testing.T) {
+	resetState(t)
+
+	game := newTestGame(t, "analysis", CreateGameRequest{})
+
+	starts := []string{"a2a3", "b2b3", "c2c3", "d2d3", "e2e3", "f2f3", "g2g3", "h2h3"}
+
+	var wg sync.WaitGroup
+	for _, start := range starts {
+		wg.Add(1)
+		go func(start string) {
+			defer wg.Done()
+
+			recorder := doRequest(t, "POST", "/game/analysis/variations", AddVariationRequest{
+				Moves: []string{start},
+			})
+			if recorder.Code != 200 {
+				t.Errorf("%s: status %d", start, recorder.Code)
+			}
+		}(start)
+	}
+
+	// moves on the main line race with the variations
+	playMoves(t, "analysis", game, "e2e4", "e7e5")
+
+	wg.Wait()
+
+	game.mu.Lock()
+	count := len(game.Variations)
+	game.mu.Unlock()
+	if count != len(starts) {
+		t.Errorf("%d variations kept, want %d", count, len(starts))
+	}
+
+	var tree MoveNode
+	decodeJSON(t, doRequest(t, "GET", "/game/analysis/tree", nil), &tree)
+	if len(tree.Children) != len(starts)+1 {
+		t.Errorf("root has %d children, want %d", len(tree.Children), len(starts)+1)
+	}
+}